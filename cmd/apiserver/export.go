@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"restapi_langparser/internal/config"
+	"restapi_langparser/internal/export"
+	"restapi_langparser/internal/model"
+	"restapi_langparser/internal/store/sqlstore"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const exportUsage = `usage: apiserver export [-store sqlstore|sqlite] [-database-url url] [-format csv|jsonl|parquet] [-o file]
+	[-code request] [-content-lang lang] [-declared-lang lang] [-response-code code] [-blocker name]
+	[-error-class class] [-tld tld] [-checked-from time] [-checked-to time]`
+
+// exportDomains writes the domains of the sql store to the file or stdout
+func exportDomains(cfg *config.Config, args []string) (err error) {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	storeType := flags.String("store", string(cfg.Type), "store type, sqlstore or sqlite")
+	databaseURL := flags.String("database-url", cfg.DatabaseURL, "connection string or database file")
+	format := flags.String("format", export.CSV, "csv, jsonl or parquet")
+	output := flags.String("o", "", "output file, stdout by default")
+	code := flags.String("code", "", "export the crawled domains of the request")
+	filter := model.DomainFilter{}
+	flags.StringVar(&filter.ContentLang, "content-lang", "", "detected language of the page text")
+	flags.StringVar(&filter.DeclaredLang, "declared-lang", "", "language declared by the tags or the sitemap")
+	flags.StringVar(&filter.ResponseCode, "response-code", "", "response code of the last crawl")
+	flags.StringVar(&filter.BlockerName, "blocker", "", "name of the blocker")
+	flags.StringVar(&filter.ErrorClass, "error-class", "", "class of the last crawl error")
+	flags.StringVar(&filter.TLD, "tld", "", "top level domain")
+	checkedFrom := flags.String("checked-from", "", "crawled at or after the RFC 3339 time")
+	checkedTo := flags.String("checked-to", "", "crawled before the RFC 3339 time")
+	if err = flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return errors.New(exportUsage)
+	}
+	if _, err = export.ContentType(*format); err != nil {
+		return err
+	}
+	filter.TLD = strings.TrimPrefix(filter.TLD, ".")
+	if filter.CheckedFrom, err = flagTime("checked-from", *checkedFrom); err != nil {
+		return err
+	}
+	if filter.CheckedTo, err = flagTime("checked-to", *checkedTo); err != nil {
+		return err
+	}
+
+	var db *gorm.DB
+	switch config.StoreType(*storeType) {
+	case config.SQLStore:
+		db, err = sqlstore.Connect(*databaseURL)
+	case config.SQLiteStore:
+		db, err = sqlstore.ConnectSQLite(*databaseURL)
+	default:
+		return fmt.Errorf("store %s can not be exported", *storeType)
+	}
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		out = file
+	}
+	buf := bufio.NewWriter(out)
+	w, err := export.NewWriter(*format, buf)
+	if err != nil {
+		return err
+	}
+
+	rows := 0
+	err = sqlstore.New(db).Domain().Export(context.Background(), filter, *code, func(domain model.Domain) error {
+		rows++
+		return w.Write(domain)
+	})
+	if err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	if err = buf.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d domains\n", rows)
+	return nil
+}
+
+func flagTime(name, value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %q is not RFC 3339 time", name, value)
+	}
+	return &t, nil
+}
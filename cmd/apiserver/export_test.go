@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"restapi_langparser/internal/config"
+	"restapi_langparser/internal/export"
+	"restapi_langparser/internal/model"
+	"restapi_langparser/internal/store/sqlstore"
+	"testing"
+)
+
+func TestExportDomains(t *testing.T) {
+	dir := t.TempDir()
+	databaseURL := filepath.Join(dir, "test.db")
+	db, err := sqlstore.OpenSQLite(databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := sqlstore.New(db)
+	domains := []model.Domain{{Host: "https://a.example.com"}, {Host: "https://b.example.org"}}
+	if err = st.AddDomains(context.Background(), &domains); err != nil {
+		t.Fatal(err)
+	}
+	domains[0].ResponseCode = model.ResponseOk
+	domains[0].ContentLanguage = "en"
+	domains[0].TagsLanguages = []string{"DE", "EN"}
+	if err = st.SaveDomain(context.Background(), domains[0]); err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+
+	output := filepath.Join(dir, "domains.csv")
+	args := []string{"-store", string(config.SQLiteStore), "-database-url", databaseURL, "-o", output, "-tld", ".com"}
+	if err = exportDomains(config.New(), args); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || !reflect.DeepEqual(records[0], export.Columns) {
+		t.Fatalf("records %v, want the header and the .com domain", records)
+	}
+	row := make(map[string]string)
+	for i, column := range export.Columns {
+		row[column] = records[1][i]
+	}
+	if row["host"] != "https://a.example.com" || row["content_lang"] != "EN" || row["tags_languages"] != "DE,EN" {
+		t.Fatalf("row %v", row)
+	}
+
+	if err = exportDomains(config.New(), []string{"-format", "xml"}); err == nil {
+		t.Fatal("export to the unknown format")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"restapi_langparser/internal/config"
+	"restapi_langparser/internal/model"
+	"restapi_langparser/internal/store/sqlstore"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const keysUsage = `usage: apiserver keys [-store sqlstore|sqlite] [-database-url url]
+	create -tenant name [-scope read|submit|admin] [-name name] [-daily-hosts n] [-rate-limit n] | list | revoke id`
+
+// apiKeys issues, lists and revokes the api keys of the sql store, the first
+// admin key is issued by it
+func apiKeys(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("keys", flag.ContinueOnError)
+	storeType := flags.String("store", string(cfg.Type), "store type, sqlstore or sqlite")
+	databaseURL := flags.String("database-url", cfg.DatabaseURL, "connection string or database file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New(keysUsage)
+	}
+
+	var db *gorm.DB
+	var err error
+	switch config.StoreType(*storeType) {
+	case config.SQLStore:
+		db, err = sqlstore.Connect(*databaseURL)
+	case config.SQLiteStore:
+		db, err = sqlstore.ConnectSQLite(*databaseURL)
+	default:
+		return fmt.Errorf("store %s has no keys", *storeType)
+	}
+	if err != nil {
+		return err
+	}
+	keys := sqlstore.New(db).APIKey()
+	ctx := context.Background()
+
+	switch flags.Arg(0) {
+	case "create":
+		create := flag.NewFlagSet("create", flag.ContinueOnError)
+		tenant := create.String("tenant", "", "tenant which owns the requests of the key")
+		scope := create.String("scope", model.ScopeSubmit, "read, submit or admin")
+		name := create.String("name", "", "name of the key")
+		dailyHosts := create.Int("daily-hosts", 0, "hosts submitted per UTC day, 0 is unlimited")
+		rateLimit := create.Int("rate-limit", 0, "api calls per minute, 0 is unlimited")
+		if err = create.Parse(flags.Args()[1:]); err != nil {
+			return err
+		}
+		key, secret, err := model.NewAPIKey(*tenant, *scope)
+		if err != nil {
+			return err
+		}
+		key.Name = *name
+		key.DailyHosts = *dailyHosts
+		key.RateLimit = *rateLimit
+		if err = keys.Create(ctx, key); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "key %d of tenant %s issued, store the secret, it is not shown again\n", key.ID, key.Tenant)
+		fmt.Println(secret)
+	case "list":
+		list, err := keys.Read(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tPREFIX\tTENANT\tSCOPE\tNAME\tDAILY HOSTS\tRATE LIMIT\tCREATED\tREVOKED")
+		for _, key := range list {
+			revoked := ""
+			if key.RevokedAt != nil {
+				revoked = key.RevokedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n", key.ID, key.Prefix, key.Tenant, key.Scope, key.Name,
+				key.DailyHosts, key.RateLimit, key.CreatedAt.Format(time.RFC3339), revoked)
+		}
+		return w.Flush()
+	case "revoke":
+		if flags.NArg() != 2 {
+			return errors.New(keysUsage)
+		}
+		id, err := strconv.ParseUint(flags.Arg(1), 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid key id %q", flags.Arg(1))
+		}
+		if err = keys.Revoke(ctx, uint(id), time.Now()); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "key %d revoked\n", id)
+	default:
+		return errors.New(keysUsage)
+	}
+	return nil
+}
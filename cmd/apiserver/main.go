@@ -1,15 +1,53 @@
 package main
 
 import (
+	"flag"
 	"log"
+	"os"
 	"restapi_langparser/internal/apiserver"
 	"restapi_langparser/internal/config"
+	"strconv"
 )
 
 func main() {
 	cfg := config.New()
-	//cfg.Type = config.MemStore
-	cfg.DatabaseURL = "user=kai dbname=postgres password=hitomi sslmode=disable"
+	if value := os.Getenv("STORE"); value != "" {
+		cfg.Type = config.StoreType(value)
+	}
+	cfg.DatabaseURL = os.Getenv("DATABASE_URL")
+	if value := os.Getenv("REQUIRE_API_KEY"); value != "" {
+		require, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Fatalf("invalid REQUIRE_API_KEY %q", value)
+		}
+		cfg.RequireAPIKey = require
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate(cfg, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		if err := apiKeys(cfg, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := exportDomains(cfg, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	flag.StringVar((*string)(&cfg.Type), "store", string(cfg.Type), "store type, memstore, sqlstore or sqlite, STORE by default")
+	flag.StringVar(&cfg.DatabaseURL, "database-url", cfg.DatabaseURL, "connection string or database file, DATABASE_URL by default")
+	flag.StringVar(&cfg.BindAddr, "bind-addr", cfg.BindAddr, "address of the api")
+	flag.BoolVar(&cfg.RequireAPIKey, "require-api-key", cfg.RequireAPIKey, "clients authenticate with the api keys, false opens the api for development, REQUIRE_API_KEY by default")
+	flag.Parse()
+
 	if err := apiserver.Start(cfg); err != nil {
 		log.Println(err)
 	}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"restapi_langparser/internal/config"
+	"restapi_langparser/internal/store/sqlstore"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+const migrateUsage = `usage: apiserver migrate [-store sqlstore|sqlite] [-database-url url] up [version] | down [steps] | status`
+
+// migrate applies or rolls back the schema migrations of the sql store
+func migrate(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	storeType := flags.String("store", string(cfg.Type), "store type, sqlstore or sqlite")
+	databaseURL := flags.String("database-url", cfg.DatabaseURL, "connection string or database file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 || flags.NArg() > 2 {
+		return errors.New(migrateUsage)
+	}
+	command := flags.Arg(0)
+	arg := 0
+	if flags.NArg() == 2 {
+		var err error
+		if arg, err = strconv.Atoi(flags.Arg(1)); err != nil || arg < 0 {
+			return errors.New(migrateUsage)
+		}
+	}
+
+	var db *gorm.DB
+	var err error
+	switch config.StoreType(*storeType) {
+	case config.SQLStore:
+		db, err = sqlstore.Connect(*databaseURL)
+	case config.SQLiteStore:
+		db, err = sqlstore.ConnectSQLite(*databaseURL)
+	default:
+		return fmt.Errorf("store %s has no schema", *storeType)
+	}
+	if err != nil {
+		return err
+	}
+
+	m, err := sqlstore.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	switch command {
+	case "up":
+		err = m.Up(ctx, arg)
+	case "down":
+		if arg == 0 {
+			arg = 1
+		}
+		err = m.Down(ctx, arg)
+	case "status":
+	default:
+		return errors.New(migrateUsage)
+	}
+	if err != nil {
+		return err
+	}
+
+	version, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "schema version %d, latest %d\n", version, m.Latest())
+	return nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"restapi_langparser/internal/config"
+	"restapi_langparser/internal/langworker"
+)
+
+func main() {
+	cfg := config.New()
+	flag.StringVar(&cfg.DatabaseURL, "database-url", os.Getenv("DATABASE_URL"), "connection string of the store shared with the api server")
+	flag.StringVar(&cfg.WorkerID, "worker-id", cfg.WorkerID, "unique name of the worker")
+	flag.UintVar(&cfg.MaxThreads, "threads", cfg.MaxThreads, "max domains crawled at once")
+	flag.UintVar(&cfg.ThreadsPerProxy, "threads-per-proxy", cfg.ThreadsPerProxy, "max domains crawled at once through one proxy")
+	flag.BoolVar(&cfg.UseIP, "use-ip", cfg.UseIP, "crawl without proxy too")
+	flag.Parse()
+
+	if err := langworker.Start(cfg); err != nil {
+		log.Println(err)
+	}
+}
@@ -1,13 +1,18 @@
 package apiserver
 
 import (
-	"errors"
+	"context"
+	"fmt"
+	"os/signal"
 	"restapi_langparser/internal/config"
+	"restapi_langparser/internal/langfinder"
 	"restapi_langparser/internal/model"
+	"restapi_langparser/internal/store"
+	"restapi_langparser/internal/store/memstore"
 	"restapi_langparser/internal/store/sqlstore"
+	"syscall"
 
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
+	"github.com/sirupsen/logrus"
 )
 
 func Start(cfg *config.Config) error {
@@ -15,31 +20,66 @@ func Start(cfg *config.Config) error {
 
 	switch cfg.Type {
 	case config.MemStore:
-		//srv = newServer(memstore.New(), cfg)
+		srv = newServer(memstore.New(), cfg)
+		if cfg.RequireAPIKey {
+			secret, err := issueAdminKey(context.Background(), srv.store)
+			if err != nil {
+				return err
+			}
+			logrus.Warn("Admin api key of the memory store issued, it is lost on restart")
+			fmt.Println(secret)
+		}
 	case config.SQLStore:
-		db, err := newDBConnection(cfg.DatabaseURL)
+		db, err := sqlstore.Open(cfg.DatabaseURL)
+		if err != nil {
+			return err
+		}
+		srv = newServer(sqlstore.New(db), cfg)
+	case config.SQLiteStore:
+		db, err := sqlstore.OpenSQLite(cfg.DatabaseURL)
 		if err != nil {
 			return err
 		}
 		srv = newServer(sqlstore.New(db), cfg)
 	default:
-		return errors.New("store type incorrect")
+		return fmt.Errorf("store type %q incorrect, memstore, sqlstore or sqlite", cfg.Type)
 	}
 
-	return srv.Start(cfg.BindAddr)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.Type == config.SQLiteStore || cfg.Type == config.MemStore {
+		return startSingleNode(ctx, srv, cfg)
+	}
+	return srv.Start(ctx, cfg.BindAddr)
 }
 
-func newDBConnection(databaseURL string) (*gorm.DB, error) {
-	if databaseURL == "" {
-		databaseURL = "user=postgres dbname=postgres password=password sslmode=disable"
+// startSingleNode crawls the queue in the api server process, the memory store
+// and the SQLite database can not be shared with workers on other hosts
+func startSingleNode(ctx context.Context, srv *server, cfg *config.Config) error {
+	finder := langfinder.New(ctx, srv.store, cfg)
+	finder.Start(ctx)
+
+	err := srv.Start(ctx, cfg.BindAddr)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if shutdownErr := finder.Shutdown(shutdownCtx); shutdownErr != nil {
+		logrus.Errorf("Drain fail: %s, unfinished domains are returned to the queue", shutdownErr)
 	}
-	db, err := gorm.Open(postgres.New(postgres.Config{
-		DSN:                  databaseURL,
-		PreferSimpleProtocol: true, // disables implicit prepared statement usage
-	}), &gorm.Config{})
+	return err
+}
+
+// issueAdminKey issues the first admin key, the keys subcommand can not reach
+// the memory store of the running server
+func issueAdminKey(ctx context.Context, st store.IStore) (string, error) {
+	key, secret, err := model.NewAPIKey("admin", model.ScopeAdmin)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	key.Name = "bootstrap"
+	if err = st.APIKey().Create(ctx, key); err != nil {
+		return "", err
 	}
-	err = db.AutoMigrate(&model.Domain{}, &model.Proxy{}, &model.Request{}, &model.Queue{})
-	return db, err
+	return secret, nil
 }
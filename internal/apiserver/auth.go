@@ -0,0 +1,218 @@
+package apiserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"restapi_langparser/internal/apistructs"
+	"restapi_langparser/internal/model"
+	"restapi_langparser/internal/store"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// apiKeyCtx is the gin context key of the authenticated api key
+const apiKeyCtx = "apiKey"
+
+// errQuota is returned when the hosts are over the daily quota of the key, the
+// store returns it when the ingested batch is over the quota
+var errQuota = store.ErrQuotaExceeded
+
+// authenticate finds the api key of the request by the bearer token or the
+// X-API-Key header and applies its rate limit. Nothing is checked when the
+// keys are not required.
+func (s *server) authenticate(c *gin.Context) {
+	if !s.config.RequireAPIKey {
+		return
+	}
+	resp := &apistructs.APIResponse{}
+
+	secret := c.GetHeader("X-API-Key")
+	if auth := c.GetHeader("Authorization"); secret == "" && strings.HasPrefix(auth, "Bearer ") {
+		secret = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	if secret == "" {
+		c.Header("WWW-Authenticate", "Bearer")
+		resp.CreateError(http.StatusUnauthorized, "api key required")
+		c.AbortWithStatusJSON(resp.Status, resp)
+		return
+	}
+	key, err := s.store.APIKey().FindByHash(c.Request.Context(), model.HashAPIKey(secret))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.Header("WWW-Authenticate", "Bearer")
+		resp.CreateError(http.StatusUnauthorized, "invalid api key")
+		c.AbortWithStatusJSON(resp.Status, resp)
+		return
+	}
+	if err != nil {
+		fail(resp, err)
+		c.AbortWithStatusJSON(resp.Status, resp)
+		return
+	}
+
+	if ok, retry := s.limiter.allow(key.ID, key.RateLimit, time.Now()); !ok {
+		c.Header("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+		resp.CreateError(http.StatusTooManyRequests, "rate limit of %d calls per minute exceeded", key.RateLimit)
+		c.AbortWithStatusJSON(resp.Status, resp)
+		return
+	}
+	c.Set(apiKeyCtx, key)
+}
+
+// require answers 403 when the key does not have the scope
+func require(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key := apiKeyOf(c); key != nil && !key.Allows(scope) {
+			resp := &apistructs.APIResponse{}
+			resp.CreateError(http.StatusForbidden, "api key of scope %s can not %s %s, %s scope is required",
+				key.Scope, c.Request.Method, c.FullPath(), scope)
+			c.AbortWithStatusJSON(resp.Status, resp)
+		}
+	}
+}
+
+// apiKeyOf returns the key of the request, nil when the keys are not required
+func apiKeyOf(c *gin.Context) *model.APIKey {
+	if value, ok := c.Get(apiKeyCtx); ok {
+		return value.(*model.APIKey)
+	}
+	return nil
+}
+
+// tenantOf returns the tenant which owns the requests and the jobs created by the request
+func tenantOf(c *gin.Context) string {
+	if key := apiKeyOf(c); key != nil {
+		return key.Tenant
+	}
+	return ""
+}
+
+// owns reports whether the request may see the resource of the owner, the
+// admin keys see the resources of all tenants
+func owns(c *gin.Context, owner string) bool {
+	key := apiKeyOf(c)
+	return key == nil || key.Allows(model.ScopeAdmin) || key.Tenant == owner
+}
+
+// useQuota counts the submitted hosts against the daily quota of the key
+func (s *server) useQuota(c *gin.Context, hosts int) error {
+	key := apiKeyOf(c)
+	if key == nil || key.DailyHosts == 0 || hosts == 0 {
+		return nil
+	}
+	ok, err := s.store.APIKey().AddUsage(c.Request.Context(), key.ID, model.UsageDay(time.Now()), hosts, key.DailyHosts)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: %d hosts are over the quota of %d hosts per day", errQuota, hosts, key.DailyHosts)
+	}
+	return nil
+}
+
+// quotaLeft returns the hosts which the key may submit today, -1 is unlimited
+func (s *server) quotaLeft(c *gin.Context) (int, error) {
+	key := apiKeyOf(c)
+	if key == nil || key.DailyHosts == 0 {
+		return -1, nil
+	}
+	used, err := s.store.APIKey().Usage(c.Request.Context(), key.ID, model.UsageDay(time.Now()))
+	if err != nil || used >= key.DailyHosts {
+		return 0, err
+	}
+	return key.DailyHosts - used, nil
+}
+
+// rateLimiter counts the calls of the keys in the fixed minute windows. The
+// counts are kept by the process, so every api server applies the limit.
+type rateLimiter struct {
+	m      sync.Mutex
+	window time.Time
+	calls  map[uint]int
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{calls: make(map[uint]int)}
+}
+
+// allow counts the call of the key, it returns false with the time to the
+// next window when the limit is reached. The limit 0 is unlimited.
+func (l *rateLimiter) allow(id uint, limit int, now time.Time) (bool, time.Duration) {
+	if limit == 0 {
+		return true, 0
+	}
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if window := now.Truncate(time.Minute); !window.Equal(l.window) {
+		l.window = window
+		l.calls = make(map[uint]int)
+	}
+	if l.calls[id] >= limit {
+		return false, l.window.Add(time.Minute).Sub(now)
+	}
+	l.calls[id]++
+	return true, 0
+}
+
+// handleGetAPIKeys lists the keys of all tenants
+func (s *server) handleGetAPIKeys(c *gin.Context) {
+	resp, writeResp := newResp(c)
+	defer writeResp()
+
+	keys, err := s.store.APIKey().Read(c.Request.Context())
+	if err != nil {
+		fail(resp, err)
+		return
+	}
+	resp.Results = &apistructs.APIResults{APIKeys: keys}
+}
+
+// handleAddAPIKey issues the key, its secret is returned only by this response
+func (s *server) handleAddAPIKey(c *gin.Context) {
+	resp, writeResp := newResp(c)
+	defer writeResp()
+
+	var req apistructs.APIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		resp.CreateError(http.StatusBadRequest, "invalid request body: %s", err)
+		return
+	}
+	key, secret, err := model.NewAPIKey(req.Tenant, req.Scope)
+	if err != nil {
+		fail(resp, err)
+		return
+	}
+	key.Name = req.Name
+	key.DailyHosts = req.DailyHosts
+	key.RateLimit = req.RateLimit
+	if err = s.store.APIKey().Create(c.Request.Context(), key); err != nil {
+		fail(resp, err)
+		return
+	}
+	resp.Status = http.StatusCreated
+	resp.Results = &apistructs.APIResults{APIKeys: []model.APIKey{*key}, Secret: secret}
+	resp.CreateMessage("Key %s issued, store the secret, it is not shown again", key.Prefix)
+}
+
+// handleRevokeAPIKey disables the key at once
+func (s *server) handleRevokeAPIKey(c *gin.Context) {
+	resp, writeResp := newResp(c)
+	defer writeResp()
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		fail(resp, invalid("invalid key id %q", c.Param("id")))
+		return
+	}
+	if err = s.store.APIKey().Revoke(c.Request.Context(), uint(id), time.Now()); err != nil {
+		fail(resp, err)
+		return
+	}
+	resp.CreateMessage("Key %d revoked", id)
+}
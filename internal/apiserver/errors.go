@@ -0,0 +1,108 @@
+package apiserver
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"restapi_langparser/internal/apistructs"
+	"restapi_langparser/internal/model"
+	"restapi_langparser/internal/store"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// errUnavailable is returned when the request can not be served for a while
+var errUnavailable = errors.New("server is shutting down")
+
+// badRequest is the mistake of the client which is not caught by the store
+type badRequest struct {
+	err error
+}
+
+func (e badRequest) Error() string {
+	return e.err.Error()
+}
+
+func (e badRequest) Unwrap() error {
+	return e.err
+}
+
+// invalid returns the bad request error
+func invalid(format string, args ...interface{}) error {
+	return badRequest{err: fmt.Errorf(format, args...)}
+}
+
+// fail sets the error of the response with the status of its kind, the
+// validation errors are detailed per field
+func fail(resp *apistructs.APIResponse, err error) {
+	status := errorStatus(err)
+	resp.CreateError(status, "%s", err)
+	if status == http.StatusInternalServerError {
+		logrus.Errorf("Request fail: %s", err)
+	}
+
+	var fields validation.Errors
+	if errors.As(err, &fields) {
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			resp.AddDetail(name, "", fields[name].Error())
+		}
+	}
+}
+
+// errorStatus returns the HTTP status of the error
+func errorStatus(err error) int {
+	var fields validation.Errors
+	var netErr net.Error
+	switch {
+	case errors.As(err, &badRequest{}), errors.Is(err, model.ErrInvalidCursor):
+		return http.StatusBadRequest
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, model.ErrRequestFinished), errors.Is(err, store.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, model.ErrRequestExpired):
+		return http.StatusGone
+	case errors.Is(err, errQuota):
+		return http.StatusTooManyRequests
+	case errors.As(err, &fields):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, errUnavailable), errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, driver.ErrBadConn), errors.As(err, &netErr):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// handleNoRoute answers the unknown path
+func (s *server) handleNoRoute(c *gin.Context) {
+	resp, writeResp := newResp(c)
+	defer writeResp()
+	resp.CreateError(http.StatusNotFound, "no route %s %s", c.Request.Method, c.Request.URL.Path)
+}
+
+// handleNoMethod answers the method which is not served by the path
+func (s *server) handleNoMethod(c *gin.Context) {
+	resp, writeResp := newResp(c)
+	defer writeResp()
+	resp.CreateError(http.StatusMethodNotAllowed, "method %s is not allowed for %s", c.Request.Method, c.Request.URL.Path)
+}
+
+// recovery answers the panic of the handler with the internal error
+func recovery(c *gin.Context, recovered interface{}) {
+	logrus.Errorf("Handler panic: %v", recovered)
+	resp := &apistructs.APIResponse{}
+	resp.CreateError(http.StatusInternalServerError, "internal error")
+	c.AbortWithStatusJSON(resp.Status, resp)
+}
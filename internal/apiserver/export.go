@@ -0,0 +1,70 @@
+package apiserver
+
+import (
+	"fmt"
+	"net/http"
+	"restapi_langparser/internal/apistructs"
+	"restapi_langparser/internal/export"
+	"restapi_langparser/internal/model"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// handleExport streams the domains matching the search filters, or the crawled
+// domains of the request code, as the file of the format. The status is sent
+// with the first rows, so a failure in the middle only cuts the file.
+func (s *server) handleExport(c *gin.Context) {
+	resp := &apistructs.APIResponse{}
+	failed := func(err error) {
+		fail(resp, err)
+		c.JSON(resp.Status, resp)
+	}
+
+	format := c.DefaultQuery("format", export.CSV)
+	contentType, err := export.ContentType(format)
+	if err != nil {
+		failed(badRequest{err})
+		return
+	}
+	filter, err := domainFilter(c)
+	if err != nil {
+		failed(badRequest{err})
+		return
+	}
+
+	ctx := c.Request.Context()
+	name := "domains"
+	code := c.Query("code")
+	if code != "" {
+		request, err := s.store.GetRequestHeader(ctx, code)
+		if err == nil && !owns(c, request.Owner) {
+			err = gorm.ErrRecordNotFound
+		}
+		if err == nil && request.Expired(s.config.RequestTTL, time.Now()) {
+			err = model.ErrRequestExpired
+		}
+		if err != nil {
+			failed(err)
+			return
+		}
+		name = "request-" + code
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+format))
+	c.Status(http.StatusOK)
+	w, err := export.NewWriter(format, c.Writer)
+	if err == nil {
+		err = s.store.Domain().Export(ctx, filter, code, w.Write)
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		logrus.Errorf("Export %s fail: %s", name, err)
+		c.Abort()
+	}
+}
@@ -0,0 +1,283 @@
+package apiserver
+
+import (
+	"net/http"
+	"reflect"
+	"restapi_langparser/internal/apistructs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	apiVersion = "1.0.0"
+	apiPrefix  = "/api/v1"
+	specPath   = "/openapi.json"
+)
+
+// openAPI is the OpenAPI 3 document of the v1 api
+type openAPI struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       openAPIInfo                      `json:"info"`
+	Servers    []openAPIServer                  `json:"servers"`
+	Paths      map[string]map[string]*operation `json:"paths"`
+	Components openAPIComponents                `json:"components"`
+	Security   []map[string][]string            `json:"security,omitempty"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*schema        `json:"schemas"`
+	SecuritySchemes map[string]securityScheme `json:"securitySchemes,omitempty"`
+}
+
+type securityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+type operation struct {
+	OperationID string                  `json:"operationId"`
+	Summary     string                  `json:"summary"`
+	Tags        []string                `json:"tags"`
+	Scope       string                  `json:"x-scope,omitempty"` // scope of the api key
+	Parameters  []*parameter            `json:"parameters,omitempty"`
+	RequestBody *openAPIBody            `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIBody `json:"responses"`
+}
+
+type parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *schema `json:"schema"`
+}
+
+// openAPIBody is the request body or the response
+type openAPIBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type mediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+// schema is the subset of the JSON schema used by the document and the validation
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	MinItems             int                `json:"minItems,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+}
+
+// schemas generates the schemas of the Go types by their json tags, the
+// structs are the components referenced by name
+type schemas map[string]*schema
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g schemas) of(t reflect.Type) *schema {
+	switch {
+	case t == timeType:
+		return &schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Ptr:
+		s := *g.of(t.Elem())
+		if s.Ref == "" {
+			s.Nullable = true
+		}
+		return &s
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if _, ok := g[t.Name()]; !ok {
+			g[t.Name()] = nil // the recursive types refer to the schema in progress
+			g[t.Name()] = g.object(t, nil)
+		}
+		return &schema{Ref: "#/components/schemas/" + t.Name()}
+	case reflect.Slice, reflect.Array:
+		return &schema{Type: "array", Items: g.of(t.Elem())}
+	case reflect.Map:
+		return &schema{Type: "object"}
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &schema{Type: "number"}
+	case reflect.String:
+		return &schema{Type: "string"}
+	}
+	return &schema{}
+}
+
+// object returns the inline schema of the struct fields, only the given
+// properties if there are any. The fields of the embedded structs are
+// inlined as encoding/json does.
+func (g schemas) object(t reflect.Type, only []string) *schema {
+	s := &schema{Type: "object", Properties: map[string]*schema{}}
+	var fields func(t reflect.Type)
+	fields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" || field.PkgPath != "" && !field.Anonymous {
+				continue
+			}
+			name, opts := tag, ""
+			if sep := strings.IndexByte(tag, ','); sep >= 0 {
+				name, opts = tag[:sep], tag[sep+1:]
+			}
+			if field.Anonymous && name == "" {
+				embedded := field.Type
+				if embedded.Kind() == reflect.Ptr {
+					embedded = embedded.Elem()
+				}
+				fields(embedded)
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			if len(only) > 0 && !contains(only, name) {
+				continue
+			}
+			s.Properties[name] = g.of(field.Type)
+			if len(only) == 0 && !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Ptr {
+				s.Required = append(s.Required, name)
+			}
+		}
+	}
+	fields(t)
+	sort.Strings(s.Required)
+	return s
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// newOpenAPI builds the document of the routes, with requireKey the
+// operations are secured by the api keys
+func newOpenAPI(routes []route, requireKey bool) *openAPI {
+	g := schemas{}
+	response := g.of(reflect.TypeOf(apistructs.APIResponse{}))
+	errorResponse := &openAPIBody{
+		Description: "error, see the code of the Error",
+		Content:     map[string]mediaType{"application/json": {Schema: response}},
+	}
+
+	doc := &openAPI{
+		OpenAPI: "3.0.3",
+		Info: openAPIInfo{
+			Title:       "Language parser API",
+			Description: "Finds the languages of the web sites. Errors answer with the Error object of the response.",
+			Version:     apiVersion,
+		},
+		Servers: []openAPIServer{{URL: apiPrefix}},
+		Paths:   map[string]map[string]*operation{},
+	}
+	for i := range routes {
+		r := &routes[i]
+		path := openAPIPath(r.path)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]*operation{}
+		}
+
+		op := &operation{
+			OperationID: r.id,
+			Summary:     r.summary,
+			Tags:        []string{r.tag},
+			Scope:       r.scope,
+			Parameters:  r.parameters(),
+			Responses:   map[string]*openAPIBody{"default": errorResponse},
+		}
+		status := r.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		ok := &openAPIBody{Description: http.StatusText(status), Content: map[string]mediaType{}}
+		if len(r.produces) == 0 {
+			ok.Content["application/json"] = mediaType{Schema: response}
+		}
+		for _, media := range r.produces {
+			ok.Content[media] = mediaType{Schema: &schema{Type: "string", Format: "binary"}}
+		}
+		op.Responses[strconv.Itoa(status)] = ok
+
+		if r.body != nil {
+			r.bodySchema = g.object(reflect.TypeOf(r.body), r.fields)
+			r.bodySchema.Required = r.required
+			closed := false
+			r.bodySchema.AdditionalProperties = &closed
+			op.RequestBody = &openAPIBody{
+				Required: !r.optional,
+				Content:  map[string]mediaType{"application/json": {Schema: r.bodySchema}},
+			}
+		}
+		if len(r.consumes) > 0 {
+			op.RequestBody = &openAPIBody{Required: true, Content: map[string]mediaType{}}
+			for _, media := range r.consumes {
+				op.RequestBody.Content[media] = mediaType{Schema: &schema{Type: "string", Format: "binary"}}
+			}
+		}
+		doc.Paths[path][strings.ToLower(r.method)] = op
+	}
+	doc.Components.Schemas = g
+	if requireKey {
+		doc.Components.SecuritySchemes = map[string]securityScheme{"apiKey": {
+			Type:        "http",
+			Scheme:      "bearer",
+			Description: "api key of the tenant, the x-scope of the operation is required. The X-API-Key header is accepted too.",
+		}}
+		doc.Security = []map[string][]string{{"apiKey": {}}}
+	}
+	return doc
+}
+
+// openAPIPath converts the gin path parameters to the OpenAPI templates
+func openAPIPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// handleOpenAPI serves the OpenAPI document of the v1 api
+func (s *server) handleOpenAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, s.spec)
+}
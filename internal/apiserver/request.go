@@ -0,0 +1,91 @@
+package apiserver
+
+import (
+	"net/http"
+	"restapi_langparser/internal/apistructs"
+	"restapi_langparser/internal/model"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// handleGetRequest returns the status and the progress of the request with the page of its crawled domains
+func (s *server) handleGetRequest(c *gin.Context) {
+	resp, writeResp := newResp(c)
+	defer writeResp()
+
+	page, err := s.pageOf(c)
+	if err != nil {
+		resp.CreateError(http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	results, err := s.requestResults(c, c.Param("code"), page)
+	if err != nil {
+		fail(resp, err)
+		return
+	}
+	resp.Results = results
+}
+
+// handleCancelRequest stops the pending request, its domains which are not
+// crawled yet are removed from the queue unless another request needs them
+func (s *server) handleCancelRequest(c *gin.Context) {
+	resp, writeResp := newResp(c)
+	defer writeResp()
+
+	ctx := c.Request.Context()
+	code := c.Param("code")
+	request, err := s.store.GetRequestHeader(ctx, code)
+	if err == nil && !owns(c, request.Owner) {
+		err = gorm.ErrRecordNotFound
+	}
+	if err == nil && request.Expired(s.config.RequestTTL, time.Now()) {
+		err = model.ErrRequestExpired
+	}
+	if err != nil {
+		fail(resp, err)
+		return
+	}
+
+	removed, err := s.store.StopRequest(ctx, code, model.RequestCancelled)
+	if err != nil {
+		fail(resp, err)
+		return
+	}
+	if resp.Results, err = s.requestResults(c, code, model.Page{Limit: s.config.PageSize}); err != nil {
+		fail(resp, err)
+		return
+	}
+	resp.CreateMessage("Request cancelled, domains removed from the queue: %d", removed)
+}
+
+// requestResults returns the request state and the crawled domains which are ready so far
+func (s *server) requestResults(c *gin.Context, code string, page model.Page) (*apistructs.APIResults, error) {
+	ctx := c.Request.Context()
+	request, err := s.store.GetRequestHeader(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	if !owns(c, request.Owner) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if request.Expired(s.config.RequestTTL, time.Now()) {
+		return nil, model.ErrRequestExpired
+	}
+	progress, err := s.store.GetRequestProgress(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	domains, info, err := s.store.GetRequest(ctx, code, page)
+	if err != nil {
+		return nil, err
+	}
+	state := model.NewRequestState(*request, *progress, time.Now())
+	return &apistructs.APIResults{
+		Domains:  domains,
+		Request:  &state,
+		PageInfo: info,
+	}, nil
+}
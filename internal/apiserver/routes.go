@@ -0,0 +1,292 @@
+package apiserver
+
+import (
+	"net/http"
+	"restapi_langparser/internal/apistructs"
+	"restapi_langparser/internal/export"
+	"restapi_langparser/internal/model"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// route is the operation of the v1 api. The router, the OpenAPI document and
+// the request validation are built from the same table.
+type route struct {
+	method, path string // gin path relative to apiPrefix
+	id, summary  string
+	tag          string
+	scope        string // scope of the api key
+	params       []param
+	status       int      // status of the success, 200 by default
+	produces     []string // media types of the file responses, JSON by default
+	consumes     []string // media types of the raw body
+	handler      gin.HandlerFunc
+
+	body       interface{} // zero value of the JSON body, its type makes the schema
+	fields     []string    // properties of the body, all fields of the type if empty
+	required   []string    // required properties of the body
+	optional   bool        // the body may be empty
+	bodySchema *schema     // built by newOpenAPI
+}
+
+// param is the path or the query parameter of the route
+type param struct {
+	parameter
+	time bool // RFC 3339 time or date
+}
+
+func pathParam(name, description string, s schema) param {
+	return param{parameter: parameter{Name: name, In: "path", Description: description, Required: true, Schema: &s}}
+}
+
+func queryParam(name, description string, s schema) param {
+	return param{parameter: parameter{Name: name, In: "query", Description: description, Schema: &s}}
+}
+
+func intSchema(min float64) schema {
+	return schema{Type: "integer", Minimum: &min}
+}
+
+func enumSchema(values ...string) schema {
+	return schema{Type: "string", Enum: values}
+}
+
+func timeParam(name, description string) param {
+	p := queryParam(name, description+", RFC 3339 time or date", schema{Type: "string"})
+	p.time = true
+	return p
+}
+
+func (r *route) parameters() []*parameter {
+	list := make([]*parameter, len(r.params))
+	for i := range r.params {
+		list[i] = &r.params[i].parameter
+	}
+	return list
+}
+
+var (
+	idParam    = pathParam("id", "id of the resource", intSchema(1))
+	codeParam  = pathParam("code", "code of the request", schema{Type: "string"})
+	pageParams = []param{
+		queryParam("pagesize", "rows of the page", intSchema(1)),
+		queryParam("cursor", "next_cursor of the previous page", schema{Type: "string"}),
+	}
+	budgetParams = []param{
+		queryParam("callback", "url called when the request is finished", schema{Type: "string", Format: "uri"}),
+		queryParam("max_domains", "hosts over it are skipped, 0 is unlimited", intSchema(0)),
+		timeParam("deadline", "the request is stopped after it"),
+		queryParam("max_duration", "like 2h, the earlier of it and the deadline wins", schema{Type: "string"}),
+	}
+	hardParam = queryParam("hard", "removes the rows, by default the domains are only marked deleted", schema{Type: "boolean"})
+)
+
+// filterParams are the search filters of the domains
+func filterParams() []param {
+	sortKeys := make([]string, 0, 2*len(model.DomainSortKeys))
+	for key := range model.DomainSortKeys {
+		sortKeys = append(sortKeys, key, "-"+key)
+	}
+	sort.Strings(sortKeys)
+	return []param{
+		queryParam("content_lang", "detected language of the page text", schema{Type: "string"}),
+		queryParam("declared_lang", "language declared by the tags or the sitemap", schema{Type: "string"}),
+		queryParam("response_code", "response of the last crawl", enumSchema(model.ResponseOk, model.ResponseBan, model.ResponseNotExist, model.ResponseError)),
+		queryParam("blocker", "name of the blocker", schema{Type: "string"}),
+		queryParam("error_class", "class of the last crawl error", enumSchema(model.ErrorClassRequest, model.ErrorClassDNS,
+			model.ErrorClassTimeout, model.ErrorClassTLS, model.ErrorClassConnection, model.ErrorClassHTTP, model.ErrorClassParse)),
+		queryParam("tld", "top level domain", schema{Type: "string"}),
+		timeParam("checked_from", "crawled at or after"),
+		timeParam("checked_to", "crawled before, the date is included"),
+		queryParam("sort", "sort key, - sorts in descending order", enumSchema(sortKeys...)),
+	}
+}
+
+// deleteFilterParams are the search filters without the sort, which is the last one
+func deleteFilterParams() []param {
+	list := filterParams()
+	return list[:len(list)-1]
+}
+
+func params(groups ...[]param) []param {
+	var list []param
+	for _, group := range groups {
+		list = append(list, group...)
+	}
+	return list
+}
+
+// routes returns the operations of the v1 api
+func (s *server) routes() []route {
+	return []route{
+		{method: http.MethodGet, path: "/domains", id: "searchDomains", tag: "domains", scope: model.ScopeRead,
+			summary: "Lists the domains matching all filters",
+			params:  params(filterParams(), pageParams), handler: s.handleSearchDomains},
+		{method: http.MethodPost, path: "/domains", id: "addDomains", tag: "domains", scope: model.ScopeSubmit,
+			summary: "Adds the domains and queues the new ones for the crawl",
+			body:    apistructs.APIRequest{}, fields: []string{"urls"}, required: []string{"urls"}, handler: s.handleAddDomains},
+		{method: http.MethodDelete, path: "/domains", id: "deleteDomains", tag: "domains", scope: model.ScopeAdmin,
+			summary: "Deletes the domains of the urls, or the domains matching all filters, with their queue, request and history rows",
+			params:  params(deleteFilterParams(), []param{hardParam}),
+			body:    apistructs.APIRequest{}, fields: []string{"urls"}, optional: true, handler: s.handleDeleteDomains},
+		{method: http.MethodGet, path: "/domains/export", id: "exportDomains", tag: "domains", scope: model.ScopeRead,
+			summary: "Streams the domains matching all filters, or the crawled domains of the request, as the file",
+			params: params(filterParams(), []param{
+				queryParam("format", "format of the file", enumSchema(export.CSV, export.JSONL, export.Parquet)),
+				queryParam("code", "code of the request", schema{Type: "string"}),
+			}),
+			produces: []string{"text/csv", "application/x-ndjson", "application/vnd.apache.parquet"}, handler: s.handleExport},
+		{method: http.MethodGet, path: "/domains/:id", id: "getDomain", tag: "domains", scope: model.ScopeRead,
+			summary: "Returns the domain", params: []param{idParam}, handler: s.handleGetDomain},
+		{method: http.MethodPut, path: "/domains/:id", id: "updateDomain", tag: "domains", scope: model.ScopeAdmin,
+			summary: "Replaces the crawl result of the domain", params: []param{idParam},
+			body: model.Domain{}, fields: []string{"host", "responseCode", "errorCount", "contentLang", "tagLanguages",
+				"sitemapLanguages", "blockerName", "errorClass", "ip", "checkedAt", "maxAge", "refreshGroup"},
+			handler: s.handleUpdateDomain},
+		{method: http.MethodDelete, path: "/domains/:id", id: "deleteDomain", tag: "domains", scope: model.ScopeAdmin,
+			summary: "Deletes the domain with its queue, request and history rows",
+			params:  []param{idParam, hardParam}, handler: s.handleDeleteDomain},
+		{method: http.MethodGet, path: "/domains/:id/history", id: "getDomainHistory", tag: "domains", scope: model.ScopeRead,
+			summary: "Lists the crawls of the domain from the latest one",
+			params:  params([]param{idParam}, pageParams), handler: s.handleGetHistory},
+		{method: http.MethodGet, path: "/domains/:id/history/diff", id: "diffDomainHistory", tag: "domains", scope: model.ScopeRead,
+			summary: "Compares the languages of two crawls, the latest two by default",
+			params: []param{idParam,
+				queryParam("from", "history id of the earlier crawl", intSchema(1)),
+				queryParam("to", "history id of the later crawl", intSchema(1)),
+			}, handler: s.handleGetHistoryDiff},
+		{method: http.MethodGet, path: "/languages", id: "countLanguages", tag: "domains", scope: model.ScopeRead,
+			summary: "Counts the domains per language and source", handler: s.handleGetLanguages},
+
+		{method: http.MethodPost, path: "/requests", id: "submitRequest", tag: "requests", scope: model.ScopeSubmit,
+			summary: "Submits the hosts, the crawled ones are returned at once and the rest are queued",
+			status:  http.StatusAccepted, body: apistructs.SubmitRequest{}, required: []string{"urls"}, handler: s.handleSubmitRequest},
+		{method: http.MethodPost, path: "/requests/uploads", id: "uploadRequest", tag: "requests", scope: model.ScopeSubmit,
+			summary: "Submits the host list file, one host per line or the csv with the host in the first column",
+			params: params(budgetParams, []param{
+				queryParam("format", "format of the file, by the content type or the file name by default", enumSchema(uploadText, uploadCSV)),
+			}),
+			status: http.StatusAccepted, consumes: []string{"text/plain", "text/csv", "application/gzip", "multipart/form-data"},
+			handler: s.handleUploadRequest},
+		{method: http.MethodGet, path: "/requests/:code", id: "getRequest", tag: "requests", scope: model.ScopeRead,
+			summary: "Returns the status and the progress of the request with the page of its crawled domains",
+			params:  params([]param{codeParam}, pageParams), handler: s.handleGetRequest},
+		{method: http.MethodDelete, path: "/requests/:code", id: "cancelRequest", tag: "requests", scope: model.ScopeSubmit,
+			summary: "Cancels the request, its domains which are not crawled yet leave the queue",
+			params:  []param{codeParam}, handler: s.handleCancelRequest},
+
+		{method: http.MethodGet, path: "/proxies", id: "listProxies", tag: "proxies", scope: model.ScopeAdmin,
+			summary: "Lists the proxies", params: pageParams, handler: s.handleGetProxyList},
+		{method: http.MethodPost, path: "/proxies", id: "addProxies", tag: "proxies", scope: model.ScopeAdmin,
+			summary: "Adds the proxies, the invalid ones are skipped",
+			body:    apistructs.APIRequest{}, fields: []string{"proxy"}, required: []string{"proxy"}, handler: s.handleAddProxy},
+		{method: http.MethodPut, path: "/proxies/:id", id: "updateProxy", tag: "proxies", scope: model.ScopeAdmin,
+			summary: "Replaces the proxy", params: []param{idParam},
+			body: model.Proxy{}, required: []string{"ip", "port", "type"}, handler: s.handleUpdateProxy},
+		{method: http.MethodDelete, path: "/proxies/:id", id: "deleteProxy", tag: "proxies", scope: model.ScopeAdmin,
+			summary: "Deletes the proxy", params: []param{idParam}, handler: s.handleDeleteProxy},
+
+		{method: http.MethodGet, path: "/jobs", id: "listJobs", tag: "jobs", scope: model.ScopeRead,
+			summary: "Lists the recrawl jobs of the watched domains", handler: s.handleGetWatchlists},
+		{method: http.MethodPost, path: "/jobs", id: "createJob", tag: "jobs", scope: model.ScopeSubmit,
+			summary: "Creates the job which recrawls its domains every interval and reports the language changes",
+			body:    apistructs.APIRequest{}, fields: []string{"name", "interval", "callback", "urls"},
+			required: []string{"name", "interval"}, handler: s.handleAddWatchlist},
+		{method: http.MethodGet, path: "/jobs/:id", id: "getJob", tag: "jobs", scope: model.ScopeRead,
+			summary: "Returns the job with its domains", params: []param{idParam}, handler: s.handleGetWatchlist},
+		{method: http.MethodDelete, path: "/jobs/:id", id: "deleteJob", tag: "jobs", scope: model.ScopeSubmit,
+			summary: "Deletes the job", params: []param{idParam}, handler: s.handleDeleteWatchlist},
+		{method: http.MethodPost, path: "/jobs/:id/domains", id: "addJobDomains", tag: "jobs", scope: model.ScopeSubmit,
+			summary: "Adds the domains to the job", params: []param{idParam},
+			body: apistructs.APIRequest{}, fields: []string{"urls"}, required: []string{"urls"}, handler: s.handleAddWatchlistDomains},
+		{method: http.MethodGet, path: "/keys", id: "listKeys", tag: "keys", scope: model.ScopeAdmin,
+			summary: "Lists the api keys of all tenants, the revoked ones too", handler: s.handleGetAPIKeys},
+		{method: http.MethodPost, path: "/keys", id: "issueKey", tag: "keys", scope: model.ScopeAdmin,
+			summary: "Issues the api key of the tenant, its secret is returned only once",
+			status:  http.StatusCreated, body: apistructs.APIKeyRequest{}, required: []string{"tenant", "scope"}, handler: s.handleAddAPIKey},
+		{method: http.MethodDelete, path: "/keys/:id", id: "revokeKey", tag: "keys", scope: model.ScopeAdmin,
+			summary: "Revokes the api key", params: []param{idParam}, handler: s.handleRevokeAPIKey},
+
+		{method: http.MethodGet, path: "/workers", id: "listWorkers", tag: "jobs", scope: model.ScopeRead,
+			summary: "Lists the crawler workers with a recent heartbeat", handler: s.handleGetWorkers},
+	}
+}
+
+// deprecated marks the responses of the unversioned routes, they are replaced by the v1 api
+func deprecated(c *gin.Context) {
+	c.Header("Deprecation", "true")
+	c.Header("Link", "<"+apiPrefix+specPath+`>; rel="service-desc"`)
+	c.Next()
+}
+
+// handleSearchDomains lists the domains matching all filters, all of them without filters
+func (s *server) handleSearchDomains(c *gin.Context) {
+	resp, writeResp := newResp(c)
+	defer writeResp()
+
+	page, err := s.pageOf(c)
+	if err != nil {
+		resp.CreateError(http.StatusBadRequest, "%s", err)
+		return
+	}
+	filter, err := domainFilter(c)
+	if err != nil {
+		resp.CreateError(http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	res, info, err := s.store.Domain().Search(c.Request.Context(), filter, page)
+	if err != nil {
+		fail(resp, err)
+		return
+	}
+	resp.Results = &apistructs.APIResults{
+		Domains:  res,
+		PageInfo: info,
+	}
+	resp.CreateMessage("Page size: %d, Total: %d", page.Limit, info.Total)
+}
+
+func (s *server) handleGetDomain(c *gin.Context) {
+	resp, writeResp := newResp(c)
+	defer writeResp()
+
+	var err error
+	if resp.Results, err = s.getDomainByID(c.Request.Context(), c.Param("id")); err != nil {
+		fail(resp, err)
+	}
+}
+
+// handleSubmitRequest returns the crawled domains of the hosts or queues them for the crawl
+func (s *server) handleSubmitRequest(c *gin.Context) {
+	resp, writeResp := newResp(c)
+	defer writeResp()
+
+	var req apistructs.SubmitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		resp.CreateError(http.StatusBadRequest, "invalid request body: %s", err)
+		return
+	}
+	if len(req.Hosts) == 0 {
+		resp.CreateError(http.StatusUnprocessableEntity, "urls are required")
+		return
+	}
+	if invalidHosts(resp, "urls", req.Hosts) {
+		return
+	}
+	header, err := newRequestHeader(req.MaxDomains, req.Deadline, req.MaxDuration)
+	if err != nil {
+		fail(resp, err)
+		return
+	}
+	header.Callback = req.Callback
+
+	if resp.Results, err = s.requestDomains(c, req.Hosts, header, req.Refresh); err != nil {
+		fail(resp, err)
+		return
+	}
+	if len(resp.Results.Domains) == 0 {
+		resp.Status = http.StatusAccepted
+	}
+}
@@ -1,18 +1,25 @@
 package apiserver
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/go-ozzo/ozzo-validation/is"
 	"github.com/sirupsen/logrus"
 	"io"
 	"net/http"
 	"restapi_langparser/internal/apistructs"
 	"restapi_langparser/internal/config"
+	"restapi_langparser/internal/langfinder"
 	"restapi_langparser/internal/model"
 	"restapi_langparser/internal/store"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type ctxKey int8
@@ -20,16 +27,26 @@ type ctxKey int8
 type server struct {
 	router *gin.Engine
 	store  store.IStore
-	config config.Config
+	config *config.Config
+	spec   *openAPI
+
+	limiter *rateLimiter // calls of the api keys
 	//finder *langfinder.LangFinder
+
+	ingests    sync.WaitGroup // uploads being added to their requests and callbacks of the deletions
+	ingestCtx  context.Context
+	stopIngest context.CancelFunc
 }
 
 func newServer(store store.IStore, config *config.Config) *server {
 	s := &server{
-		router: gin.New(),
-		store:  store,
+		router:  gin.New(),
+		store:   store,
+		config:  config,
+		limiter: newRateLimiter(),
 		//finder: langfinder.New(store, config),
 	}
+	s.ingestCtx, s.stopIngest = context.WithCancel(context.Background())
 	s.configureRouter()
 	return s
 }
@@ -39,17 +56,17 @@ func newResp(c *gin.Context) (*apistructs.APIResponse, func()) {
 		Status: http.StatusOK,
 	}
 	return resp, func() {
-		c.String(resp.Status, resp.String())
+		c.JSON(resp.Status, resp)
 	}
 }
 
-func (s *server) getDomainByID(sid string) (*apistructs.APIResults, error) {
+func (s *server) getDomainByID(ctx context.Context, sid string) (*apistructs.APIResults, error) {
 	id, err := strconv.Atoi(sid)
 	if err != nil {
-		return nil, err
+		return nil, invalid("invalid id %q", sid)
 	}
 
-	domain, err := s.store.Domain().FindByID(id)
+	domain, err := s.store.Domain().FindByID(ctx, id)
 	if err != nil {
 		return nil, err
 	}
@@ -60,266 +77,682 @@ func (s *server) getDomainByID(sid string) (*apistructs.APIResults, error) {
 	}, nil
 }
 
-func (s *server) requestDomains(hostsString string, callback *string) (*apistructs.APIResults, error) {
-	hosts := strings.Split(hostsString, ",")
-
-	domains, err := s.store.GetDomains(hosts)
-	if err != nil {
+// requestDomains returns the crawled domains or queues them for the crawl.
+// With refresh the domains are crawled again even if the results are ready.
+// The hosts over the budget of the request are skipped, the rest are counted
+// against the quota of the key and the request belongs to its tenant.
+func (s *server) requestDomains(c *gin.Context, hosts []string, header model.RequestHeader, refresh bool) (*apistructs.APIResults, error) {
+	ctx := c.Request.Context()
+	hosts = header.Budget(hosts)
+	if err := s.useQuota(c, len(hosts)); err != nil {
 		return nil, err
 	}
+	header.Owner = tenantOf(c)
+
+	var domains []model.Domain
+	var err error
+	if !refresh {
+		domains, err = s.store.GetDomains(ctx, hosts)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	res := &apistructs.APIResults{}
 
 	if len(domains) == 0 {
 		domains = model.CreateDomainsList(hosts)
-		if err = s.store.AddDomains(&domains); err != nil {
+		if err = s.store.AddDomains(ctx, &domains); err != nil {
 			return nil, err
 		}
+		if refresh {
+			if err = s.store.RefreshDomains(ctx, domains, false); err != nil {
+				return nil, err
+			}
+		}
 	} else {
 		res.Domains = domains
 	}
 
-	requestCode, err := s.store.CreateRequest(domains, callback)
+	request, err := s.store.CreateRequest(ctx, header, domains)
 	if err != nil {
 		return nil, err
 	}
 
-	res.RequestCode = requestCode
+	res.RequestCode = request.Code
 
 	return res, nil
 }
 
 func (s *server) configureRouter() {
-	s.router.Use(gin.Recovery())
-	s.router.POST("/domains", s.handleAddDomains)
-	s.router.GET("/domains", s.domainsHandler)
-	s.router.PUT("/domains/:id", s.handleUpdateDomain)
-	s.router.DELETE("/domains/:id", s.handleDeleteDomain)
+	s.router.HandleMethodNotAllowed = true
+	s.router.NoRoute(s.handleNoRoute)
+	s.router.NoMethod(s.handleNoMethod)
+	s.router.Use(gin.CustomRecovery(recovery))
+
+	routes := s.routes()
+	s.spec = newOpenAPI(routes, s.config.RequireAPIKey)
+	s.router.GET(apiPrefix+specPath, s.handleOpenAPI)
+	v1 := s.router.Group(apiPrefix, s.authenticate)
+	for i := range routes {
+		r := &routes[i]
+		v1.Handle(r.method, r.path, require(r.scope), s.validate(r), r.handler)
+	}
 
-	s.router.POST("/proxy", s.handleAddProxy)
-	s.router.GET("/proxy", s.handleGetProxyList)
-	s.router.PUT("/proxy/:id", s.handleUpdateProxy)
-	s.router.DELETE("/proxy/:id", s.handleDeleteProxy)
+	// the unversioned routes are kept for the old clients
+	legacy := s.router.Group("/", deprecated, s.authenticate)
+	read := legacy.Group("/", require(model.ScopeRead))
+	submit := legacy.Group("/", require(model.ScopeSubmit))
+	admin := legacy.Group("/", require(model.ScopeAdmin))
+	submit.POST("/domains", s.handleAddDomains)
+	read.GET("/domains", s.domainsHandler)
+	admin.PUT("/domains/:id", s.handleUpdateDomain)
+	admin.DELETE("/domains", s.handleDeleteDomains)
+	admin.DELETE("/domains/:id", s.handleDeleteDomain)
+	read.GET("/domains/:id/history", s.handleGetHistory)
+	read.GET("/domains/:id/history/diff", s.handleGetHistoryDiff)
+	read.GET("/languages", s.handleGetLanguages)
+	read.GET("/export", s.handleExport)
+
+	read.GET("/requests/:code", s.handleGetRequest)
+	submit.POST("/requests", s.handleUploadRequest)
+	submit.DELETE("/requests/:code", s.handleCancelRequest)
+
+	admin.POST("/proxy", s.handleAddProxy)
+	admin.GET("/proxy", s.handleGetProxyList)
+	admin.PUT("/proxy/:id", s.handleUpdateProxy)
+	admin.DELETE("/proxy/:id", s.handleDeleteProxy)
+
+	submit.POST("/watchlists", s.handleAddWatchlist)
+	read.GET("/watchlists", s.handleGetWatchlists)
+	read.GET("/watchlists/:id", s.handleGetWatchlist)
+	submit.DELETE("/watchlists/:id", s.handleDeleteWatchlist)
+	submit.POST("/watchlists/:id/domains", s.handleAddWatchlistDomains)
+
+	read.GET("/workers", s.handleGetWorkers)
+	s.router.GET("/metrics", s.authenticate, require(model.ScopeRead), s.handleMetrics)
+}
 
-	s.router.POST("/echo", func(c *gin.Context) {
-		resp, writeResp := newResp(c)
-		defer writeResp()
+// Start serves the api until ctx is done, then waits for the running requests
+func (s *server) Start(ctx context.Context, addr string) error {
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: s.router,
+	}
 
-		d, _ := io.ReadAll(c.Request.Body)
-		c.Request.Body.Close()
-		fmt.Println(string(d))
-		resp.CreateMessage("Echo request accepted")
-	})
-}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
 
-func (s *server) Start(addr string) error {
-	return s.router.Run(addr)
+	logrus.Info("Shutting down the api server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+	err := srv.Shutdown(shutdownCtx)
+	s.waitIngests(shutdownCtx)
+	return err
 }
 
 /*
 	Handlers
 */
 
+// domainsHandler serves the unversioned domain list, it returns the domain by
+// id, requests the hosts, returns the request by code or searches the domains
 func (s *server) domainsHandler(c *gin.Context) {
+	switch {
+	case c.Query("id") != "":
+		c.Params = append(c.Params, gin.Param{Key: "id", Value: c.Query("id")})
+		s.handleGetDomain(c)
+	case c.Query("hosts") != "":
+		if require(model.ScopeSubmit)(c); !c.IsAborted() {
+			s.handleRequestHosts(c)
+		}
+	case c.Query("code") != "":
+		c.Params = append(c.Params, gin.Param{Key: "code", Value: c.Query("code")})
+		s.handleGetRequest(c)
+	default:
+		s.handleSearchDomains(c)
+	}
+}
+
+// handleRequestHosts returns the crawled domains of the comma separated hosts or queues them for the crawl
+func (s *server) handleRequestHosts(c *gin.Context) {
 	resp, writeResp := newResp(c)
 	defer writeResp()
 
-	var err error
+	hosts := strings.Split(c.Query("hosts"), ",")
+	if invalidHosts(resp, "hosts", hosts) {
+		return
+	}
+	header, err := requestBudget(c)
+	if err != nil {
+		resp.CreateError(http.StatusBadRequest, "%s", err)
+		return
+	}
+	header.Callback = c.Query("callback")
 
-	if id := c.Query("id"); id != "" { // get domain info by ID
-		resp.Results, err = s.getDomainByID(id)
-		if err != nil {
-			resp.Status = http.StatusInternalServerError
-			resp.CreateError(err.Error())
-			return
+	refresh, err := strconv.ParseBool(c.DefaultQuery("refresh", "false"))
+	if err != nil {
+		resp.CreateError(http.StatusBadRequest, "invalid refresh: %s", err)
+		return
+	}
+
+	if resp.Results, err = s.requestDomains(c, hosts, header, refresh); err != nil {
+		fail(resp, err)
+	}
+}
+
+// requestBudget reads the optional budget of the submission. The max duration
+// and the deadline are both the deadline of the request, the earlier one wins.
+func requestBudget(c *gin.Context) (model.RequestHeader, error) {
+	maxDomains := 0
+	if value := c.Query("max_domains"); value != "" {
+		var err error
+		if maxDomains, err = strconv.Atoi(value); err != nil {
+			return model.RequestHeader{}, fmt.Errorf("invalid max_domains %q", value)
+		}
+	}
+	deadline, err := queryTime(c, "deadline", 0)
+	if err != nil {
+		return model.RequestHeader{}, err
+	}
+	return newRequestHeader(maxDomains, deadline, c.Query("max_duration"))
+}
+
+// newRequestHeader returns the request with the budget
+func newRequestHeader(maxDomains int, deadline *time.Time, maxDuration string) (model.RequestHeader, error) {
+	header := model.RequestHeader{MaxDomains: maxDomains, Deadline: deadline}
+	if maxDomains < 0 {
+		return header, invalid("invalid max_domains %d", maxDomains)
+	}
+	if maxDuration != "" {
+		duration, err := time.ParseDuration(maxDuration)
+		if err != nil || duration <= 0 {
+			return header, invalid("invalid max_duration %q", maxDuration)
 		}
-	} else if hosts := c.Query("hosts"); len(hosts) > 0 { // get domains info by host
-		var cb *string
-		callback := c.Query("callback")
-		if callback != "" {
-			cb = &callback
+		deadline := time.Now().Add(duration)
+		if header.Deadline == nil || deadline.Before(*header.Deadline) {
+			header.Deadline = &deadline
 		}
+	}
+	return header, nil
+}
 
-		resp.Results, err = s.requestDomains(hosts, cb)
-		if err != nil {
-			resp.Status = http.StatusInternalServerError
-			resp.CreateError(err.Error())
+// pageOf reads the page size and the cursor of the listing, the size is cut to the maximum
+func (s *server) pageOf(c *gin.Context) (model.Page, error) {
+	if c.Query("page") != "" {
+		return model.Page{}, errors.New("page numbers are not supported, pass next_cursor of the previous page as cursor")
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("pagesize", strconv.Itoa(s.config.PageSize)))
+	if err != nil || limit < 1 {
+		return model.Page{}, fmt.Errorf("invalid pagesize %q", c.Query("pagesize"))
+	}
+	if limit > s.config.MaxPageSize {
+		limit = s.config.MaxPageSize
+	}
+	return model.Page{Limit: limit, Cursor: c.Query("cursor")}, nil
+}
+
+// domainFilter reads the search filters of the domain list. The crawl date
+// range takes RFC 3339 times or dates, the date of checked_to is included.
+func domainFilter(c *gin.Context) (model.DomainFilter, error) {
+	filter := model.DomainFilter{
+		ContentLang:  c.Query("content_lang"),
+		DeclaredLang: c.Query("declared_lang"),
+		ResponseCode: c.Query("response_code"),
+		BlockerName:  c.Query("blocker"),
+		ErrorClass:   c.Query("error_class"),
+		TLD:          strings.TrimPrefix(c.Query("tld"), "."),
+		Sort:         c.Query("sort"),
+	}
+	if _, _, err := filter.SortColumn(); err != nil {
+		return filter, err
+	}
+
+	var err error
+	if filter.CheckedFrom, err = queryTime(c, "checked_from", 0); err != nil {
+		return filter, err
+	}
+	if filter.CheckedTo, err = queryTime(c, "checked_to", 24*time.Hour); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// queryTime parses the time parameter, the day is added to the date without the time
+func queryTime(c *gin.Context, name string, day time.Duration) (*time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %q is neither RFC 3339 time nor date", name, value)
+	}
+	t = t.Add(day)
+	return &t, nil
+}
+
+func (s *server) handleUpdateDomain(c *gin.Context) {
+	resp, writeResp := newResp(c)
+	defer writeResp()
+
+	var domain model.Domain
+	if err := c.ShouldBindJSON(&domain); err != nil {
+		resp.CreateError(http.StatusBadRequest, "invalid domain: %s", err)
+		return
+	}
+	found, err := s.findDomain(c)
+	if err != nil {
+		fail(resp, err)
+		return
+	}
+	domain.ID = found.ID
+
+	if err = s.store.Domain().Update(c.Request.Context(), domain); err != nil {
+		fail(resp, err)
+		return
+	}
+	resp.CreateMessage("updated")
+}
+
+func (s *server) handleDeleteDomain(c *gin.Context) {
+	resp, writeResp := newResp(c)
+	defer writeResp()
+
+	hard, err := queryBool(c, "hard")
+	if err != nil {
+		fail(resp, err)
+		return
+	}
+	domain, err := s.findDomain(c)
+	if err != nil {
+		fail(resp, err)
+		return
+	}
+	if err = s.deleteDomains(c, []uint{domain.ID}, hard); err != nil {
+		fail(resp, err)
+		return
+	}
+	resp.CreateMessage("domain %d deleted", domain.ID)
+}
+
+// handleDeleteDomains deletes the domains of the urls of the body, or the
+// domains matching all filters. Either is required, so a bare call does not
+// delete everything.
+func (s *server) handleDeleteDomains(c *gin.Context) {
+	resp, writeResp := newResp(c)
+	defer writeResp()
+
+	hard, err := queryBool(c, "hard")
+	if err != nil {
+		fail(resp, err)
+		return
+	}
+	filter, err := domainFilter(c)
+	if err != nil {
+		resp.CreateError(http.StatusBadRequest, "%s", err)
+		return
+	}
+	filter.Sort = ""
+	var req apistructs.APIRequest
+	if c.Request.Body != nil {
+		if err = json.NewDecoder(c.Request.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			resp.CreateError(http.StatusBadRequest, "invalid request body: %s", err)
 			return
 		}
-	} else if code := c.Query("code"); code != "" { // get results by request code
-		res, err := s.store.GetRequest(code)
-		if err != nil {
-			resp.Status = http.StatusInternalServerError
-			resp.CreateError(err.Error())
+	}
+
+	ctx := c.Request.Context()
+	var ids []uint
+	switch {
+	case len(req.Hosts) > 0 && filter != (model.DomainFilter{}):
+		resp.CreateError(http.StatusUnprocessableEntity, "either urls or filters are required, not both")
+		return
+	case len(req.Hosts) > 0:
+		if invalidHosts(resp, "urls", req.Hosts) {
 			return
 		}
-		resp.Results = &apistructs.APIResults{
-			Domains: res,
+		var hosts []string
+		for _, domain := range model.CreateDomainsList(req.Hosts) {
+			hosts = append(hosts, domain.Host)
 		}
-	} else { // list all domains
-		limit, err := strconv.Atoi(c.DefaultQuery("pagesize", "10"))
+		domains, err := s.store.Domain().FindByHost(ctx, hosts...)
 		if err != nil {
-			resp.Status = http.StatusInternalServerError
-			resp.CreateError(err.Error())
+			fail(resp, err)
 			return
 		}
-		page, err := strconv.Atoi(c.DefaultQuery("page", "0"))
-		if err != nil {
-			resp.Status = http.StatusInternalServerError
-			resp.CreateError(err.Error())
-			return
+		for _, domain := range domains {
+			ids = append(ids, domain.ID)
 		}
-
-		res, err := s.store.Domain().Read(limit, page*limit)
+	case filter != (model.DomainFilter{}):
+		err = s.store.Domain().Export(ctx, filter, "", func(domain model.Domain) error {
+			ids = append(ids, domain.ID)
+			return nil
+		})
 		if err != nil {
-			resp.Status = http.StatusInternalServerError
-			resp.CreateError(err.Error())
+			fail(resp, err)
 			return
 		}
-		resp.Results = &apistructs.APIResults{
-			Domains: res,
-		}
-		resp.CreateMessage("Page size: %d, Page: %d", limit, page)
+	default:
+		resp.CreateError(http.StatusUnprocessableEntity, "urls or filters are required")
+		return
 	}
+
+	if err = s.deleteDomains(c, ids, hard); err != nil {
+		fail(resp, err)
+		return
+	}
+	resp.CreateMessage("domains deleted: %d", len(ids))
 }
 
-func (s *server) handleUpdateDomain(c *gin.Context) {
-	var domain model.Domain
-	resp := &apistructs.APIResponse{}
-	status := http.StatusInternalServerError
-	defer func() {
-		c.String(status, resp.String())
+// deleteDomains deletes the domains and finishes the requests which have no
+// other pending domains. Their callbacks are sent in background.
+func (s *server) deleteDomains(c *gin.Context, ids []uint, hard bool) error {
+	requests, err := s.store.DeleteDomains(c.Request.Context(), ids, hard)
+	if err != nil || len(requests) == 0 {
+		return err
+	}
+	s.ingests.Add(1)
+	go func() {
+		defer s.ingests.Done()
+		langfinder.FinishRequests(s.ingestCtx, s.store, s.config, requests)
 	}()
+	return nil
+}
 
-	if err := c.BindJSON(&domain); err != nil {
-		resp.CreateError(err.Error())
-		status = http.StatusInternalServerError
-		return
+// queryBool parses the boolean parameter, false if it is missing
+func queryBool(c *gin.Context, name string) (bool, error) {
+	value := c.Query(name)
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, invalid("invalid %s %q", name, value)
 	}
+	return b, nil
+}
 
-	status = http.StatusOK
+// findDomain returns the domain of the id parameter
+func (s *server) findDomain(c *gin.Context) (*model.Domain, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id < 1 {
+		return nil, invalid("invalid id %q", c.Param("id"))
+	}
+	return s.store.Domain().FindByID(c.Request.Context(), id)
+}
 
-	sID := c.Param("id")
-	id, _ := strconv.Atoi(sID)
-	domain.ID = uint(id)
+// handleGetHistory lists crawls of the domain from the latest one
+func (s *server) handleGetHistory(c *gin.Context) {
+	resp, writeResp := newResp(c)
+	defer writeResp()
 
-	if err := s.store.Domain().Update(domain); err != nil {
-		resp.Status = http.StatusInternalServerError
-		resp.CreateError(err.Error())
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		resp.CreateError(http.StatusBadRequest, "invalid id: %s", err)
+		return
+	}
+	page, err := s.pageOf(c)
+	if err != nil {
+		resp.CreateError(http.StatusBadRequest, "%s", err)
 		return
 	}
-	resp.CreateMessage("updated")
-}
-
-func (s *server) handleDeleteDomain(c *gin.Context) {
-	resp := &apistructs.APIResponse{}
-	status := http.StatusInternalServerError
-	defer func() {
-		c.String(status, resp.String())
-	}()
 
-	status = http.StatusOK
-	resp.CreateMessage("request %v", c.Param("id"))
+	history, info, err := s.store.GetHistory(c.Request.Context(), uint(id), page)
+	if err != nil {
+		fail(resp, err)
+		return
+	}
+	resp.Results = &apistructs.APIResults{
+		History:  history,
+		PageInfo: info,
+	}
+	resp.CreateMessage("Page size: %d, Total: %d", page.Limit, info.Total)
 }
 
-func (s *server) handleAddDomains(c *gin.Context) {
-	var req apistructs.APIRequest
-	resp := &apistructs.APIResponse{}
-	status := http.StatusInternalServerError
-	defer func() {
-		c.String(status, resp.String())
-	}()
+// handleGetHistoryDiff compares languages of two crawls given by the from and
+// to history ids, the latest two crawls by default
+func (s *server) handleGetHistoryDiff(c *gin.Context) {
+	resp, writeResp := newResp(c)
+	defer writeResp()
 
-	body, err := io.ReadAll(c.Request.Body)
-	c.Request.Body.Close()
+	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		resp.CreateError(err.Error())
-		status = http.StatusInternalServerError
+		resp.CreateError(http.StatusBadRequest, "invalid id: %s", err)
 		return
 	}
 
-	if err = json.Unmarshal(body, &req); err != nil {
-		resp.CreateError(err.Error())
-		status = http.StatusInternalServerError
-		return
+	var from, to *model.DomainHistory
+	if c.Query("from") == "" && c.Query("to") == "" {
+		history, _, err := s.store.GetHistory(c.Request.Context(), uint(id), model.Page{Limit: 2})
+		if err != nil {
+			fail(resp, err)
+			return
+		}
+		if len(history) < 2 {
+			resp.CreateError(http.StatusNotFound, "domain %d has less than two crawls", id)
+			return
+		}
+		from, to = &history[1], &history[0]
+	} else {
+		if from, err = s.getHistoryRecord(c, uint(id), "from"); err != nil {
+			fail(resp, err)
+			return
+		}
+		if to, err = s.getHistoryRecord(c, uint(id), "to"); err != nil {
+			fail(resp, err)
+			return
+		}
+	}
+
+	diff := model.DiffLanguages(*from, *to)
+	resp.Results = &apistructs.APIResults{
+		Diff: &diff,
+	}
+}
+
+func (s *server) getHistoryRecord(c *gin.Context, domainID uint, param string) (*model.DomainHistory, error) {
+	id, err := strconv.Atoi(c.Query(param))
+	if err != nil {
+		return nil, invalid("invalid %s: %s", param, err)
 	}
+	return s.store.GetHistoryRecord(c.Request.Context(), domainID, uint(id))
+}
+
+// handleAddDomains adds the urls of the request to the domains and queues the new ones
+func (s *server) handleAddDomains(c *gin.Context) {
+	resp, writeResp := newResp(c)
+	defer writeResp()
 
+	var req apistructs.APIRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		resp.CreateError(http.StatusBadRequest, "invalid request body: %s", err)
+		return
+	}
 	if len(req.Hosts) == 0 {
-		logrus.Errorf("Invalid data format in the request %s", string(body))
-		resp.CreateError("Invalid data format in the request %s", string(body))
-		status = http.StatusInternalServerError
+		resp.CreateError(http.StatusUnprocessableEntity, "urls are required")
+		return
+	}
+	if invalidHosts(resp, "urls", req.Hosts) {
 		return
 	}
 
+	if err := s.useQuota(c, len(req.Hosts)); err != nil {
+		fail(resp, err)
+		return
+	}
 	domains := model.CreateDomainsList(req.Hosts)
-	if err = s.store.AddDomains(&domains); err != nil {
-		status = http.StatusInternalServerError
-		resp.CreateError("Storage fail: %s", err.Error())
+	if err := s.store.AddDomains(c.Request.Context(), &domains); err != nil {
+		fail(resp, err)
 		return
 	}
+	resp.Results = &apistructs.APIResults{
+		Domains: domains,
+	}
+	resp.CreateMessage("domains added: %d", len(domains))
+}
 
-	status = http.StatusOK
+// invalidHosts adds the details of the hosts which are not urls, true if there are any
+func invalidHosts(resp *apistructs.APIResponse, field string, hosts []string) bool {
+	for i, host := range hosts {
+		if err := validation.Validate(host, validation.Required, is.URL); err != nil {
+			resp.AddDetail(fmt.Sprintf("%s[%d]", field, i), host, err.Error())
+		}
+	}
+	if resp.Error == nil {
+		return false
+	}
+	resp.Error.Message = fmt.Sprintf("invalid hosts: %d", len(resp.Error.Details))
+	return true
 }
 
 func (s *server) handleAddProxy(c *gin.Context) {
-	var request apistructs.APIRequest
-	resp := &apistructs.APIResponse{}
-	status := http.StatusInternalServerError
-	defer func() {
-		c.String(status, resp.String())
-	}()
+	resp, writeResp := newResp(c)
+	defer writeResp()
 
-	if err := c.BindJSON(&request); err != nil {
-		resp.CreateError(err.Error())
-		status = http.StatusInternalServerError
+	var request apistructs.APIRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		resp.CreateError(http.StatusBadRequest, "invalid request body: %s", err)
 		return
 	}
-
-	err := s.store.Proxy().Create(request.Proxy)
-	if err != nil {
-		resp.CreateError(err.Error())
+	if len(request.Proxy) == 0 {
+		resp.CreateError(http.StatusUnprocessableEntity, "proxy is required")
 		return
 	}
 
-	status = http.StatusOK
+	if err := s.store.Proxy().Create(c.Request.Context(), request.Proxy); err != nil {
+		fail(resp, err)
+		return
+	}
+	resp.CreateMessage("proxy added: %d", len(request.Proxy))
 }
 
 func (s *server) handleGetProxyList(c *gin.Context) {
-	resp := &apistructs.APIResponse{}
-	status := http.StatusInternalServerError
-	defer func() {
-		c.String(status, resp.String())
-	}()
+	resp, writeResp := newResp(c)
+	defer writeResp()
 
-	lst, err := s.store.Proxy().Read(0, 0)
+	page, err := s.pageOf(c)
+	if err != nil {
+		resp.CreateError(http.StatusBadRequest, "%s", err)
+		return
+	}
+	lst, info, err := s.store.Proxy().Read(c.Request.Context(), page)
 	if err != nil {
-		resp.CreateError(err.Error())
+		fail(resp, err)
 		return
 	}
 	resp.Results = &apistructs.APIResults{
-		Proxy: lst,
+		Proxy:    apistructs.NewProxyInfo(lst),
+		PageInfo: info,
 	}
-	resp.CreateMessage("proxy %v", lst)
-
-	status = http.StatusOK
+	resp.CreateMessage("proxies: %d", len(lst))
 }
 
 func (s *server) handleUpdateProxy(c *gin.Context) {
-	resp := &apistructs.APIResponse{}
-	status := http.StatusInternalServerError
-	defer func() {
-		c.String(status, resp.String())
-	}()
+	resp, writeResp := newResp(c)
+	defer writeResp()
+
+	var proxy model.Proxy
+	if err := c.ShouldBindJSON(&proxy); err != nil {
+		resp.CreateError(http.StatusBadRequest, "invalid proxy: %s", err)
+		return
+	}
+	found, err := s.findProxy(c)
+	if err != nil {
+		fail(resp, err)
+		return
+	}
+	proxy.ID = found.ID
 
-	status = http.StatusOK
+	if err = s.store.Proxy().Update(c.Request.Context(), []model.Proxy{proxy}); err != nil {
+		fail(resp, err)
+		return
+	}
+	resp.CreateMessage("proxy %d updated", proxy.ID)
 }
 
 func (s *server) handleDeleteProxy(c *gin.Context) {
-	resp := &apistructs.APIResponse{}
-	status := http.StatusInternalServerError
-	defer func() {
-		c.String(status, resp.String())
-	}()
+	resp, writeResp := newResp(c)
+	defer writeResp()
+
+	proxy, err := s.findProxy(c)
+	if err != nil {
+		fail(resp, err)
+		return
+	}
+	if err = s.store.Proxy().Delete(c.Request.Context(), []int{proxy.ID}); err != nil {
+		fail(resp, err)
+		return
+	}
+	resp.CreateMessage("proxy %d deleted", proxy.ID)
+}
+
+// findProxy returns the proxy of the id parameter
+func (s *server) findProxy(c *gin.Context) (*model.Proxy, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id < 1 {
+		return nil, invalid("invalid id %q", c.Param("id"))
+	}
+	return s.store.Proxy().FindByID(c.Request.Context(), int64(id))
+}
+
+// handleGetLanguages reports the number of domains per language and source
+func (s *server) handleGetLanguages(c *gin.Context) {
+	resp, writeResp := newResp(c)
+	defer writeResp()
 
-	status = http.StatusOK
+	counts, err := s.store.Domain().CountByLang(c.Request.Context())
+	if err != nil {
+		fail(resp, err)
+		return
+	}
+	resp.Results = &apistructs.APIResults{
+		Languages: counts,
+	}
+	resp.CreateMessage("languages: %d", len(counts))
+}
+
+// handleGetWorkers lists crawler workers with a recent heartbeat
+func (s *server) handleGetWorkers(c *gin.Context) {
+	resp, writeResp := newResp(c)
+	defer writeResp()
+
+	workers, err := s.store.GetWorkers(c.Request.Context(), time.Now().Add(-3*s.config.HeartbeatInterval))
+	if err != nil {
+		fail(resp, err)
+		return
+	}
+	resp.Results = &apistructs.APIResults{
+		Workers: workers,
+	}
+	resp.CreateMessage("alive workers: %d", len(workers))
+}
+
+// handleMetrics reports queue state in the Prometheus text format
+func (s *server) handleMetrics(c *gin.Context) {
+	sb := strings.Builder{}
+	sb.WriteString("# HELP langparser_queue_depth Number of domains waiting in the crawl queue lane.\n")
+	sb.WriteString("# TYPE langparser_queue_depth gauge\n")
+	for _, lane := range model.QueueLanes {
+		depth, err := s.store.QueueDepth(c.Request.Context(), lane)
+		if err != nil {
+			resp := &apistructs.APIResponse{}
+			fail(resp, err)
+			c.JSON(resp.Status, resp)
+			return
+		}
+		sb.WriteString(fmt.Sprintf("langparser_queue_depth{lane=%q} %d\n", lane, depth))
+	}
+	c.String(http.StatusOK, sb.String())
 }
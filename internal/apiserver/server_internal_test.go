@@ -1,107 +1,848 @@
 package apiserver
 
-//
-//import (
-//	"bytes"
-//	"encoding/json"
-//	"github.com/stretchr/testify/assert"
-//	"net/http"
-//	"net/http/httptest"
-//	"restapi_langparser/internal/model"
-//	"restapi_langparser/internal/store/teststore"
-//	"testing"
-//)
-//
-//func TestServer_HandleParse(t *testing.T) {
-//	testCases := []struct {
-//		name         string
-//		payload      interface{}
-//		expectedCode int
-//	}{
-//		{
-//			name: "valid request",
-//			payload: []model.Proxy{
-//				{
-//					"https://validurl.com/",
-//				},
-//			},
-//			expectedCode: http.StatusOK,
-//		},
-//	}
-//
-//	store := teststore.New()
-//	s := newServer(store)
-//
-//	for _, tc := range testCases {
-//		t.Run(tc.name, func(t *testing.T) {
-//			rec := httptest.NewRecorder()
-//			b := &bytes.Buffer{}
-//			json.NewEncoder(b).Encode(tc.payload)
-//			req, _ := http.NewRequest(http.MethodGet, "/parse", b)
-//			s.router.ServeHTTP(rec, req)
-//			assert.Equalf(t, tc.expectedCode, rec.Code, "Body of failed test case: %s", rec.Body)
-//			_, err := CreateFromJSON(rec.Body.String())
-//			assert.NoErrorf(t, err, "Bad response format: %s [%v]", rec.Body.String(), err)
-//		})
-//	}
-//}
-//
-//func TestServer_HandleAddProxy(t *testing.T) {
-//	testCases := []struct {
-//		name         string
-//		payload      interface{}
-//		expectedCode int
-//	}{
-//		{
-//			name: "valid request",
-//			payload: []model.Proxy{
-//				{
-//					"https://validurl.com/",
-//				},
-//			},
-//			expectedCode: http.StatusOK,
-//		},
-//		{
-//			name: "validation fail",
-//			payload: []model.Proxy{
-//				{
-//					"invalid url",
-//				},
-//			},
-//			expectedCode: http.StatusInternalServerError,
-//		},
-//		{
-//			name: "duplicate url",
-//			payload: []model.Proxy{
-//				{
-//					"https://duplicateurl.com/",
-//				},
-//				{
-//					"https://duplicateurl.com/",
-//				},
-//			},
-//			expectedCode: http.StatusOK,
-//		},
-//		{
-//			name:         "bad request",
-//			payload:      map[string]string{"bad": "request"},
-//			expectedCode: http.StatusBadRequest,
-//		},
-//	}
-//
-//	store := teststore.New()
-//	s := newServer(store)
-//
-//	for _, tc := range testCases {
-//		t.Run(tc.name, func(t *testing.T) {
-//			rec := httptest.NewRecorder()
-//			b := &bytes.Buffer{}
-//			json.NewEncoder(b).Encode(tc.payload)
-//			req, _ := http.NewRequest(http.MethodPost, "/proxy", b)
-//			s.router.ServeHTTP(rec, req)
-//			assert.Equalf(t, tc.expectedCode, rec.Code, "Body of failed test case: %s", rec.Body)
-//			_, err := CreateFromJSON(rec.Body.String())
-//			assert.NoErrorf(t, err, "Bad response format: %s [%v]", rec.Body.String(), err)
-//		})
-//	}
-//}
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"restapi_langparser/internal/apistructs"
+	"restapi_langparser/internal/config"
+	"restapi_langparser/internal/export"
+	"restapi_langparser/internal/model"
+	"restapi_langparser/internal/store"
+	"restapi_langparser/internal/store/memstore"
+	"restapi_langparser/internal/store/sqlstore"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openConfig is the config of the api open without the keys
+func openConfig() *config.Config {
+	cfg := config.New()
+	cfg.RequireAPIKey = false
+	return cfg
+}
+
+func serve(t *testing.T, s *server, method, path string, payload interface{}) (int, *apistructs.APIResponse) {
+	t.Helper()
+	b := &bytes.Buffer{}
+	if payload != nil {
+		json.NewEncoder(b).Encode(payload) //nolint:errcheck
+	}
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, b)
+	s.router.ServeHTTP(rec, req)
+
+	resp, err := apistructs.CreateFromJSON(rec.Body.String())
+	if err != nil {
+		t.Fatalf("Bad response format: %s [%v]", rec.Body.String(), err)
+	}
+	return rec.Code, resp
+}
+
+func TestServer_HandleAddDomains(t *testing.T) {
+	testCases := []struct {
+		name         string
+		payload      interface{}
+		expectedCode int
+	}{
+		{
+			name:         "valid request",
+			payload:      apistructs.APIRequest{Hosts: []string{"https://validurl.com/"}},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "empty host list",
+			payload:      apistructs.APIRequest{},
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "invalid host",
+			payload:      apistructs.APIRequest{Hosts: []string{"https://validurl.com/", "not a host"}},
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "bad json",
+			payload:      "urls",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	s := newServer(memstore.New(), openConfig())
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			code, resp := serve(t, s, http.MethodPost, "/domains", tc.payload)
+			if code != tc.expectedCode {
+				t.Fatalf("code %d, want %d: %+v", code, tc.expectedCode, resp)
+			}
+		})
+	}
+}
+
+func TestServer_ErrorEnvelope(t *testing.T) {
+	s := newServer(memstore.New(), openConfig())
+	domains, err := s.store.Domain().CreateWithHost(context.Background(), "https://a.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/domains/%d", domains[0].ID)
+	if err = s.store.Proxy().Create(context.Background(), []model.Proxy{{IP: "10.0.0.1", Port: "80", Scheme: model.HTTPS}}); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		method, path string
+		payload      interface{}
+		expectedCode int
+	}{
+		{http.MethodGet, "/domains?id=abc", nil, http.StatusBadRequest},
+		{http.MethodGet, "/domains?id=999", nil, http.StatusNotFound},
+		{http.MethodGet, "/domains?pagesize=many", nil, http.StatusBadRequest},
+		{http.MethodGet, "/domains?hosts=https://a.example,bad%20host", nil, http.StatusUnprocessableEntity},
+		{http.MethodGet, "/unknown", nil, http.StatusNotFound},
+		{http.MethodPatch, "/domains", nil, http.StatusMethodNotAllowed},
+		{http.MethodPut, "/domains/999", model.Domain{}, http.StatusNotFound},
+		{http.MethodDelete, "/domains/999", nil, http.StatusNotFound},
+		{http.MethodPut, "/proxy/1", model.Proxy{IP: "bad", Port: "80", Scheme: model.HTTPS}, http.StatusUnprocessableEntity},
+		{http.MethodPut, "/proxy/1", model.Proxy{IP: "10.0.0.2", Port: "80", Scheme: model.HTTPS}, http.StatusOK},
+		{http.MethodDelete, "/proxy/7", nil, http.StatusNotFound},
+		{http.MethodGet, "/watchlists/abc", nil, http.StatusBadRequest},
+		{http.MethodDelete, path, nil, http.StatusOK},
+		{http.MethodDelete, path, nil, http.StatusNotFound},
+	}
+	for _, tc := range testCases {
+		b := &bytes.Buffer{}
+		if tc.payload != nil {
+			json.NewEncoder(b).Encode(tc.payload) //nolint:errcheck
+		}
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(tc.method, tc.path, b)
+		s.router.ServeHTTP(rec, req)
+
+		if rec.Code != tc.expectedCode || rec.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+			t.Fatalf("%s %s: code %d, want %d: %v %s", tc.method, tc.path, rec.Code, tc.expectedCode, rec.Header(), rec.Body)
+		}
+		resp, err := apistructs.CreateFromJSON(rec.Body.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code == http.StatusOK {
+			continue
+		}
+		if resp.Error == nil || resp.Error.Code != apistructs.ErrorCode(rec.Code) || resp.Error.Message == "" {
+			t.Fatalf("%s %s: error %+v", tc.method, tc.path, resp.Error)
+		}
+		if rec.Code == http.StatusUnprocessableEntity && len(resp.Error.Details) != 1 {
+			t.Fatalf("%s %s: details %+v", tc.method, tc.path, resp.Error.Details)
+		}
+	}
+
+	// the error text is not taken as the format
+	code, resp := serve(t, s, http.MethodGet, "/domains?hosts=https://a.example&max_duration=5%25x", nil)
+	if code != http.StatusBadRequest || resp.Error == nil || !strings.Contains(resp.Error.Message, `"5%x"`) {
+		t.Fatalf("code %d: error %+v", code, resp.Error)
+	}
+}
+
+func TestServer_Conflict(t *testing.T) {
+	backends := []struct {
+		name     string
+		newStore func(t *testing.T) store.IStore
+	}{
+		{"memstore", func(t *testing.T) store.IStore { return memstore.New() }},
+		{"sqlite", func(t *testing.T) store.IStore {
+			db, err := sqlstore.OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return sqlstore.New(db)
+		}},
+	}
+
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			s := newServer(backend.newStore(t), openConfig())
+			domains, err := s.store.Domain().CreateWithHost(context.Background(), "https://a.example", "https://b.example")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			path := fmt.Sprintf("%s/domains/%d", apiPrefix, domains[1].ID)
+			code, resp := serve(t, s, http.MethodPut, path, map[string]string{"host": "https://a.example"})
+			if code != http.StatusConflict || resp.Error == nil || resp.Error.Code != apistructs.ErrorCode(code) {
+				t.Fatalf("update to the taken host code %d: %+v", code, resp.Error)
+			}
+
+			job := map[string]string{"name": "news", "interval": "1h"}
+			if code, resp = serve(t, s, http.MethodPost, apiPrefix+"/jobs", job); code != http.StatusOK {
+				t.Fatalf("create job code %d: %+v", code, resp.Error)
+			}
+			if code, resp = serve(t, s, http.MethodPost, apiPrefix+"/jobs", job); code != http.StatusConflict {
+				t.Fatalf("job with the taken name code %d: %+v", code, resp.Error)
+			}
+		})
+	}
+}
+
+func TestServer_HandleAddProxy(t *testing.T) {
+	testCases := []struct {
+		name         string
+		payload      interface{}
+		expectedCode int
+		expectedIPs  []string
+	}{
+		{
+			name: "valid request",
+			payload: apistructs.APIRequest{Proxy: []model.Proxy{
+				{IP: "10.0.0.1", Port: "8080", Login: "user", Password: "secret", Scheme: model.HTTPS},
+			}},
+			expectedCode: http.StatusOK,
+			expectedIPs:  []string{"10.0.0.1"},
+		},
+		{
+			name: "invalid proxy is skipped",
+			payload: apistructs.APIRequest{Proxy: []model.Proxy{
+				{IP: "invalid ip", Port: "8080", Scheme: model.HTTPS},
+				{IP: "10.0.0.2", Port: "1080", Scheme: model.Socks5},
+			}},
+			expectedCode: http.StatusOK,
+			expectedIPs:  []string{"10.0.0.1", "10.0.0.2"},
+		},
+	}
+
+	s := newServer(memstore.New(), openConfig())
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			code, resp := serve(t, s, http.MethodPost, "/proxy", tc.payload)
+			if code != tc.expectedCode {
+				t.Fatalf("code %d, want %d: %+v", code, tc.expectedCode, resp)
+			}
+
+			rec := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/proxy", nil)
+			if s.router.ServeHTTP(rec, req); strings.Contains(rec.Body.String(), "secret") {
+				t.Fatalf("proxy list with the password %s", rec.Body)
+			}
+			_, resp = serve(t, s, http.MethodGet, "/proxy", nil)
+			if resp.Results == nil || len(resp.Results.Proxy) != len(tc.expectedIPs) {
+				t.Fatalf("proxy list %+v, want %v", resp.Results, tc.expectedIPs)
+			}
+			for i, proxy := range resp.Results.Proxy {
+				if proxy.IP != tc.expectedIPs[i] {
+					t.Fatalf("proxy list %+v, want %v", resp.Results.Proxy, tc.expectedIPs)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleSearchDomains(t *testing.T) {
+	s := newServer(memstore.New(), openConfig())
+	serve(t, s, http.MethodPost, "/domains", apistructs.APIRequest{Hosts: []string{"https://a.example.com", "https://b.example.org"}})
+
+	testCases := []struct {
+		name          string
+		query         string
+		expectedCode  int
+		expectedHosts []string
+	}{
+		{
+			name:          "tld",
+			query:         "?tld=.org",
+			expectedCode:  http.StatusOK,
+			expectedHosts: []string{"https://b.example.org"},
+		},
+		{
+			name:          "sort",
+			query:         "?sort=-host",
+			expectedCode:  http.StatusOK,
+			expectedHosts: []string{"https://b.example.org", "https://a.example.com"},
+		},
+		{
+			name:         "unknown sort key",
+			query:        "?sort=ip",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "invalid date",
+			query:        "?checked_from=yesterday",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "page number",
+			query:        "?page=1",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "broken cursor",
+			query:        "?cursor=broken",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			code, resp := serve(t, s, http.MethodGet, "/domains"+tc.query, nil)
+			if code != tc.expectedCode {
+				t.Fatalf("code %d, want %d: %+v", code, tc.expectedCode, resp)
+			}
+			if tc.expectedHosts == nil {
+				return
+			}
+			if resp.Results == nil || len(resp.Results.Domains) != len(tc.expectedHosts) {
+				t.Fatalf("domains %+v, want %v", resp.Results, tc.expectedHosts)
+			}
+			for i, domain := range resp.Results.Domains {
+				if domain.Host != tc.expectedHosts[i] {
+					t.Fatalf("domains %+v, want %v", resp.Results.Domains, tc.expectedHosts)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_DomainsCursor(t *testing.T) {
+	s := newServer(memstore.New(), openConfig())
+	hosts := []string{"https://a.example.com", "https://b.example.com", "https://c.example.com"}
+	serve(t, s, http.MethodPost, "/domains", apistructs.APIRequest{Hosts: hosts})
+
+	var listed []string
+	query := "/domains?sort=-host&pagesize=2"
+	for i := 0; i < len(hosts); i++ {
+		code, resp := serve(t, s, http.MethodGet, query, nil)
+		if code != http.StatusOK || resp.Results == nil || resp.Results.PageInfo == nil {
+			t.Fatalf("code %d: %+v", code, resp)
+		}
+		if resp.Results.Total != int64(len(hosts)) {
+			t.Fatalf("total %d", resp.Results.Total)
+		}
+		for _, domain := range resp.Results.Domains {
+			listed = append(listed, domain.Host)
+		}
+		if resp.Results.NextCursor == "" {
+			break
+		}
+		query = "/domains?sort=-host&pagesize=2&cursor=" + resp.Results.NextCursor
+	}
+
+	want := []string{hosts[2], hosts[1], hosts[0]}
+	if !reflect.DeepEqual(listed, want) {
+		t.Fatalf("listed %v, want %v", listed, want)
+	}
+}
+
+func TestServer_HandleGetRequest(t *testing.T) {
+	s := newServer(memstore.New(), openConfig())
+	code, resp := serve(t, s, http.MethodGet, "/domains?hosts=https://a.example,https://b.example", nil)
+	if code != http.StatusOK || resp.Results == nil || resp.Results.RequestCode == "" {
+		t.Fatalf("code %d: %+v", code, resp)
+	}
+	path := "/requests/" + resp.Results.RequestCode
+
+	code, resp = serve(t, s, http.MethodGet, path, nil)
+	if code != http.StatusOK || resp.Results == nil || resp.Results.Request == nil {
+		t.Fatalf("code %d: %+v", code, resp)
+	}
+	if state := resp.Results.Request; state.Status != model.RequestPending || state.Queued != 2 || state.ETA != nil || len(resp.Results.Domains) != 0 {
+		t.Fatalf("pending request %+v", resp.Results)
+	}
+
+	ctx := context.Background()
+	domains, err := s.store.Domain().FindByHost(ctx, "https://a.example")
+	if err != nil || len(domains) != 1 {
+		t.Fatalf("domains %+v %v", domains, err)
+	}
+	checkedAt := time.Now().Add(time.Second)
+	domains[0].ResponseCode = model.ResponseOk
+	domains[0].CheckedAt = &checkedAt
+	if err = s.store.SaveDomain(ctx, domains[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.store.RemoveFromQueue(ctx, domains[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	code, resp = serve(t, s, http.MethodGet, path, nil)
+	if code != http.StatusOK || resp.Results == nil || resp.Results.Request == nil {
+		t.Fatalf("code %d: %+v", code, resp)
+	}
+	state := resp.Results.Request
+	if state.Status != model.RequestRunning || state.Done != 1 || state.Queued != 1 || state.ETA == nil {
+		t.Fatalf("running request %+v", state)
+	}
+	if len(resp.Results.Domains) != 1 || resp.Results.Domains[0].Host != "https://a.example" {
+		t.Fatalf("partial results %+v", resp.Results.Domains)
+	}
+
+	if code, _ = serve(t, s, http.MethodGet, "/requests/unknown", nil); code != http.StatusNotFound {
+		t.Fatalf("unknown request code %d", code)
+	}
+
+	s.config.RequestTTL = time.Nanosecond
+	if code, _ = serve(t, s, http.MethodGet, path, nil); code != http.StatusGone {
+		t.Fatalf("expired request code %d", code)
+	}
+}
+
+func TestServer_HandleCancelRequest(t *testing.T) {
+	s := newServer(memstore.New(), openConfig())
+	code, resp := serve(t, s, http.MethodGet, "/domains?hosts=https://a.example,https://b.example,https://c.example&max_domains=2&max_duration=1h", nil)
+	if code != http.StatusOK || resp.Results == nil || resp.Results.RequestCode == "" {
+		t.Fatalf("code %d: %+v", code, resp)
+	}
+	path := "/requests/" + resp.Results.RequestCode
+
+	code, resp = serve(t, s, http.MethodGet, path, nil)
+	if code != http.StatusOK || resp.Results == nil || resp.Results.Request == nil {
+		t.Fatalf("code %d: %+v", code, resp)
+	}
+	if state := resp.Results.Request; state.Total != 3 || state.Queued != 2 || state.Skipped != 1 || state.MaxDomains != 2 || state.Deadline == nil {
+		t.Fatalf("request over budget %+v", state)
+	}
+
+	code, resp = serve(t, s, http.MethodDelete, path, nil)
+	if code != http.StatusOK || resp.Results == nil || resp.Results.Request == nil {
+		t.Fatalf("code %d: %+v", code, resp)
+	}
+	if state := resp.Results.Request; state.Status != model.RequestCancelled || state.Queued != 0 || state.Skipped != 3 {
+		t.Fatalf("cancelled request %+v", state)
+	}
+	depth, err := s.store.QueueDepth(context.Background(), model.LaneUserRequest)
+	if err != nil || depth != 0 {
+		t.Fatalf("queue depth %d %v", depth, err)
+	}
+
+	if code, _ = serve(t, s, http.MethodDelete, path, nil); code != http.StatusConflict {
+		t.Fatalf("cancel of cancelled request code %d", code)
+	}
+	if code, _ = serve(t, s, http.MethodDelete, "/requests/unknown", nil); code != http.StatusNotFound {
+		t.Fatalf("cancel of unknown request code %d", code)
+	}
+	if code, _ = serve(t, s, http.MethodGet, "/domains?hosts=https://a.example&max_domains=-1", nil); code != http.StatusBadRequest {
+		t.Fatalf("invalid budget code %d", code)
+	}
+}
+
+func TestServer_HandleUploadRequest(t *testing.T) {
+	s := newServer(memstore.New(), openConfig())
+	s.config.UploadBatch = 2
+
+	upload := func(path, contentType string, body []byte) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		s.router.ServeHTTP(rec, req)
+		resp, err := apistructs.CreateFromJSON(rec.Body.String())
+		if err != nil || rec.Code != http.StatusAccepted || resp.Results == nil || resp.Results.RequestCode == "" {
+			t.Fatalf("code %d: %s", rec.Code, rec.Body.String())
+		}
+		s.ingests.Wait()
+		return resp.Results.RequestCode
+	}
+	state := func(code string) *model.RequestState {
+		t.Helper()
+		status, resp := serve(t, s, http.MethodGet, "/requests/"+code, nil)
+		if status != http.StatusOK || resp.Results == nil || resp.Results.Request == nil {
+			t.Fatalf("code %d: %+v", status, resp)
+		}
+		return resp.Results.Request
+	}
+
+	text := "# weekly import\nA.example\n\nhttps://b.example/path\nnot a host\nftp://c.example\na.example\n"
+	code := upload("/requests?max_domains=2", "text/plain", []byte(text))
+	if got := state(code); got.Status != model.RequestPending || got.Hosts != 2 || got.Queued != 2 || got.Skipped != 1 {
+		t.Fatalf("text upload %+v", got)
+	}
+	if _, err := s.store.Domain().FindByHost(context.Background(), "https://a.example", "https://b.example"); err != nil {
+		t.Fatal(err)
+	}
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("host,rank\nd.example,1\n\"e.example\",2\n")) //nolint:errcheck
+	zw.Close()
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, _ := mw.CreateFormFile("file", "top.csv.gz")
+	fw.Write(gz.Bytes()) //nolint:errcheck
+	mw.Close()
+	code = upload("/requests", mw.FormDataContentType(), form.Bytes())
+	if got := state(code); got.Hosts != 2 || got.Queued != 2 {
+		t.Fatalf("csv upload %+v", got)
+	}
+	domains, err := s.store.Domain().FindByHost(context.Background(), "https://d.example", "https://e.example")
+	if err != nil || len(domains) != 2 {
+		t.Fatalf("csv domains %+v %v", domains, err)
+	}
+
+	if status, _ := serve(t, s, http.MethodPost, "/requests?format=xml", nil); status != http.StatusBadRequest {
+		t.Fatalf("invalid format code %d", status)
+	}
+}
+
+func TestServer_HandleExport(t *testing.T) {
+	s := newServer(memstore.New(), openConfig())
+	ctx := context.Background()
+	domains, err := s.store.Domain().CreateWithHost(ctx, "https://a.example.com", "https://b.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkedAt := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	domains[0].ResponseCode = model.ResponseOk
+	domains[0].ContentLanguage = "en"
+	domains[0].TagsLanguages = []string{"fr", "en"}
+	domains[0].CheckedAt = &checkedAt
+	if err = s.store.Domain().Update(ctx, domains[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		s.router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/export?tld=com")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Fatalf("code %d: %v", rec.Code, rec.Header())
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || !reflect.DeepEqual(records[0], export.Columns) {
+		t.Fatalf("csv %v", records)
+	}
+	row := map[string]string{}
+	for i, column := range records[0] {
+		row[column] = records[1][i]
+	}
+	if row["host"] != "https://a.example.com" || row["content_lang"] != "EN" || row["tags_languages"] != "EN,FR" ||
+		row["checked_at"] != "2022-03-01T10:00:00.000Z" {
+		t.Fatalf("csv row %v", row)
+	}
+
+	rec = get("/export?format=jsonl")
+	var rows []export.Row
+	for dec := json.NewDecoder(rec.Body); dec.More(); {
+		var row export.Row
+		if err = dec.Decode(&row); err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) != 2 || rows[0].CheckedAt == nil || rows[1].CheckedAt != nil || rows[1].TLD != "org" {
+		t.Fatalf("jsonl rows %+v", rows)
+	}
+
+	rec = get("/export?format=parquet")
+	if body := rec.Body.Bytes(); rec.Code != http.StatusOK || !bytes.HasPrefix(body, []byte("PAR1")) || !bytes.HasSuffix(body, []byte("PAR1")) {
+		t.Fatalf("code %d: parquet of %d bytes", rec.Code, rec.Body.Len())
+	}
+
+	if rec = get("/export?format=xml"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown format: code %d", rec.Code)
+	}
+	if rec = get("/export?code=unknown"); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown request: code %d", rec.Code)
+	}
+}
+
+func TestServer_APIv1(t *testing.T) {
+	s := newServer(memstore.New(), openConfig())
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, apiPrefix+specPath, nil)
+	s.router.ServeHTTP(rec, req)
+	var doc struct {
+		OpenAPI    string                                `json:"openapi"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("code %d: %v %s", rec.Code, err, rec.Body)
+	}
+	if doc.OpenAPI == "" || doc.Paths["/domains/{id}"]["put"] == nil || doc.Paths["/jobs/{id}/domains"]["post"] == nil ||
+		doc.Components.Schemas["APIResponse"] == nil || doc.Components.Schemas["Domain"] == nil {
+		t.Fatalf("document %s", rec.Body)
+	}
+
+	code, resp := serve(t, s, http.MethodPost, apiPrefix+"/requests",
+		apistructs.SubmitRequest{Hosts: []string{"https://a.example"}, MaxDomains: 5})
+	if code != http.StatusAccepted || resp.Results == nil || resp.Results.RequestCode == "" {
+		t.Fatalf("submit: code %d: %+v", code, resp)
+	}
+	code, resp = serve(t, s, http.MethodGet, apiPrefix+"/requests/"+resp.Results.RequestCode, nil)
+	if code != http.StatusOK || resp.Results.Request == nil {
+		t.Fatalf("get request: code %d: %+v", code, resp)
+	}
+	code, resp = serve(t, s, http.MethodGet, apiPrefix+"/domains?tld=example", nil)
+	if code != http.StatusOK || len(resp.Results.Domains) != 1 {
+		t.Fatalf("search: code %d: %+v", code, resp)
+	}
+
+	testCases := []struct {
+		method, path string
+		payload      interface{}
+		expectedCode int
+		field        string
+	}{
+		{http.MethodGet, apiPrefix + "/domains/abc", nil, http.StatusBadRequest, "id"},
+		{http.MethodGet, apiPrefix + "/domains?response_code=maybe", nil, http.StatusBadRequest, "response_code"},
+		{http.MethodGet, apiPrefix + "/domains?checked_from=yesterday", nil, http.StatusBadRequest, "checked_from"},
+		{http.MethodPost, apiPrefix + "/domains", map[string]interface{}{"urls": "https://a.example"}, http.StatusUnprocessableEntity, "urls"},
+		{http.MethodPost, apiPrefix + "/domains", map[string]interface{}{}, http.StatusUnprocessableEntity, "urls"},
+		{http.MethodPost, apiPrefix + "/requests", map[string]interface{}{"urls": []interface{}{"https://a.example", 1}}, http.StatusUnprocessableEntity, "urls[1]"},
+		{http.MethodPost, apiPrefix + "/jobs", map[string]interface{}{"name": "n", "interval": "1h", "owner": "x"}, http.StatusUnprocessableEntity, "owner"},
+		{http.MethodPut, apiPrefix + "/proxies/1", map[string]interface{}{"ip": "10.0.0.1", "port": 80, "type": "https"}, http.StatusUnprocessableEntity, "port"},
+	}
+	for _, tc := range testCases {
+		code, resp := serve(t, s, tc.method, tc.path, tc.payload)
+		if code != tc.expectedCode || resp.Error == nil || len(resp.Error.Details) != 1 || resp.Error.Details[0].Field != tc.field {
+			t.Fatalf("%s %s: code %d, want %d: %+v", tc.method, tc.path, code, tc.expectedCode, resp.Error)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost, apiPrefix+"/domains", bytes.NewBufferString("{"))
+	s.router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest || rec.Header().Get("Deprecation") != "" {
+		t.Fatalf("bad json: code %d: %v", rec.Code, rec.Header())
+	}
+
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/domains", nil)
+	s.router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Deprecation") != "true" || rec.Header().Get("Link") == "" {
+		t.Fatalf("legacy route: code %d: %v", rec.Code, rec.Header())
+	}
+}
+
+func TestServer_APIKeys(t *testing.T) {
+	s := newServer(memstore.New(), config.New())
+
+	issue := func(tenant, scope string, dailyHosts, rateLimit int) string {
+		t.Helper()
+		key, secret, err := model.NewAPIKey(tenant, scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key.DailyHosts, key.RateLimit = dailyHosts, rateLimit
+		if err = s.store.APIKey().Create(context.Background(), key); err != nil {
+			t.Fatal(err)
+		}
+		return secret
+	}
+	admin := issue("ops", model.ScopeAdmin, 0, 0)
+	submit := issue("acme", model.ScopeSubmit, 3, 0)
+	read := issue("other", model.ScopeRead, 0, 0)
+	limited := issue("other", model.ScopeRead, 0, 2)
+
+	call := func(secret, method, path string, payload interface{}) (*httptest.ResponseRecorder, *apistructs.APIResponse) {
+		t.Helper()
+		b := &bytes.Buffer{}
+		if payload != nil {
+			json.NewEncoder(b).Encode(payload) //nolint:errcheck
+		}
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, b)
+		if secret != "" {
+			req.Header.Set("Authorization", "Bearer "+secret)
+		}
+		s.router.ServeHTTP(rec, req)
+		resp, err := apistructs.CreateFromJSON(rec.Body.String())
+		if err != nil {
+			t.Fatalf("Bad response format: %s [%v]", rec.Body.String(), err)
+		}
+		return rec, resp
+	}
+
+	if rec, _ := call("", http.MethodGet, apiPrefix+"/domains", nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("without key: code %d", rec.Code)
+	}
+	if rec, _ := call("lp_unknown", http.MethodGet, "/domains", nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unknown key: code %d", rec.Code)
+	}
+	if rec, _ := call("", http.MethodGet, apiPrefix+"/languages", nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("v1 without key: code %d", rec.Code)
+	}
+	if rec, _ := call("", http.MethodGet, "/metrics", nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("metrics without key: code %d", rec.Code)
+	}
+	doc := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, apiPrefix+specPath, nil)
+	if s.router.ServeHTTP(doc, req); doc.Code != http.StatusOK {
+		t.Fatalf("document: code %d", doc.Code)
+	}
+
+	testCases := []struct {
+		secret, method, path string
+		payload              interface{}
+		expectedCode         int
+	}{
+		{read, http.MethodGet, apiPrefix + "/domains", nil, http.StatusOK},
+		{read, http.MethodPost, apiPrefix + "/requests", apistructs.SubmitRequest{Hosts: []string{"https://a.example"}}, http.StatusForbidden},
+		{read, http.MethodGet, "/domains?hosts=https://a.example", nil, http.StatusForbidden},
+		{submit, http.MethodGet, apiPrefix + "/proxies", nil, http.StatusForbidden},
+		{submit, http.MethodGet, "/proxy", nil, http.StatusForbidden},
+		{admin, http.MethodGet, "/proxy", nil, http.StatusOK},
+		{submit, http.MethodGet, apiPrefix + "/keys", nil, http.StatusForbidden},
+	}
+	for _, tc := range testCases {
+		if rec, resp := call(tc.secret, tc.method, tc.path, tc.payload); rec.Code != tc.expectedCode {
+			t.Fatalf("%s %s: code %d, want %d: %+v", tc.method, tc.path, rec.Code, tc.expectedCode, resp.Error)
+		}
+	}
+
+	rec, resp := call(submit, http.MethodPost, apiPrefix+"/requests", apistructs.SubmitRequest{Hosts: []string{"https://a.example", "https://b.example"}})
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("submit: code %d: %+v", rec.Code, resp.Error)
+	}
+	code := resp.Results.RequestCode
+	if rec, resp = call(submit, http.MethodGet, apiPrefix+"/requests/"+code, nil); rec.Code != http.StatusOK || resp.Results.Request.Owner != "acme" {
+		t.Fatalf("own request: code %d: %+v", rec.Code, resp)
+	}
+	if rec, _ = call(read, http.MethodGet, apiPrefix+"/requests/"+code, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("request of other tenant: code %d", rec.Code)
+	}
+	if rec, _ = call(admin, http.MethodGet, apiPrefix+"/requests/"+code, nil); rec.Code != http.StatusOK {
+		t.Fatalf("admin: code %d", rec.Code)
+	}
+	rec, resp = call(submit, http.MethodPost, apiPrefix+"/requests", apistructs.SubmitRequest{Hosts: []string{"https://c.example", "https://d.example"}})
+	if rec.Code != http.StatusTooManyRequests || resp.Error.Code != apistructs.ErrorRateLimited {
+		t.Fatalf("over quota: code %d: %+v", rec.Code, resp.Error)
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec, _ = call(limited, http.MethodGet, apiPrefix+"/languages", nil); rec.Code != http.StatusOK {
+			t.Fatalf("call %d: code %d", i, rec.Code)
+		}
+	}
+	if rec, _ = call(limited, http.MethodGet, apiPrefix+"/languages", nil); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("over rate limit: code %d: %v", rec.Code, rec.Header())
+	}
+
+	rec, resp = call(admin, http.MethodPost, apiPrefix+"/keys", apistructs.APIKeyRequest{Tenant: "new", Scope: model.ScopeRead})
+	if rec.Code != http.StatusCreated || resp.Results.Secret == "" || len(resp.Results.APIKeys) != 1 {
+		t.Fatalf("issue: code %d: %+v", rec.Code, resp)
+	}
+	issued := resp.Results.Secret
+	if rec, _ = call(issued, http.MethodGet, apiPrefix+"/domains", nil); rec.Code != http.StatusOK {
+		t.Fatalf("issued key: code %d", rec.Code)
+	}
+	if rec, _ = call(admin, http.MethodPost, apiPrefix+"/keys", apistructs.APIKeyRequest{Tenant: "new", Scope: "owner"}); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("unknown scope: code %d", rec.Code)
+	}
+	if rec, _ = call(admin, http.MethodDelete, fmt.Sprintf("%s/keys/%d", apiPrefix, resp.Results.APIKeys[0].ID), nil); rec.Code != http.StatusOK {
+		t.Fatalf("revoke: code %d", rec.Code)
+	}
+	if rec, _ = call(issued, http.MethodGet, apiPrefix+"/domains", nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("revoked key: code %d", rec.Code)
+	}
+}
+
+func TestServer_AdminKey(t *testing.T) {
+	s := newServer(memstore.New(), config.New())
+	if code, _ := serve(t, s, http.MethodGet, apiPrefix+"/keys", nil); code != http.StatusUnauthorized {
+		t.Fatalf("keys are not required by default: code %d", code)
+	}
+
+	secret, err := issueAdminKey(context.Background(), s.store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, apiPrefix+"/keys", nil)
+	req.Header.Set("X-API-Key", secret)
+	s.router.ServeHTTP(rec, req)
+	resp, err := apistructs.CreateFromJSON(rec.Body.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || len(resp.Results.APIKeys) != 1 || resp.Results.APIKeys[0].Scope != model.ScopeAdmin {
+		t.Fatalf("admin key: code %d: %+v", rec.Code, resp)
+	}
+}
+
+func TestServer_DeleteDomains(t *testing.T) {
+	notified := make(chan model.RequestNotification, 1)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n model.RequestNotification
+		json.NewDecoder(r.Body).Decode(&n) //nolint:errcheck
+		notified <- n
+	}))
+	defer callback.Close()
+
+	s := newServer(memstore.New(), openConfig())
+	code, resp := serve(t, s, http.MethodGet, "/domains?hosts=https://a.example,https://b.example,https://c.org&callback="+callback.URL, nil)
+	if code != http.StatusOK || resp.Results == nil || resp.Results.RequestCode == "" {
+		t.Fatalf("code %d: %+v", code, resp)
+	}
+	requestCode := resp.Results.RequestCode
+	requestPath := apiPrefix + "/requests/" + requestCode
+	domains, err := s.store.Domain().FindByHost(context.Background(), "https://a.example")
+	if err != nil || len(domains) != 1 {
+		t.Fatalf("find a.example: %v %v", domains, err)
+	}
+
+	if code, _ = serve(t, s, http.MethodDelete, apiPrefix+"/domains", nil); code != http.StatusUnprocessableEntity {
+		t.Fatalf("delete without urls or filters code %d", code)
+	}
+	if code, _ = serve(t, s, http.MethodDelete, apiPrefix+"/domains?hard=maybe", nil); code != http.StatusBadRequest {
+		t.Fatalf("invalid hard code %d", code)
+	}
+	if code, _ = serve(t, s, http.MethodDelete, fmt.Sprintf("%s/domains/%d?hard=true", apiPrefix, domains[0].ID), nil); code != http.StatusOK {
+		t.Fatalf("delete domain code %d", code)
+	}
+	if code, _ = serve(t, s, http.MethodGet, fmt.Sprintf("%s/domains/%d", apiPrefix, domains[0].ID), nil); code != http.StatusNotFound {
+		t.Fatalf("deleted domain code %d", code)
+	}
+	if code, resp = serve(t, s, http.MethodGet, requestPath, nil); code != http.StatusOK || resp.Results.Request.Total != 2 {
+		t.Fatalf("request after delete code %d: %+v", code, resp.Results)
+	}
+
+	code, resp = serve(t, s, http.MethodDelete, apiPrefix+"/domains", apistructs.APIRequest{Hosts: []string{"https://b.example"}})
+	if code != http.StatusOK {
+		t.Fatalf("delete by urls code %d: %+v", code, resp)
+	}
+	if code, resp = serve(t, s, http.MethodDelete, apiPrefix+"/domains?tld=org", nil); code != http.StatusOK {
+		t.Fatalf("delete by filter code %d: %+v", code, resp)
+	}
+	s.ingests.Wait()
+	select {
+	case n := <-notified:
+		if n.Code != requestCode || n.Hosts != 0 {
+			t.Fatalf("notification %+v", n)
+		}
+	default:
+		t.Fatal("request emptied by the deletions is not notified")
+	}
+
+	code, resp = serve(t, s, http.MethodGet, "/domains", nil)
+	if code != http.StatusOK || len(resp.Results.Domains) != 0 {
+		t.Fatalf("domains after delete code %d: %+v", code, resp.Results)
+	}
+}
+
+func TestStart_UnknownStore(t *testing.T) {
+	cfg := config.New()
+	cfg.Type = "mongo"
+	if err := Start(cfg); err == nil || !strings.Contains(err.Error(), "sqlite") {
+		t.Fatalf("start with the unknown store: %v", err)
+	}
+}
@@ -0,0 +1,279 @@
+package apiserver
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"restapi_langparser/internal/apistructs"
+	"restapi_langparser/internal/model"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Formats of the uploaded host lists
+const (
+	uploadText = "text" // one host per line
+	uploadCSV  = "csv"  // host in the first column
+)
+
+// handleUploadRequest accepts the host list as the body or as the file field
+// of the multipart form. The file is spooled to disk and the request code is
+// returned at once, the hosts are added to the request in background while it
+// is ingesting.
+func (s *server) handleUploadRequest(c *gin.Context) {
+	resp, writeResp := newResp(c)
+	defer writeResp()
+
+	header, err := requestBudget(c)
+	if err != nil {
+		resp.CreateError(http.StatusBadRequest, "%s", err)
+		return
+	}
+	header.Callback = c.Query("callback")
+	header.Status = model.RequestIngesting
+	header.Owner = tenantOf(c)
+
+	// the hosts over the quota of the key are skipped like the ones over the budget
+	left, err := s.quotaLeft(c)
+	if err != nil {
+		fail(resp, err)
+		return
+	}
+	if left == 0 {
+		fail(resp, fmt.Errorf("%w: no hosts are left today", errQuota))
+		return
+	}
+	if left > 0 && (header.MaxDomains == 0 || header.MaxDomains > left) {
+		header.MaxDomains = left
+	}
+
+	body, name, err := uploadBody(c)
+	if err != nil {
+		resp.CreateError(http.StatusBadRequest, "%s", err)
+		return
+	}
+	format, err := uploadFormat(c, name)
+	if err != nil {
+		resp.CreateError(http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	file, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		fail(resp, err)
+		return
+	}
+	spooled := false
+	defer func() {
+		if !spooled {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+	if _, err = io.Copy(file, http.MaxBytesReader(c.Writer, body, s.config.MaxUploadSize)); err != nil {
+		resp.CreateError(http.StatusBadRequest, "read upload: %s", err)
+		return
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		fail(resp, err)
+		return
+	}
+
+	if s.ingestCtx.Err() != nil {
+		fail(resp, errUnavailable)
+		return
+	}
+	request, err := s.store.CreateRequest(c.Request.Context(), header, nil)
+	if err != nil {
+		fail(resp, err)
+		return
+	}
+	spooled = true
+	s.ingests.Add(1)
+	go s.ingest(*request, file, format, apiKeyOf(c))
+
+	resp.Status = http.StatusAccepted
+	resp.Results = &apistructs.APIResults{RequestCode: request.Code}
+	resp.CreateMessage("Upload accepted, the hosts are being added to the request")
+}
+
+// uploadBody returns the file field of the multipart form or the request body with the file name if it is known
+func uploadBody(c *gin.Context) (io.ReadCloser, string, error) {
+	mediaType, _, _ := mime.ParseMediaType(c.ContentType())
+	if mediaType != "multipart/form-data" {
+		return c.Request.Body, "", nil
+	}
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, "", err
+	}
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, "", errors.New("no file field in the form")
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if part.FormName() == "file" {
+			return part, part.FileName(), nil
+		}
+	}
+}
+
+// uploadFormat takes the format from the parameter, the content type or the file extension, text by default
+func uploadFormat(c *gin.Context, name string) (string, error) {
+	format := c.Query("format")
+	switch {
+	case format != "":
+	case c.ContentType() == "text/csv" || strings.HasSuffix(strings.TrimSuffix(strings.ToLower(name), ".gz"), ".csv"):
+		format = uploadCSV
+	default:
+		format = uploadText
+	}
+	if format != uploadText && format != uploadCSV {
+		return "", fmt.Errorf("invalid format %q, want %s or %s", format, uploadText, uploadCSV)
+	}
+	return format, nil
+}
+
+// ingest adds the hosts of the spooled file to the request and finishes it,
+// the added hosts are charged to the quota of the key. The batches over the
+// quota are dropped, the hosts of the parallel uploads are charged in turn.
+func (s *server) ingest(request model.RequestHeader, file *os.File, format string, key *model.APIKey) {
+	defer s.ingests.Done()
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	batches, err := newHostBatches(file, format, s.config.UploadBatch, request.MaxDomains)
+	if err == nil {
+		_, err = s.store.IngestRequest(s.ingestCtx, request.Code, key, batches.next)
+	}
+	if errors.Is(err, errQuota) {
+		logrus.Warnf("Ingest request %s stopped: %s", request.Code, err)
+	} else if err != nil {
+		logrus.Errorf("Ingest request %s fail: %s", request.Code, err)
+	}
+	if batches != nil && batches.rejected > 0 {
+		logrus.Warnf("Ingest request %s: invalid hosts skipped: %d", request.Code, batches.rejected)
+	}
+
+	// the hosts added before the failure are crawled anyway
+	if _, err = s.store.FinishIngest(context.Background(), request.Code, batches.overBudget()); err != nil && !errors.Is(err, model.ErrRequestFinished) {
+		logrus.Errorf("Finish ingest of request %s fail: %s", request.Code, err)
+	}
+}
+
+// waitIngests waits for the running uploads, they are aborted when ctx is done
+func (s *server) waitIngests(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		s.ingests.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.stopIngest()
+		<-done
+	}
+}
+
+// hostBatches reads the normalized hosts of the file by batches, the hosts
+// over the max count are counted only
+type hostBatches struct {
+	scan     func() (string, error)
+	size     int
+	max      int
+	taken    int
+	over     int
+	rejected int
+}
+
+func newHostBatches(r io.Reader, format string, size, max int) (*hostBatches, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(gz)
+	}
+
+	b := &hostBatches{size: size, max: max}
+	if format == uploadCSV {
+		reader := csv.NewReader(br)
+		reader.FieldsPerRecord = -1
+		reader.LazyQuotes = true
+		reader.ReuseRecord = true
+		b.scan = func() (string, error) {
+			record, err := reader.Read()
+			if err != nil || len(record) == 0 {
+				return "", err
+			}
+			return record[0], nil
+		}
+		return b, nil
+	}
+
+	scanner := bufio.NewScanner(br)
+	b.scan = func() (string, error) {
+		if scanner.Scan() {
+			return scanner.Text(), nil
+		}
+		if err := scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return b, nil
+}
+
+// next returns the next batch of the hosts, io.EOF after the last one. The
+// comments and the csv header are skipped, the invalid hosts are counted.
+func (b *hostBatches) next() ([]string, error) {
+	batch := make([]string, 0, b.size)
+	for len(batch) < b.size {
+		entry, err := b.scan()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if entry = strings.TrimSpace(entry); entry == "" || strings.HasPrefix(entry, "#") || strings.EqualFold(entry, "host") {
+			continue
+		}
+		host, ok := model.NormalizeHost(entry)
+		if !ok {
+			b.rejected++
+			continue
+		}
+		if b.max > 0 && b.taken >= b.max {
+			b.over++
+			continue
+		}
+		b.taken++
+		batch = append(batch, host)
+	}
+	if len(batch) == 0 {
+		return nil, io.EOF
+	}
+	return batch, nil
+}
+
+func (b *hostBatches) overBudget() int {
+	if b == nil {
+		return 0
+	}
+	return b.over
+}
@@ -0,0 +1,212 @@
+package apiserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"restapi_langparser/internal/apistructs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// validate checks the parameters and the JSON body of the request against the
+// route. The invalid parameters answer 400 and the invalid body answers 422,
+// both with the details per field.
+func (s *server) validate(r *route) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		resp := &apistructs.APIResponse{}
+		abort := func() {
+			c.AbortWithStatusJSON(resp.Status, resp)
+		}
+
+		resp.CreateError(http.StatusBadRequest, "invalid parameters")
+		for i := range r.params {
+			p := &r.params[i]
+			value := c.Query(p.Name)
+			if p.In == "path" {
+				value = c.Param(p.Name)
+			}
+			if value == "" {
+				if p.Required {
+					resp.AddDetail(p.Name, "", "is required")
+				}
+				continue
+			}
+			if msg := p.check(value); msg != "" {
+				resp.AddDetail(p.Name, value, msg)
+			}
+		}
+		if len(resp.Error.Details) > 0 {
+			abort()
+			return
+		}
+
+		if r.bodySchema == nil {
+			return
+		}
+		if c.Request.Body == nil {
+			c.Request.Body = http.NoBody
+		}
+		raw, err := io.ReadAll(c.Request.Body)
+		c.Request.Body.Close()
+		if err != nil {
+			resp.CreateError(http.StatusBadRequest, "invalid request body: %s", err)
+			abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(raw))
+		if r.optional && len(bytes.TrimSpace(raw)) == 0 {
+			return
+		}
+
+		var body interface{}
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		if err := decoder.Decode(&body); err != nil {
+			resp.CreateError(http.StatusBadRequest, "invalid request body: %s", err)
+			abort()
+			return
+		}
+		resp.CreateError(http.StatusUnprocessableEntity, "invalid request body")
+		s.checkValue(r.bodySchema, body, "", resp)
+		if len(resp.Error.Details) > 0 {
+			abort()
+		}
+	}
+}
+
+// check returns the problem of the parameter value, empty if it is valid
+func (p *param) check(value string) string {
+	if p.time {
+		if _, err := time.Parse(time.RFC3339, value); err == nil {
+			return ""
+		}
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return "is neither RFC 3339 time nor date"
+		}
+		return ""
+	}
+	switch p.Schema.Type {
+	case "integer":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "must be an integer"
+		}
+		if p.Schema.Minimum != nil && float64(n) < *p.Schema.Minimum {
+			return fmt.Sprintf("must be at least %g", *p.Schema.Minimum)
+		}
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return "must be a boolean"
+		}
+	}
+	if len(p.Schema.Enum) > 0 && !contains(p.Schema.Enum, value) {
+		return "must be one of " + strings.Join(p.Schema.Enum, ", ")
+	}
+	return ""
+}
+
+// checkValue adds the details of the value which does not match the schema,
+// the field is the path of the value in the body like urls[1]
+func (s *server) checkValue(sc *schema, value interface{}, field string, resp *apistructs.APIResponse) {
+	if sc.Ref != "" {
+		sc = s.spec.Components.Schemas[strings.TrimPrefix(sc.Ref, "#/components/schemas/")]
+	}
+	if sc == nil || sc.Type == "" {
+		return
+	}
+	if value == nil {
+		if !sc.Nullable {
+			resp.AddDetail(field, "null", "must not be null")
+		}
+		return
+	}
+
+	switch sc.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			resp.AddDetail(field, fmt.Sprint(value), "must be an object")
+			return
+		}
+		for _, name := range sc.Required {
+			if _, ok := object[name]; !ok {
+				resp.AddDetail(join(field, name), "", "is required")
+			}
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := sc.Properties[name]
+			if !ok {
+				if sc.AdditionalProperties != nil && !*sc.AdditionalProperties {
+					resp.AddDetail(join(field, name), "", "is not a known property")
+				}
+				continue
+			}
+			s.checkValue(property, object[name], join(field, name), resp)
+		}
+	case "array":
+		list, ok := value.([]interface{})
+		if !ok {
+			resp.AddDetail(field, fmt.Sprint(value), "must be an array")
+			return
+		}
+		if len(list) < sc.MinItems {
+			resp.AddDetail(field, "", fmt.Sprintf("must have at least %d items", sc.MinItems))
+		}
+		if sc.Items != nil {
+			for i, item := range list {
+				s.checkValue(sc.Items, item, fmt.Sprintf("%s[%d]", field, i), resp)
+			}
+		}
+	case "integer", "number":
+		n, ok := value.(json.Number)
+		if !ok {
+			resp.AddDetail(field, fmt.Sprint(value), "must be a "+sc.Type)
+			return
+		}
+		f, err := n.Float64()
+		if _, intErr := n.Int64(); err != nil || sc.Type == "integer" && intErr != nil {
+			resp.AddDetail(field, n.String(), "must be a "+sc.Type)
+			return
+		}
+		if sc.Minimum != nil && f < *sc.Minimum {
+			resp.AddDetail(field, n.String(), fmt.Sprintf("must be at least %g", *sc.Minimum))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			resp.AddDetail(field, fmt.Sprint(value), "must be a boolean")
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			resp.AddDetail(field, fmt.Sprint(value), "must be a string")
+			return
+		}
+		if sc.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				resp.AddDetail(field, str, "must be an RFC 3339 time")
+			}
+		}
+		if len(sc.Enum) > 0 && !contains(sc.Enum, str) {
+			resp.AddDetail(field, str, "must be one of "+strings.Join(sc.Enum, ", "))
+		}
+	}
+}
+
+func join(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
@@ -0,0 +1,162 @@
+package apiserver
+
+import (
+	"net/http"
+	"restapi_langparser/internal/apistructs"
+	"restapi_langparser/internal/model"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func (s *server) handleAddWatchlist(c *gin.Context) {
+	resp, writeResp := newResp(c)
+	defer writeResp()
+
+	var req apistructs.APIRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		resp.CreateError(http.StatusBadRequest, "%s", err)
+		return
+	}
+	interval, err := time.ParseDuration(req.Interval)
+	if err != nil || interval <= 0 {
+		resp.CreateError(http.StatusUnprocessableEntity, "invalid interval %q", req.Interval)
+		return
+	}
+	if invalidHosts(resp, "urls", req.Hosts) {
+		return
+	}
+	if err = s.useQuota(c, len(req.Hosts)); err != nil {
+		fail(resp, err)
+		return
+	}
+
+	watchlist := &model.Watchlist{
+		Name:     req.Name,
+		Owner:    tenantOf(c),
+		Interval: int64(interval.Seconds()),
+	}
+	if req.Callback != nil {
+		watchlist.Callback = *req.Callback
+	}
+	if len(req.Hosts) > 0 {
+		domains := model.CreateDomainsList(req.Hosts)
+		if err = s.store.AddDomains(c.Request.Context(), &domains); err != nil {
+			fail(resp, err)
+			return
+		}
+		watchlist.Domains = domains
+	}
+
+	if err = s.store.Watchlist().Create(c.Request.Context(), watchlist); err != nil {
+		fail(resp, err)
+		return
+	}
+	resp.Results = &apistructs.APIResults{
+		Watchlists: []model.Watchlist{*watchlist},
+	}
+}
+
+func (s *server) handleGetWatchlists(c *gin.Context) {
+	resp, writeResp := newResp(c)
+	defer writeResp()
+
+	lists, err := s.store.Watchlist().Read(c.Request.Context())
+	if err != nil {
+		fail(resp, err)
+		return
+	}
+	owned := make([]model.Watchlist, 0, len(lists))
+	for _, watchlist := range lists {
+		if owns(c, watchlist.Owner) {
+			owned = append(owned, watchlist)
+		}
+	}
+	resp.Results = &apistructs.APIResults{
+		Watchlists: owned,
+	}
+}
+
+func (s *server) handleGetWatchlist(c *gin.Context) {
+	resp, writeResp := newResp(c)
+	defer writeResp()
+
+	watchlist, err := s.getWatchlist(c)
+	if err != nil {
+		fail(resp, err)
+		return
+	}
+	resp.Results = &apistructs.APIResults{
+		Watchlists: []model.Watchlist{*watchlist},
+	}
+}
+
+func (s *server) handleDeleteWatchlist(c *gin.Context) {
+	resp, writeResp := newResp(c)
+	defer writeResp()
+
+	watchlist, err := s.getWatchlist(c)
+	if err != nil {
+		fail(resp, err)
+		return
+	}
+	if err = s.store.Watchlist().Delete(c.Request.Context(), watchlist.ID); err != nil {
+		fail(resp, err)
+		return
+	}
+	resp.CreateMessage("watchlist %s deleted", watchlist.Name)
+}
+
+// handleAddWatchlistDomains adds the urls of the request to the watchlist
+func (s *server) handleAddWatchlistDomains(c *gin.Context) {
+	resp, writeResp := newResp(c)
+	defer writeResp()
+
+	var req apistructs.APIRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		resp.CreateError(http.StatusBadRequest, "invalid request body: %s", err)
+		return
+	}
+	if len(req.Hosts) == 0 {
+		resp.CreateError(http.StatusUnprocessableEntity, "urls are required")
+		return
+	}
+	if invalidHosts(resp, "urls", req.Hosts) {
+		return
+	}
+	watchlist, err := s.getWatchlist(c)
+	if err != nil {
+		fail(resp, err)
+		return
+	}
+	if err = s.useQuota(c, len(req.Hosts)); err != nil {
+		fail(resp, err)
+		return
+	}
+
+	domains := model.CreateDomainsList(req.Hosts)
+	if err = s.store.AddDomains(c.Request.Context(), &domains); err != nil {
+		fail(resp, err)
+		return
+	}
+	if err = s.store.Watchlist().AddDomains(c.Request.Context(), watchlist.ID, domains); err != nil {
+		fail(resp, err)
+		return
+	}
+	resp.CreateMessage("%d domains added to watchlist %s", len(domains), watchlist.Name)
+}
+
+// getWatchlist returns the watchlist of the id parameter, the watchlists of the other tenants are not found
+func (s *server) getWatchlist(c *gin.Context) (*model.Watchlist, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return nil, invalid("invalid watchlist id %q", c.Param("id"))
+	}
+	watchlist, err := s.store.Watchlist().FindByID(c.Request.Context(), uint(id))
+	if err == nil && !owns(c, watchlist.Owner) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return watchlist, err
+}
@@ -1,9 +1,33 @@
 package apistructs
 
-import "restapi_langparser/internal/model"
+import (
+	"restapi_langparser/internal/model"
+	"time"
+)
 
 type APIRequest struct {
 	Callback *string       `json:"callback,omitempty"`
 	Hosts    []string      `json:"urls,omitempty"`
 	Proxy    []model.Proxy `json:"proxy,omitempty"`
+	Name     string        `json:"name,omitempty"`     // watchlist name
+	Interval string        `json:"interval,omitempty"` // watchlist recrawl interval, like 24h
+}
+
+// SubmitRequest is the body of the request submission
+type SubmitRequest struct {
+	Hosts       []string   `json:"urls"`
+	Callback    string     `json:"callback,omitempty"`
+	Refresh     bool       `json:"refresh,omitempty"`     // crawl again even if the results are ready
+	MaxDomains  int        `json:"maxDomains,omitempty"`  // hosts over it are skipped, 0 is unlimited
+	Deadline    *time.Time `json:"deadline,omitempty"`    // the request is stopped after it
+	MaxDuration string     `json:"maxDuration,omitempty"` // like 2h, the earlier of it and the deadline wins
+}
+
+// APIKeyRequest is the body of the key issue
+type APIKeyRequest struct {
+	Tenant     string `json:"tenant"`
+	Scope      string `json:"scope"` // read, submit or admin
+	Name       string `json:"name,omitempty"`
+	DailyHosts int    `json:"dailyHosts,omitempty"` // hosts submitted per UTC day, 0 is unlimited
+	RateLimit  int    `json:"rateLimit,omitempty"`  // api calls per minute, 0 is unlimited
 }
@@ -3,24 +3,108 @@ package apistructs
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"restapi_langparser/internal/model"
 )
 
+// Codes of the errors, the clients switch on them instead of the messages
+const (
+	ErrorBadRequest       = "bad_request"
+	ErrorUnauthorized     = "unauthorized"
+	ErrorForbidden        = "forbidden"
+	ErrorNotFound         = "not_found"
+	ErrorMethodNotAllowed = "method_not_allowed"
+	ErrorConflict         = "conflict"
+	ErrorGone             = "gone"
+	ErrorTooLarge         = "payload_too_large"
+	ErrorValidation       = "validation_failed" // some items of the request are invalid, see the details
+	ErrorRateLimited      = "rate_limited"
+	ErrorInternal         = "internal"
+	ErrorUnavailable      = "unavailable" // the store can not be reached or the server is shutting down
+)
+
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            ErrorBadRequest,
+	http.StatusUnauthorized:          ErrorUnauthorized,
+	http.StatusForbidden:             ErrorForbidden,
+	http.StatusNotFound:              ErrorNotFound,
+	http.StatusMethodNotAllowed:      ErrorMethodNotAllowed,
+	http.StatusConflict:              ErrorConflict,
+	http.StatusGone:                  ErrorGone,
+	http.StatusRequestEntityTooLarge: ErrorTooLarge,
+	http.StatusUnprocessableEntity:   ErrorValidation,
+	http.StatusTooManyRequests:       ErrorRateLimited,
+	http.StatusServiceUnavailable:    ErrorUnavailable,
+}
+
+// ErrorCode returns the error code of the HTTP status
+func ErrorCode(status int) string {
+	if code, ok := errorCodes[status]; ok {
+		return code
+	}
+	if status < http.StatusInternalServerError {
+		return ErrorBadRequest
+	}
+	return ErrorInternal
+}
+
 type APIResponse struct {
 	Message *APIMessage `json:"Message,omitempty"`
-	Error   *APIMessage `json:"Error,omitempty"`
+	Error   *APIError   `json:"Error,omitempty"`
 	Results *APIResults `json:"Results,omitempty"`
 	Status  int         `json:"-"`
 }
 
 type APIResults struct {
-	Domains     []model.Domain `json:"Domains,omitempty"`
-	Proxy       []model.Proxy  `json:"Proxy,omitempty"`
-	RequestCode string         `json:"RequestCode,omitempty"`
+	Domains     []model.Domain        `json:"Domains,omitempty"`
+	Proxy       []ProxyInfo           `json:"Proxy,omitempty"`
+	RequestCode string                `json:"RequestCode,omitempty"`
+	Workers     []model.Worker        `json:"Workers,omitempty"`
+	History     []model.DomainHistory `json:"History,omitempty"`
+	Diff        *model.LanguageDiff   `json:"Diff,omitempty"`
+	Watchlists  []model.Watchlist     `json:"Watchlists,omitempty"`
+	Languages   []model.LanguageCount `json:"Languages,omitempty"`
+	Request     *model.RequestState   `json:"Request,omitempty"`
+	APIKeys     []model.APIKey        `json:"APIKeys,omitempty"`
+	Secret      string                `json:"Secret,omitempty"` // secret of the issued key, it is not stored
+
+	*model.PageInfo // next_cursor and total of the list pages
+}
+
+// ProxyInfo is the listed proxy, its password is never returned
+type ProxyInfo struct {
+	ID    int    `json:"id"`
+	IP    string `json:"ip"`
+	Port  string `json:"port"`
+	Login string `json:"login,omitempty"`
+	Type  string `json:"type"`
+}
+
+// NewProxyInfo returns the proxies without the passwords
+func NewProxyInfo(list []model.Proxy) []ProxyInfo {
+	infos := make([]ProxyInfo, len(list))
+	for i, p := range list {
+		infos[i] = ProxyInfo{ID: p.ID, IP: p.IP, Port: p.Port, Login: p.Login, Type: p.Scheme}
+	}
+	return infos
 }
 
 type APIMessage string
 
+// APIError is the error of the failed request
+type APIError struct {
+	Code    string        `json:"code"`
+	Message string        `json:"message"`
+	Details []ErrorDetail `json:"details,omitempty"`
+}
+
+// ErrorDetail is the problem of one item of the request, like the field or the host
+type ErrorDetail struct {
+	Field   string `json:"field,omitempty"`
+	Value   string `json:"value,omitempty"`
+	Message string `json:"message"`
+}
+
 func CreateFromJSON(str string) (*APIResponse, error) {
 	res := &APIResponse{}
 	err := json.Unmarshal([]byte(str), res)
@@ -30,9 +114,21 @@ func CreateFromJSON(str string) (*APIResponse, error) {
 	return res, nil
 }
 
-func (r *APIResponse) CreateError(format string, args ...interface{}) {
-	msg := APIMessage(fmt.Sprintf(format, args...))
-	r.Error = &msg
+// CreateError sets the status of the failed response and its error
+func (r *APIResponse) CreateError(status int, format string, args ...interface{}) {
+	r.Status = status
+	r.Error = &APIError{
+		Code:    ErrorCode(status),
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// AddDetail adds the problem of the item to the error of the response
+func (r *APIResponse) AddDetail(field, value, message string) {
+	if r.Error == nil {
+		r.CreateError(http.StatusUnprocessableEntity, "invalid request")
+	}
+	r.Error.Details = append(r.Error.Details, ErrorDetail{Field: field, Value: value, Message: message})
 }
 
 func (r *APIResponse) CreateMessage(format string, args ...interface{}) {
@@ -1,21 +1,41 @@
 package config
 
 import (
+	"fmt"
+	"os"
 	"time"
 )
 
 const (
-	MemStore StoreType = "memstore"
-	SQLStore StoreType = "sqlstore"
+	MemStore    StoreType = "memstore"
+	SQLStore    StoreType = "sqlstore"
+	SQLiteStore StoreType = "sqlite" // single node, DatabaseURL is the path of the database file
 
 	defaultThreadsPerProxy           = 10
 	defaultMaxThreads                = 100
 	defaultMaxRequestToBadDomain     = 60 // %
+	defaultQueueWeight               = 3
+	defaultBadDomainWeight           = 1
 	defaultResponseTimeout           = time.Second * 15
+	defaultQueueLeaseTimeout         = time.Minute * 5
+	defaultHeartbeatInterval         = time.Second * 15
+	defaultShutdownTimeout           = time.Second * 30
+	defaultDomainMaxAge              = time.Hour * 24 * 30
+	defaultFreshnessSweepInterval    = time.Minute * 10
+	defaultFreshnessSweepBatch       = 1000
+	defaultWatchlistSweepInterval    = time.Minute
 	defaultDeadProxyRefresh          = time.Minute * 70
 	defaultDomainWithErrorRefresh    = time.Hour * 72
 	defaultDomainWithDNSErrorRefresh = time.Hour * 336
 	defaultRebannedDomainRefresh     = time.Hour * 72
+	defaultRequestTTL                = time.Hour * 72
+	defaultExpiredRequestTTL         = time.Hour * 24 * 30
+	defaultRequestSweepInterval      = time.Minute * 10
+	defaultRequestSweepBatch         = 1000
+	defaultUploadBatch               = 1000
+	defaultMaxUploadSize             = 1 << 30
+	defaultPageSize                  = 10
+	defaultMaxPageSize               = 1000
 )
 
 type StoreType string
@@ -25,15 +45,35 @@ type Config struct {
 	BindAddr    string `toml:"bind_addr"`
 	DatabaseURL string `toml:"database_url"`
 
-	UseIP                     bool
+	UseIP                     bool // crawl without proxy besides the proxies, without proxies it is always used
 	ThreadsPerProxy           uint
 	MaxThreads                uint
 	MaxRequestToBadDomain     uint
+	QueueWeight               uint // share of the list queue when user requests are done
+	BadDomainWeight           uint // share of the bad domains retries when user requests are done
 	ResponseTimeout           time.Duration
+	WorkerID                  string                   // owner of the queue leases taken by this process
+	QueueLeaseTimeout         time.Duration            // time after which a domain leased by a dead worker is crawled again
+	HeartbeatInterval         time.Duration            // worker is considered dead after three missed heartbeats
+	ShutdownTimeout           time.Duration            // time to finish running requests and crawls on shutdown
+	DomainMaxAge              time.Duration            // successfully crawled domain is recrawled after it, 0 disables
+	GroupMaxAge               map[string]time.Duration // DomainMaxAge of the refresh groups
+	FreshnessSweepInterval    time.Duration
+	FreshnessSweepBatch       int // max stale domains queued by one sweep
+	WatchlistSweepInterval    time.Duration
 	DeadProxyRefresh          time.Duration
 	DomainWithErrorRefresh    time.Duration
 	DomainWithDNSErrorRefresh time.Duration
 	RebannedDomainRefresh     time.Duration
+	RequestTTL                time.Duration // requests expire after it, 0 keeps them forever
+	ExpiredRequestTTL         time.Duration // expired codes answer 410 Gone for it, then they are unknown
+	RequestSweepInterval      time.Duration
+	RequestSweepBatch         int   // requests expired or deleted by one statement of the sweep
+	UploadBatch               int   // hosts of the uploaded file added by one transaction
+	MaxUploadSize             int64 // bytes of the uploaded file
+	PageSize                  int   // rows of the list page when the size is not given
+	MaxPageSize               int   // larger page sizes are cut to it
+	RequireAPIKey             bool  // the clients authenticate with the api keys, false opens the api for development
 }
 
 func New() *Config {
@@ -44,10 +84,37 @@ func New() *Config {
 		ThreadsPerProxy:           defaultThreadsPerProxy,
 		MaxThreads:                defaultMaxThreads,
 		MaxRequestToBadDomain:     defaultMaxRequestToBadDomain,
+		QueueWeight:               defaultQueueWeight,
+		BadDomainWeight:           defaultBadDomainWeight,
 		ResponseTimeout:           defaultResponseTimeout,
+		WorkerID:                  defaultWorkerID(),
+		QueueLeaseTimeout:         defaultQueueLeaseTimeout,
+		HeartbeatInterval:         defaultHeartbeatInterval,
+		ShutdownTimeout:           defaultShutdownTimeout,
+		DomainMaxAge:              defaultDomainMaxAge,
+		FreshnessSweepInterval:    defaultFreshnessSweepInterval,
+		FreshnessSweepBatch:       defaultFreshnessSweepBatch,
+		WatchlistSweepInterval:    defaultWatchlistSweepInterval,
 		DeadProxyRefresh:          defaultDeadProxyRefresh,
 		DomainWithErrorRefresh:    defaultDomainWithErrorRefresh,
 		DomainWithDNSErrorRefresh: defaultDomainWithDNSErrorRefresh,
 		RebannedDomainRefresh:     defaultRebannedDomainRefresh,
+		RequestTTL:                defaultRequestTTL,
+		ExpiredRequestTTL:         defaultExpiredRequestTTL,
+		RequestSweepInterval:      defaultRequestSweepInterval,
+		RequestSweepBatch:         defaultRequestSweepBatch,
+		UploadBatch:               defaultUploadBatch,
+		MaxUploadSize:             defaultMaxUploadSize,
+		PageSize:                  defaultPageSize,
+		MaxPageSize:               defaultMaxPageSize,
+		RequireAPIKey:             true,
 	}
 }
+
+func defaultWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
@@ -0,0 +1,228 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"restapi_langparser/internal/model"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Formats of the export
+const (
+	CSV     = "csv"
+	JSONL   = "jsonl"
+	Parquet = "parquet"
+)
+
+const (
+	timeLayout     = "2006-01-02T15:04:05.000Z07:00" // RFC 3339 in UTC with milliseconds
+	langSeparator  = ","
+	rowGroupSize   = 8 << 20 // bytes of the parquet rows buffered before they are written
+	parquetWriters = 1
+)
+
+// Columns are the columns of the export in all formats. Languages are upper
+// case codes joined by comma in the alphabetical order, times are UTC.
+var Columns = []string{
+	"id", "host", "tld", "response_code", "error_class", "error_count", "content_lang",
+	"tags_languages", "sitemap_languages", "blocker_name", "ip", "refresh_group", "max_age",
+	"checked_at", "created_at", "updated_at",
+}
+
+// Writer writes the domains as the rows of the export
+type Writer interface {
+	Write(domain model.Domain) error
+	// Close flushes the buffered rows and writes the footer, the underlying writer is not closed
+	Close() error
+}
+
+// NewWriter returns the writer of the format
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case CSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(Columns); err != nil {
+			return nil, err
+		}
+		return &csvWriter{w: cw}, nil
+	case JSONL:
+		return &jsonlWriter{enc: json.NewEncoder(w)}, nil
+	case Parquet:
+		pw, err := writer.NewParquetWriterFromWriter(w, new(parquetRow), parquetWriters)
+		if err != nil {
+			return nil, err
+		}
+		pw.RowGroupSize = rowGroupSize
+		return &parquetWriter{w: pw}, nil
+	}
+	return nil, unknownFormat(format)
+}
+
+// ContentType returns the media type of the format, the error for the unknown format
+func ContentType(format string) (string, error) {
+	switch format {
+	case CSV:
+		return "text/csv; charset=utf-8", nil
+	case JSONL:
+		return "application/x-ndjson", nil
+	case Parquet:
+		return "application/vnd.apache.parquet", nil
+	}
+	return "", unknownFormat(format)
+}
+
+func unknownFormat(format string) error {
+	return fmt.Errorf("unknown export format %q, expected %s, %s or %s", format, CSV, JSONL, Parquet)
+}
+
+// Row is the domain in the export columns
+type Row struct {
+	ID               uint    `json:"id"`
+	Host             string  `json:"host"`
+	TLD              string  `json:"tld"`
+	ResponseCode     string  `json:"response_code"`
+	ErrorClass       string  `json:"error_class"`
+	ErrorCount       int     `json:"error_count"`
+	ContentLang      string  `json:"content_lang"`
+	TagsLanguages    string  `json:"tags_languages"`
+	SitemapLanguages string  `json:"sitemap_languages"`
+	BlockerName      string  `json:"blocker_name"`
+	IP               string  `json:"ip"`
+	RefreshGroup     string  `json:"refresh_group"`
+	MaxAge           int64   `json:"max_age"`
+	CheckedAt        *string `json:"checked_at"` // null if the domain is not crawled
+	CreatedAt        string  `json:"created_at"`
+	UpdatedAt        string  `json:"updated_at"`
+}
+
+// NewRow returns the export row of the domain
+func NewRow(domain model.Domain) Row {
+	row := Row{
+		ID:               domain.ID,
+		Host:             domain.Host,
+		TLD:              domain.TLD,
+		ResponseCode:     domain.ResponseCode,
+		ErrorClass:       domain.ErrorClass,
+		ErrorCount:       domain.ErrorCount,
+		ContentLang:      strings.ToUpper(domain.ContentLanguage),
+		TagsLanguages:    strings.Join(domain.TagsLanguages, langSeparator),
+		SitemapLanguages: strings.Join(domain.SitemapLanguages, langSeparator),
+		BlockerName:      domain.BlockerName,
+		IP:               domain.IP,
+		RefreshGroup:     domain.Group,
+		MaxAge:           domain.MaxAge,
+		CreatedAt:        formatTime(domain.CreatedAt),
+		UpdatedAt:        formatTime(domain.UpdatedAt),
+	}
+	if domain.CheckedAt != nil {
+		checkedAt := formatTime(*domain.CheckedAt)
+		row.CheckedAt = &checkedAt
+	}
+	return row
+}
+
+// record returns the values of the row in the order of Columns
+func (r Row) record() []string {
+	checkedAt := ""
+	if r.CheckedAt != nil {
+		checkedAt = *r.CheckedAt
+	}
+	return []string{
+		strconv.FormatUint(uint64(r.ID), 10), r.Host, r.TLD, r.ResponseCode, r.ErrorClass,
+		strconv.Itoa(r.ErrorCount), r.ContentLang, r.TagsLanguages, r.SitemapLanguages, r.BlockerName,
+		r.IP, r.RefreshGroup, strconv.FormatInt(r.MaxAge, 10), checkedAt, r.CreatedAt, r.UpdatedAt,
+	}
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeLayout)
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) Write(domain model.Domain) error {
+	return c.w.Write(NewRow(domain).record())
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonlWriter) Write(domain model.Domain) error {
+	return j.enc.Encode(NewRow(domain))
+}
+
+func (j *jsonlWriter) Close() error {
+	return nil
+}
+
+// parquetRow is the Row with the timestamps in milliseconds
+type parquetRow struct {
+	ID               int64  `parquet:"name=id, type=INT64"`
+	Host             string `parquet:"name=host, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TLD              string `parquet:"name=tld, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ResponseCode     string `parquet:"name=response_code, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ErrorClass       string `parquet:"name=error_class, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ErrorCount       int64  `parquet:"name=error_count, type=INT64"`
+	ContentLang      string `parquet:"name=content_lang, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TagsLanguages    string `parquet:"name=tags_languages, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SitemapLanguages string `parquet:"name=sitemap_languages, type=BYTE_ARRAY, convertedtype=UTF8"`
+	BlockerName      string `parquet:"name=blocker_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	IP               string `parquet:"name=ip, type=BYTE_ARRAY, convertedtype=UTF8"`
+	RefreshGroup     string `parquet:"name=refresh_group, type=BYTE_ARRAY, convertedtype=UTF8"`
+	MaxAge           int64  `parquet:"name=max_age, type=INT64"`
+	CheckedAt        *int64 `parquet:"name=checked_at, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=OPTIONAL"`
+	CreatedAt        int64  `parquet:"name=created_at, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	UpdatedAt        int64  `parquet:"name=updated_at, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+}
+
+type parquetWriter struct {
+	w *writer.ParquetWriter
+}
+
+func (p *parquetWriter) Write(domain model.Domain) error {
+	r := NewRow(domain)
+	row := parquetRow{
+		ID:               int64(r.ID),
+		Host:             r.Host,
+		TLD:              r.TLD,
+		ResponseCode:     r.ResponseCode,
+		ErrorClass:       r.ErrorClass,
+		ErrorCount:       int64(r.ErrorCount),
+		ContentLang:      r.ContentLang,
+		TagsLanguages:    r.TagsLanguages,
+		SitemapLanguages: r.SitemapLanguages,
+		BlockerName:      r.BlockerName,
+		IP:               r.IP,
+		RefreshGroup:     r.RefreshGroup,
+		MaxAge:           r.MaxAge,
+		CreatedAt:        toMillis(domain.CreatedAt),
+		UpdatedAt:        toMillis(domain.UpdatedAt),
+	}
+	if domain.CheckedAt != nil {
+		checkedAt := toMillis(*domain.CheckedAt)
+		row.CheckedAt = &checkedAt
+	}
+	return p.w.Write(row)
+}
+
+func (p *parquetWriter) Close() error {
+	return p.w.WriteStop()
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,175 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"restapi_langparser/internal/model"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+	"gorm.io/gorm"
+)
+
+var checkedAt = time.Date(2022, 5, 1, 10, 30, 0, 123456789, time.FixedZone("MSK", 3*60*60))
+
+func testDomains() []model.Domain {
+	return []model.Domain{
+		{
+			Model:            gorm.Model{ID: 1, CreatedAt: checkedAt.Add(-time.Hour), UpdatedAt: checkedAt},
+			Host:             "https://a.example.com",
+			TLD:              "com",
+			ResponseCode:     model.ResponseOk,
+			ContentLanguage:  "en",
+			TagsLanguages:    []string{"DE", "EN"},
+			SitemapLanguages: []string{"FR"},
+			CheckedAt:        &checkedAt,
+			MaxAge:           3600,
+			Group:            "news",
+		},
+		{
+			Model:        gorm.Model{ID: 2, CreatedAt: checkedAt, UpdatedAt: checkedAt},
+			Host:         "https://b.example.org",
+			TLD:          "org",
+			ResponseCode: model.ResponseError,
+			ErrorClass:   model.ErrorClassDNS,
+			ErrorCount:   2,
+		},
+	}
+}
+
+func writeDomains(t *testing.T, format string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(format, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, domain := range testDomains() {
+		if err := w.Write(domain); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestWriter_CSV(t *testing.T) {
+	records, err := csv.NewReader(bytes.NewReader(writeDomains(t, CSV))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("%d records, want the header and 2 rows", len(records))
+	}
+	if !reflect.DeepEqual(records[0], Columns) {
+		t.Fatalf("header %v, want %v", records[0], Columns)
+	}
+
+	want := [][]string{
+		{"1", "https://a.example.com", "com", "ok", "", "0", "EN", "DE,EN", "FR", "", "", "news", "3600",
+			"2022-05-01T07:30:00.123Z", "2022-05-01T06:30:00.123Z", "2022-05-01T07:30:00.123Z"},
+		{"2", "https://b.example.org", "org", "error", "dns", "2", "", "", "", "", "", "", "0",
+			"", "2022-05-01T07:30:00.123Z", "2022-05-01T07:30:00.123Z"},
+	}
+	if !reflect.DeepEqual(records[1:], want) {
+		t.Fatalf("rows %v, want %v", records[1:], want)
+	}
+}
+
+func TestWriter_JSONL(t *testing.T) {
+	lines := bytes.Split(bytes.TrimSpace(writeDomains(t, JSONL)), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("%d lines, want 2", len(lines))
+	}
+
+	rows := make([]map[string]interface{}, len(lines))
+	for i, line := range lines {
+		if err := json.Unmarshal(line, &rows[i]); err != nil {
+			t.Fatal(err)
+		}
+		keys := make([]string, 0, len(rows[i]))
+		for key := range rows[i] {
+			keys = append(keys, key)
+		}
+		columns := append([]string(nil), Columns...)
+		sort.Strings(keys)
+		sort.Strings(columns)
+		if !reflect.DeepEqual(keys, columns) {
+			t.Fatalf("keys %v, want %v", keys, columns)
+		}
+	}
+
+	if rows[0]["content_lang"] != "EN" || rows[0]["tags_languages"] != "DE,EN" || rows[0]["sitemap_languages"] != "FR" {
+		t.Fatalf("languages of %v", rows[0])
+	}
+	if rows[0]["checked_at"] != "2022-05-01T07:30:00.123Z" || rows[0]["max_age"] != 3600.0 {
+		t.Fatalf("row %v", rows[0])
+	}
+	if rows[1]["checked_at"] != nil || rows[1]["tags_languages"] != "" || rows[1]["error_class"] != "dns" {
+		t.Fatalf("row %v", rows[1])
+	}
+}
+
+func TestWriter_Parquet(t *testing.T) {
+	data := writeDomains(t, Parquet)
+	file, err := buffer.NewBufferFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the schema of the file
+	schema, err := reader.NewParquetReader(file, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := make([]string, 0, len(Columns))
+	for _, info := range schema.SchemaHandler.Infos[1:] {
+		columns = append(columns, info.ExName)
+	}
+	schema.ReadStop()
+	if !reflect.DeepEqual(columns, Columns) {
+		t.Fatalf("columns %v, want %v", columns, Columns)
+	}
+
+	if file, err = buffer.NewBufferFile(data); err != nil {
+		t.Fatal(err)
+	}
+	pr, err := reader.NewParquetReader(file, new(parquetRow), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+
+	rows := make([]parquetRow, pr.GetNumRows())
+	if len(rows) != 2 {
+		t.Fatalf("%d rows, want 2", len(rows))
+	}
+	if err = pr.Read(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows[0].ContentLang != "EN" || rows[0].TagsLanguages != "DE,EN" || rows[0].SitemapLanguages != "FR" {
+		t.Fatalf("languages of %+v", rows[0])
+	}
+	if rows[0].CheckedAt == nil || *rows[0].CheckedAt != checkedAt.UnixNano()/int64(time.Millisecond) {
+		t.Fatalf("checked at of %+v", rows[0])
+	}
+	if rows[1].CheckedAt != nil || rows[1].ErrorCount != 2 || rows[1].TagsLanguages != "" {
+		t.Fatalf("row %+v", rows[1])
+	}
+}
+
+func TestNewWriter_UnknownFormat(t *testing.T) {
+	if _, err := NewWriter("xml", &bytes.Buffer{}); err == nil {
+		t.Fatal("writer of the unknown format")
+	}
+	if _, err := ContentType("xml"); err == nil {
+		t.Fatal("content type of the unknown format")
+	}
+}
@@ -0,0 +1,134 @@
+package langfinder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"restapi_langparser/internal/config"
+	"restapi_langparser/internal/model"
+	"restapi_langparser/internal/store"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// finishRequests marks the requests completed by the domain as done, or as
+// partial if some of their domains failed, and calls back their owners. The
+// request is finished by one of the concurrent workers only, so the callback
+// is sent once.
+func (f *LangFinder) finishRequests(ctx context.Context, domain model.Domain) {
+	requests, err := f.store.GetCompletedRequests(ctx, domain)
+	if err != nil {
+		logrus.Errorf("Get completed requests fail: %s", err)
+		return
+	}
+	f.finish(ctx, requests)
+}
+
+// FinishRequests finishes the requests which were completed outside of the
+// crawl, like by the deletion of their last pending domains, and calls back
+// their owners
+func FinishRequests(ctx context.Context, st store.IStore, cfg *config.Config, requests []model.RequestHeader) {
+	f := &LangFinder{store: st, config: cfg}
+	f.finish(ctx, requests)
+}
+
+// finish settles the status of the completed requests and notifies them
+func (f *LangFinder) finish(ctx context.Context, requests []model.RequestHeader) {
+	for _, request := range requests {
+		status, err := f.requestStatus(ctx, request)
+		if err != nil {
+			logrus.Errorf("Get request %s progress fail: %s", request.Code, err)
+			continue
+		}
+		finished, err := f.store.FinishRequest(ctx, request.Code, status)
+		if err != nil {
+			logrus.Errorf("Finish request %s fail: %s", request.Code, err)
+			continue
+		}
+		if finished {
+			f.notifyRequest(ctx, request, status)
+		}
+	}
+}
+
+// stopOverdueRequests stops the pending requests after their deadline, their
+// unfinished domains are skipped
+func (f *LangFinder) stopOverdueRequests(ctx context.Context) (int64, error) {
+	var stopped int64
+	for ctx.Err() == nil {
+		requests, err := f.store.GetOverdueRequests(ctx, time.Now(), f.config.RequestSweepBatch)
+		if err != nil || len(requests) == 0 {
+			return stopped, err
+		}
+		for _, request := range requests {
+			status, err := f.requestStatus(ctx, request)
+			if err != nil {
+				return stopped, err
+			}
+			if _, err = f.store.StopRequest(ctx, request.Code, status); errors.Is(err, model.ErrRequestFinished) {
+				continue
+			} else if err != nil {
+				return stopped, err
+			}
+			stopped++
+			f.notifyRequest(ctx, request, status)
+		}
+	}
+	return stopped, ctx.Err()
+}
+
+// requestStatus returns the final status of the request by its progress
+func (f *LangFinder) requestStatus(ctx context.Context, request model.RequestHeader) (string, error) {
+	progress, err := f.store.GetRequestProgress(ctx, request.Code)
+	if err != nil {
+		return "", err
+	}
+	return progress.Settle(request, time.Now()).StatusOf(request), nil
+}
+
+// notifyRequest calls back the owner of the finished request
+func (f *LangFinder) notifyRequest(ctx context.Context, request model.RequestHeader, status string) {
+	if request.Callback == "" {
+		return
+	}
+	err := f.sendCallback(ctx, request.Callback, model.RequestNotification{
+		Code:   request.Code,
+		Status: status,
+		Hosts:  request.Hosts,
+	})
+	if err != nil {
+		logrus.Errorf("Request %s callback fail: %s", request.Code, err)
+	}
+}
+
+// sendCallback posts the payload as JSON to the callback url
+func (f *LangFinder) sendCallback(ctx context.Context, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := http.Client{
+		Timeout: f.config.ResponseTimeout,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("callback %s responded %s", url, resp.Status)
+	}
+	return nil
+}
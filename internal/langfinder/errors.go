@@ -0,0 +1,35 @@
+package langfinder
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"restapi_langparser/internal/model"
+	"strings"
+)
+
+// classifyError returns the error class of the failed page request
+func classifyError(err error) string {
+	var (
+		dnsErr       *net.DNSError
+		netErr       net.Error
+		opErr        *net.OpError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+		recordErr    tls.RecordHeaderError
+	)
+	switch {
+	case errors.As(err, &dnsErr):
+		return model.ErrorClassDNS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return model.ErrorClassTimeout
+	case errors.As(err, &authorityErr), errors.As(err, &hostnameErr), errors.As(err, &invalidErr),
+		errors.As(err, &recordErr), strings.Contains(err.Error(), "tls: "):
+		return model.ErrorClassTLS
+	case errors.As(err, &opErr):
+		return model.ErrorClassConnection
+	}
+	return model.ErrorClassRequest
+}
@@ -0,0 +1,29 @@
+package langfinder
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// freshnessSweeper periodically queues successfully crawled domains which are
+// older than their max age, so new languages of the sites are noticed
+func (f *LangFinder) freshnessSweeper(ctx context.Context) {
+	ticker := time.NewTicker(f.config.FreshnessSweepInterval)
+	defer ticker.Stop()
+	for {
+		cnt, err := f.store.RequeueStale(ctx, f.config.DomainMaxAge, f.config.GroupMaxAge, f.config.FreshnessSweepBatch)
+		if err != nil && ctx.Err() == nil {
+			logrus.Errorf("Requeue stale domains fail: %s", err)
+		} else if cnt > 0 {
+			logrus.Infof("Stale domains queued for refresh: %d", cnt)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
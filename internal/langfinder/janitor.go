@@ -0,0 +1,63 @@
+package langfinder
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// requestJanitor periodically stops the requests after their deadline,
+// expires the requests older than their TTL and later deletes the expired
+// ones. All of them run in batches until nothing is left, so a large backlog
+// does not hold a long transaction.
+func (f *LangFinder) requestJanitor(ctx context.Context) {
+	ticker := time.NewTicker(f.config.RequestSweepInterval)
+	defer ticker.Stop()
+	for {
+		stopped, err := f.stopOverdueRequests(ctx)
+		if err != nil && ctx.Err() == nil {
+			logrus.Errorf("Stop overdue requests fail: %s", err)
+		} else if stopped > 0 {
+			logrus.Infof("Overdue requests stopped: %d", stopped)
+		}
+		if f.config.RequestTTL > 0 {
+			f.expireRequests(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (f *LangFinder) expireRequests(ctx context.Context) {
+	now := time.Now()
+	expired, err := f.sweepRequests(ctx, f.store.ExpireRequests, now.Add(-f.config.RequestTTL))
+	if err != nil && ctx.Err() == nil {
+		logrus.Errorf("Expire requests fail: %s", err)
+	} else if expired > 0 {
+		logrus.Infof("Requests expired: %d", expired)
+	}
+	deleted, err := f.sweepRequests(ctx, f.store.DeleteExpiredRequests, now.Add(-f.config.RequestTTL-f.config.ExpiredRequestTTL))
+	if err != nil && ctx.Err() == nil {
+		logrus.Errorf("Delete expired requests fail: %s", err)
+	} else if deleted > 0 {
+		logrus.Infof("Expired requests deleted: %d", deleted)
+	}
+}
+
+// sweepRequests runs the batches of the sweep until one of them is not full
+func (f *LangFinder) sweepRequests(ctx context.Context, sweep func(context.Context, time.Time, int) (int64, error), before time.Time) (int64, error) {
+	var total int64
+	for ctx.Err() == nil {
+		cnt, err := sweep(ctx, before, f.config.RequestSweepBatch)
+		total += cnt
+		if err != nil || f.config.RequestSweepBatch <= 0 || cnt < int64(f.config.RequestSweepBatch) {
+			return total, err
+		}
+	}
+	return total, ctx.Err()
+}
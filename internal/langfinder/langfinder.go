@@ -2,6 +2,7 @@ package langfinder
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/sirupsen/logrus"
@@ -19,7 +20,10 @@ import (
 )
 
 const (
-	maxErrorsCount = 10
+	minIdleDelay = time.Second
+	maxIdleDelay = time.Minute
+	minBackoff   = time.Second
+	maxBackoff   = time.Minute
 )
 
 type LangFinder struct {
@@ -27,25 +31,64 @@ type LangFinder struct {
 	config        *config.Config
 	threadLimit   chan interface{}
 	proxyProvider *proxyprovider.ProxyProvider
-	callbacks     struct {
-		sync.RWMutex
-		m map[string]string
-	}
+	scheduler     *scheduler
+	workers       sync.WaitGroup
+	stopTasks     context.CancelFunc
+	crawlCtx      context.Context // cancelled when shutdown runs out of time
+	abortCrawl    context.CancelFunc
+	done          chan struct{}
 }
 
-func New(store store.IStore, config *config.Config) *LangFinder {
+func New(ctx context.Context, store store.IStore, config *config.Config) *LangFinder {
+	crawlCtx, abortCrawl := context.WithCancel(context.Background())
 	return &LangFinder{
 		store:         store,
 		config:        config,
-		proxyProvider: proxyprovider.New(config, store),
-		callbacks: struct {
-			sync.RWMutex
-			m map[string]string
-		}{m: make(map[string]string)},
+		proxyProvider: proxyprovider.New(ctx, config, store),
+		scheduler:     newScheduler(store, config),
+		stopTasks:     func() {},
+		crawlCtx:      crawlCtx,
+		abortCrawl:    abortCrawl,
+		done:          make(chan struct{}),
+	}
+}
+
+// Start runs crawling of the queue in background until ctx is done or Shutdown is called
+func (f *LangFinder) Start(ctx context.Context) {
+	ctx, f.stopTasks = context.WithCancel(ctx)
+	go f.taskManager(ctx, int(f.config.MaxThreads))
+	if f.config.FreshnessSweepInterval > 0 {
+		go f.freshnessSweeper(ctx)
+	}
+	if f.config.WatchlistSweepInterval > 0 {
+		go f.watchlistSweeper(ctx)
+	}
+	if f.config.RequestSweepInterval > 0 {
+		go f.requestJanitor(ctx)
 	}
 }
 
-func (f *LangFinder) NewTask(callback string, hosts ...string) (string, error) {
+// Shutdown stops taking domains from the queue and waits for the running workers.
+// When ctx is done first the running crawls are aborted and their domains are
+// returned to the queue.
+func (f *LangFinder) Shutdown(ctx context.Context) error {
+	f.stopTasks()
+	select {
+	case <-f.done:
+		return nil
+	case <-ctx.Done():
+		f.abortCrawl()
+		<-f.done
+		return ctx.Err()
+	}
+}
+
+// Active returns the number of domains being crawled now
+func (f *LangFinder) Active() int {
+	return f.scheduler.active()
+}
+
+func (f *LangFinder) NewTask(ctx context.Context, callback string, hosts ...string) (string, error) {
 	if len(hosts) == 0 {
 		return "", errors.New("empty host list")
 	}
@@ -56,34 +99,16 @@ func (f *LangFinder) NewTask(callback string, hosts ...string) (string, error) {
 		}
 	}
 
-	err := f.store.AddDomains(&domains)
+	err := f.store.AddDomains(ctx, &domains)
 
 	if err != nil {
 		return "", err
 	}
 
-	//if queuePrior == model.RequestQueue {
-	//	domains, err = f.store.Domain().FindByHost(hosts...)
-	//	if err != nil {
-	//		return "", err
-	//	}
-	//	requestCode, err := f.store.Request().Create(domains)
-	//	if err != nil {
-	//		return "", err
-	//	}
-	//
-	//	if callback != "" {
-	//		f.callbacks.Lock()
-	//		f.callbacks.m[requestCode] = callback
-	//		f.callbacks.Unlock()
-	//	}
-	//	return requestCode, nil
-	//}
-
 	return "", nil
 }
 
-func (f *LangFinder) getSitemapURLs(uRL string) ([]string, error) {
+func (f *LangFinder) getSitemapURLs(ctx context.Context, uRL string) ([]string, error) {
 	u, _ := url.Parse(uRL)
 	uRL = fmt.Sprintf("%s://%s/robots.txt", u.Scheme, u.Host)
 
@@ -91,7 +116,7 @@ func (f *LangFinder) getSitemapURLs(uRL string) ([]string, error) {
 		Timeout: time.Second * 5,
 	}
 
-	req, err := http.NewRequest(http.MethodGet, uRL, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uRL, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -113,161 +138,250 @@ func (f *LangFinder) getSitemapURLs(uRL string) ([]string, error) {
 	return robots.Sitemaps, nil
 }
 
-func (f *LangFinder) taskWorker(domain model.Domain, taskLimiter chan interface{}) {
+func (f *LangFinder) taskWorker(domain model.Domain, lane model.QueueLane, proxy *model.Proxy, taskLimiter chan interface{}) {
 	defer func() {
+		f.proxyProvider.Release(proxy)
+		f.scheduler.done(lane)
 		<-taskLimiter
+		f.workers.Done()
 	}()
 
-	req, err := http.NewRequest(http.MethodGet, domain.Host, nil)
+	req, err := http.NewRequestWithContext(f.crawlCtx, http.MethodGet, domain.Host, nil)
 	if err != nil {
-		logrus.Errorf("Creqte http request fail: %s", err)
-		return
-	}
-
-	proxy := f.proxyProvider.Get()
-	if proxy == nil {
+		logrus.Errorf("Create http request failed: %s", err)
+		domain.ResponseCode = model.ResponseError
+		domain.ErrorClass = model.ErrorClassRequest
+		domain.ErrorCount++
+		f.complete(domain, "", err)
 		return
 	}
-	defer func() {
-		f.proxyProvider.Release(proxy)
-	}()
 
 	client := createClient(proxy, f.config.ResponseTimeout)
 
-	defer func() {
-		err = f.store.Domain().Update(domain)
-		if err != nil {
-			logrus.Errorf("DB update fail: %s", err)
-		}
-	}()
-
 	// request page
 	resp, err := client.Do(req)
 	if err != nil {
+		if f.crawlCtx.Err() != nil { // aborted by shutdown
+			f.release(domain)
+			return
+		}
 		logrus.Errorf("URL visit fail: %s", err)
-		if domain.ResponseCode == model.ResponseError {
-			domain.ErrorCount++
-		} else {
-			domain.ErrorCount = 1
+		requestFailed(&domain, err)
+		f.complete(domain, proxy.String(), err)
+		return
+	}
+
+	// the truncated page is not parsed, it fails like the request
+	page, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		if f.crawlCtx.Err() != nil {
+			f.release(domain)
+			return
 		}
+		logrus.Errorf("Read response fail: %s", err)
+		requestFailed(&domain, err)
+		f.complete(domain, proxy.String(), err)
 		return
 	}
 
+	domain.ErrorClass = model.ErrorClassHTTP
 	switch resp.StatusCode {
 	case http.StatusOK:
 		domain.ResponseCode = model.ResponseOk
+		domain.ErrorClass = ""
 	case http.StatusNotFound:
 		domain.ResponseCode = model.ResponseNotExist
 	default:
 		domain.ResponseCode = model.ResponseError
 	}
 
-	page, _ := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
-
+	var parseErr error
 	domain.ContentLanguage, err = parser.GetContentLang(bytes.NewReader(page))
 	if err != nil {
 		domain.ErrorCount++
 		domain.ResponseCode = model.ResponseError
+		domain.ErrorClass = model.ErrorClassParse
+		parseErr = err
 	}
 
 	domain.TagsLanguages, err = parser.GetLangsInTags(bytes.NewReader(page))
 	if err != nil {
 		domain.ErrorCount++
 		domain.ResponseCode = model.ResponseError
+		domain.ErrorClass = model.ErrorClassParse
+		parseErr = err
 	}
 
-	err = f.store.Domain().Update(domain)
-	if err != nil {
-		logrus.Error(err)
+	f.complete(domain, proxy.String(), parseErr)
+}
+
+// requestFailed counts the failed request of the domain, the errors are counted in a row
+func requestFailed(domain *model.Domain, err error) {
+	if domain.ResponseCode == model.ResponseError {
+		domain.ErrorCount++
+	} else {
+		domain.ErrorCount = 1
+	}
+	domain.ResponseCode = model.ResponseError
+	domain.ErrorClass = classifyError(err)
+}
+
+// release returns the unprocessed domain to the queue
+func (f *LangFinder) release(domain model.Domain) {
+	if err := f.store.ReturnToQueue(context.Background(), time.Now(), domain); err != nil {
+		logrus.Errorf("Return to queue fail: %s", err)
 	}
 }
 
-func (f *LangFinder) taskManager(limit int) {
-	errorsCnt := 0
-	//updateLimit := int(float64(limit) * 0.6)
-	taskLimiter := make(chan interface{}, limit-1)
+// complete saves the result with the crawl history and only then acknowledges
+// the queue lease. If the result is lost the domain stays in the queue and is
+// crawled again. The result is saved even on shutdown, so the store calls are
+// not cancelled.
+func (f *LangFinder) complete(domain model.Domain, proxy string, crawlErr error) {
+	ctx := context.Background()
+	checkedAt := time.Now()
+	domain.CheckedAt = &checkedAt
+
+	history := model.NewDomainHistory(domain)
+	history.Proxy = proxy
+	if crawlErr != nil {
+		history.Error = crawlErr.Error()
+	}
+
+	var prev *model.DomainHistory
+	if last, _, err := f.store.GetHistory(ctx, domain.ID, model.Page{Limit: 1}); err != nil {
+		logrus.Errorf("Get history fail: %s", err)
+	} else if len(last) > 0 {
+		prev = &last[0]
+	}
+
+	// the failed save is a store error, not a crawl error, so the domain is
+	// not delayed like a bad domain, it is crawled again when the lease expires
+	if err := f.store.SaveCrawlResult(ctx, domain, history); err != nil {
+		logrus.Errorf("DB update fail: %s", err)
+		return
+	}
+
+	var err error
+	if refresh := f.retryDelay(domain); refresh > 0 {
+		err = f.store.ReturnToQueue(ctx, time.Now().Add(refresh), domain)
+	} else {
+		err = f.store.RemoveFromQueue(ctx, domain)
+	}
+	if errors.Is(err, model.ErrLeaseLost) {
+		logrus.Warnf("Queue lease of %s expired, the domain is taken by another worker", domain.Host)
+	} else if err != nil {
+		logrus.Errorf("Queue acknowledge fail: %s", err)
+	} else {
+		f.finishRequests(ctx, domain)
+	}
+
+	f.notifyWatchlists(ctx, prev, domain, history)
+}
+
+// retryDelay returns when the domain should be visited again, 0 if it is done
+func (f *LangFinder) retryDelay(domain model.Domain) time.Duration {
+	switch domain.ResponseCode {
+	case model.ResponseError:
+		return f.config.DomainWithErrorRefresh
+	case model.ResponseNotExist:
+		return f.config.DomainWithDNSErrorRefresh
+	case model.ResponseBan:
+		return f.config.RebannedDomainRefresh
+	}
+	return 0
+}
+
+func (f *LangFinder) taskManager(ctx context.Context, limit int) {
+	defer close(f.done)
+
+	backoff := minBackoff
+	taskLimiter := make(chan interface{}, limit)
 	for {
-		if errorsCnt > maxErrorsCount {
-			logrus.Errorf("error limit exceeded")
+		select {
+		case <-ctx.Done():
+			f.workers.Wait()
 			return
+		case taskLimiter <- 0:
 		}
-		queueDomain, err := f.store.Domain().GetUserRequest()
-		if err != nil {
-			logrus.Errorf("Get queue fail: %s", err)
-			errorsCnt++
+
+		// the domain is leased only when there is a proxy to crawl it
+		proxy := f.proxyProvider.Get()
+		if proxy == nil {
+			<-taskLimiter
+			f.waitProxy(ctx)
 			continue
 		}
 
-		if queueDomain == nil { // user request not found
-			queueDomain, err = f.store.Domain().GetErrorHost()
-			if err != nil {
-				logrus.Errorf("Get queue fail: %s", err)
-				errorsCnt++
+		domain, lane, err := f.scheduler.next(ctx)
+		if err != nil {
+			f.proxyProvider.Release(proxy)
+			<-taskLimiter
+			if ctx.Err() != nil {
 				continue
 			}
+			logrus.Errorf("Get queue fail: %s, retry in %s", err, backoff)
+			sleep(ctx, backoff)
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		backoff = minBackoff
+
+		if domain == nil { // all lanes are empty
+			f.proxyProvider.Release(proxy)
+			<-taskLimiter
+			f.waitQueue(ctx)
+			continue
 		}
 
-		taskLimiter <- 0
-		go f.taskWorker(*queueDomain, taskLimiter)
-
-		errorsCnt = 0
-
-		//if task == nil {
-		//	time.Sleep(time.Second)
-		//	continue
-		//}
-		//qt, ok := task.(taskItem)
-		//if !ok {
-		//	continue
-		//}
-		//
-		//taskLimiter <- 0
-		//go f.taskWorker(taskLimiter)
-	}
-	//resp, err := f.requester.GetPageData(uRL)
-	//if err != nil {
-	//	log.Println("parse error:", err)
-	//	return
-	//}
-	//contentLang, err := parser.GetContentLang(resp.Page())
-	//if err != nil {
-	//	log.Println("parse error:", err)
-	//	return
-	//}
-	//tagLangs, err := parser.GetLangsInTags(resp.Page())
-	//if err != nil {
-	//	log.Println("parse error:", err)
-	//	return
-	//}
-	//
-	//dom := model.Domain{
-	//	URL:             uRL,
-	//	ResponseCode:    uint16(resp.ResponseCode),
-	//	ContentLanguage: contentLang,
-	//}
-	//
-	//// add langs form header and meta
-	//dom.TabTagsLanguages = make([]model.TagsLangs, len(tagLangs))
-	//for i, tl := range tagLangs {
-	//	dom.TabTagsLanguages[i] = model.TagsLangs{
-	//		Lang: tl,
-	//	}
-	//}
-	//for _, tl := range resp.HeaderLang {
-	//	dom.TabTagsLanguages = append(dom.TabTagsLanguages, model.TagsLangs{
-	//		Lang: tl,
-	//	})
-	//}
-	//
-	//// add langs from sitemap
-	//
-	//id, err := f.store.Domain().Add(dom)
-	//
-	//if err != nil {
-	//	log.Println("db error:", err)
-	//	return
-	//}
-	//
-	//f.userRequestResults[requestCode].Add(id)
+		f.workers.Add(1)
+		go f.taskWorker(*domain, lane, proxy, taskLimiter)
+	}
+}
+
+// waitQueue sleeps until new domains are added or the next queued domain is due
+func (f *LangFinder) waitQueue(ctx context.Context) {
+	delay := maxIdleDelay
+	next, err := f.store.NextQueueUpdate(ctx)
+	if err != nil {
+		logrus.Errorf("Get next queue update fail: %s", err)
+	} else if !next.IsZero() {
+		delay = time.Until(next)
+	}
+	if delay < minIdleDelay {
+		delay = minIdleDelay
+	} else if delay > maxIdleDelay {
+		delay = maxIdleDelay
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-f.store.QueueNotify():
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// waitProxy sleeps until a proxy is released by a worker
+func (f *LangFinder) waitProxy(ctx context.Context) {
+	timer := time.NewTimer(maxIdleDelay)
+	defer timer.Stop()
+	select {
+	case <-f.proxyProvider.Released():
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
 }
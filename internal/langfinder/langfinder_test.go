@@ -0,0 +1,90 @@
+package langfinder
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"restapi_langparser/internal/config"
+	"restapi_langparser/internal/model"
+	"restapi_langparser/internal/store"
+	"restapi_langparser/internal/store/memstore"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// leaseCounter counts the leases taken from the store
+type leaseCounter struct {
+	store.IStore
+	leases int64
+}
+
+func (s *leaseCounter) LeaseFromQueue(ctx context.Context, lane model.QueueLane, workerID string, ttl time.Duration) (*model.Domain, error) {
+	atomic.AddInt64(&s.leases, 1)
+	return s.IStore.LeaseFromQueue(ctx, lane, workerID, ttl)
+}
+
+func TestLangFinder_NoProxy(t *testing.T) {
+	ctx := context.Background()
+	st := &leaseCounter{IStore: memstore.New()}
+	if err := st.AddDomains(ctx, &[]model.Domain{{Host: "http://127.0.0.1:1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.New()
+	cfg.ThreadsPerProxy = 0 // all proxies are busy
+	cfg.FreshnessSweepInterval = 0
+	cfg.WatchlistSweepInterval = 0
+	cfg.RequestSweepInterval = 0
+	f := New(ctx, st, cfg)
+	f.Start(ctx)
+	time.Sleep(100 * time.Millisecond)
+	if err := f.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if leases := atomic.LoadInt64(&st.leases); leases != 0 {
+		t.Fatalf("%d leases without a proxy", leases)
+	}
+	depth, err := st.QueueDepth(ctx, model.LaneQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if depth != 1 {
+		t.Fatalf("queue depth %d, want 1", depth)
+	}
+}
+
+func TestLangFinder_TruncatedPage(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000")
+		w.Write([]byte(`<html lang="en"><body>`))
+	}))
+	defer srv.Close()
+
+	st := memstore.New()
+	if err := st.AddDomains(ctx, &[]model.Domain{{Host: srv.URL}}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.New()
+	f := New(ctx, st, cfg)
+
+	domain, err := st.LeaseFromQueue(ctx, model.LaneQueue, cfg.WorkerID, time.Minute)
+	if err != nil || domain == nil {
+		t.Fatalf("lease %v: %v", domain, err)
+	}
+	taskLimiter := make(chan interface{}, 1)
+	taskLimiter <- 0
+	f.workers.Add(1)
+	f.taskWorker(*domain, model.LaneQueue, f.proxyProvider.Get(), taskLimiter)
+
+	crawled, err := st.Domain().FindByID(ctx, int(domain.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crawled.ResponseCode != model.ResponseError || crawled.ErrorClass != model.ErrorClassRequest ||
+		crawled.ErrorCount != 1 || crawled.ContentLanguage != "" {
+		t.Fatalf("truncated page saved as %+v", crawled)
+	}
+}
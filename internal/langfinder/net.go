@@ -8,7 +8,7 @@ import (
 )
 
 func createClient(proxy *model.Proxy, timeout time.Duration) *http.Client {
-	switch proxy.Type {
+	switch proxy.Type() {
 	case model.HTTPS:
 		proxyURL := &url.URL{
 			Scheme: "https://",
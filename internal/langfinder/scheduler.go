@@ -0,0 +1,111 @@
+package langfinder
+
+import (
+	"context"
+	"restapi_langparser/internal/config"
+	"restapi_langparser/internal/model"
+	"restapi_langparser/internal/store"
+	"sync"
+	"time"
+)
+
+// scheduler picks the lane for every free worker. User requests always go first,
+// the rest of capacity is shared between the list queue and the bad domains by
+// smooth weighted round-robin. Bad domains never take more than
+// config.MaxRequestToBadDomain percent of the workers. Stale domains are
+// refreshed only when there is nothing else to do.
+type scheduler struct {
+	store    store.IStore
+	workerID string
+	leaseTTL time.Duration
+	weights  map[model.QueueLane]int
+	current  map[model.QueueLane]int
+	inFlight map[model.QueueLane]int
+	badLimit int
+	m        sync.Mutex
+}
+
+func newScheduler(store store.IStore, config *config.Config) *scheduler {
+	badLimit := int(config.MaxThreads * config.MaxRequestToBadDomain / 100)
+	if badLimit == 0 && config.MaxRequestToBadDomain > 0 {
+		badLimit = 1
+	}
+	return &scheduler{
+		store:    store,
+		workerID: config.WorkerID,
+		leaseTTL: config.QueueLeaseTimeout,
+		weights: map[model.QueueLane]int{
+			model.LaneQueue:     int(config.QueueWeight),
+			model.LaneBadDomain: int(config.BadDomainWeight),
+		},
+		current:  make(map[model.QueueLane]int),
+		inFlight: make(map[model.QueueLane]int),
+		badLimit: badLimit,
+	}
+}
+
+// next leases a domain from the queue, nil if there is nothing to do right now
+func (s *scheduler) next(ctx context.Context) (*model.Domain, model.QueueLane, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for _, lane := range s.order() {
+		domain, err := s.store.LeaseFromQueue(ctx, lane, s.workerID, s.leaseTTL)
+		if err != nil {
+			return nil, "", err
+		}
+		if domain != nil {
+			s.inFlight[lane]++
+			return domain, lane, nil
+		}
+	}
+	return nil, "", nil
+}
+
+// done releases the capacity taken by next
+func (s *scheduler) done(lane model.QueueLane) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.inFlight[lane] > 0 {
+		s.inFlight[lane]--
+	}
+}
+
+func (s *scheduler) active() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	res := 0
+	for _, cnt := range s.inFlight {
+		res += cnt
+	}
+	return res
+}
+
+// order returns lanes to try for the current slot
+func (s *scheduler) order() []model.QueueLane {
+	res := []model.QueueLane{model.LaneUserRequest}
+
+	candidates := []model.QueueLane{model.LaneQueue}
+	if s.inFlight[model.LaneBadDomain] < s.badLimit {
+		candidates = append(candidates, model.LaneBadDomain)
+	}
+
+	total := 0
+	var best model.QueueLane
+	for _, lane := range candidates {
+		s.current[lane] += s.weights[lane]
+		total += s.weights[lane]
+		if best == "" || s.current[lane] > s.current[best] {
+			best = lane
+		}
+	}
+	s.current[best] -= total
+
+	res = append(res, best)
+	for _, lane := range candidates {
+		if lane != best {
+			res = append(res, lane)
+		}
+	}
+	return append(res, model.LaneRefresh)
+}
@@ -0,0 +1,127 @@
+package langfinder
+
+import (
+	"context"
+	"reflect"
+	"restapi_langparser/internal/config"
+	"restapi_langparser/internal/model"
+	"restapi_langparser/internal/store"
+	"testing"
+	"time"
+)
+
+// laneStore leases the domains of the lanes, -1 domains is an endless lane
+type laneStore struct {
+	store.IStore
+	domains map[model.QueueLane]int
+}
+
+func (s *laneStore) LeaseFromQueue(_ context.Context, lane model.QueueLane, _ string, _ time.Duration) (*model.Domain, error) {
+	switch s.domains[lane] {
+	case 0:
+		return nil, nil
+	case -1:
+	default:
+		s.domains[lane]--
+	}
+	return &model.Domain{Host: "https://" + string(lane) + ".example"}, nil
+}
+
+func TestScheduler_Next(t *testing.T) {
+	const (
+		user    = model.LaneUserRequest
+		queue   = model.LaneQueue
+		bad     = model.LaneBadDomain
+		refresh = model.LaneRefresh
+	)
+	testCases := []struct {
+		name      string
+		threads   uint
+		badShare  uint // percent of the threads
+		domains   map[model.QueueLane]int
+		wantLanes []model.QueueLane // empty lane is nothing to do
+	}{
+		{
+			name:      "weighted round-robin",
+			threads:   100,
+			badShare:  60,
+			domains:   map[model.QueueLane]int{queue: -1, bad: -1, refresh: -1},
+			wantLanes: []model.QueueLane{queue, queue, bad, queue, queue, queue, bad, queue},
+		},
+		{
+			name:      "user requests first",
+			threads:   100,
+			badShare:  60,
+			domains:   map[model.QueueLane]int{user: 2, queue: -1, bad: -1},
+			wantLanes: []model.QueueLane{user, user, bad, queue, queue},
+		},
+		{
+			name:      "bad domain cap",
+			threads:   10,
+			badShare:  20,
+			domains:   map[model.QueueLane]int{bad: -1, refresh: 1},
+			wantLanes: []model.QueueLane{bad, bad, refresh, ""},
+		},
+		{
+			name:      "bad domains of one thread",
+			threads:   1,
+			badShare:  10,
+			domains:   map[model.QueueLane]int{bad: -1},
+			wantLanes: []model.QueueLane{bad, ""},
+		},
+		{
+			name:      "empty lanes fall through",
+			threads:   100,
+			badShare:  60,
+			domains:   map[model.QueueLane]int{bad: 1, refresh: 1},
+			wantLanes: []model.QueueLane{bad, refresh, ""},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.New()
+			cfg.MaxThreads = tc.threads
+			cfg.MaxRequestToBadDomain = tc.badShare
+			s := newScheduler(&laneStore{domains: tc.domains}, cfg)
+
+			lanes := make([]model.QueueLane, 0, len(tc.wantLanes))
+			for range tc.wantLanes {
+				domain, lane, err := s.next(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if (domain == nil) != (lane == "") {
+					t.Fatalf("domain %v of lane %q", domain, lane)
+				}
+				lanes = append(lanes, lane)
+			}
+			if !reflect.DeepEqual(lanes, tc.wantLanes) {
+				t.Fatalf("lanes %v, want %v", lanes, tc.wantLanes)
+			}
+		})
+	}
+}
+
+func TestScheduler_Done(t *testing.T) {
+	cfg := config.New()
+	cfg.MaxThreads = 10
+	cfg.MaxRequestToBadDomain = 10
+	s := newScheduler(&laneStore{domains: map[model.QueueLane]int{model.LaneBadDomain: -1}}, cfg)
+
+	if _, lane, _ := s.next(context.Background()); lane != model.LaneBadDomain || s.active() != 1 {
+		t.Fatalf("lane %q, active %d", lane, s.active())
+	}
+	if _, lane, _ := s.next(context.Background()); lane != "" {
+		t.Fatalf("lane %q over the bad domain cap", lane)
+	}
+
+	// the finished crawl frees the capacity of the lane
+	s.done(model.LaneBadDomain)
+	if s.active() != 0 {
+		t.Fatalf("active %d after done", s.active())
+	}
+	if _, lane, _ := s.next(context.Background()); lane != model.LaneBadDomain {
+		t.Fatalf("lane %q after done", lane)
+	}
+}
@@ -0,0 +1,83 @@
+package langfinder
+
+import (
+	"context"
+	"restapi_langparser/internal/model"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// watchlistSweeper queues domains of the due watchlists for the recrawl
+func (f *LangFinder) watchlistSweeper(ctx context.Context) {
+	ticker := time.NewTicker(f.config.WatchlistSweepInterval)
+	defer ticker.Stop()
+	for {
+		if err := f.sweepWatchlists(ctx); err != nil && ctx.Err() == nil {
+			logrus.Errorf("Watchlist sweep fail: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (f *LangFinder) sweepWatchlists(ctx context.Context) error {
+	lists, err := f.store.Watchlist().Read(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, list := range lists {
+		if !list.Due(now) {
+			continue
+		}
+		watchlist, err := f.store.Watchlist().FindByID(ctx, list.ID)
+		if err != nil {
+			return err
+		}
+		if err = f.store.RefreshDomains(ctx, watchlist.Domains, true); err != nil {
+			return err
+		}
+		if err = f.store.Watchlist().SetLastRun(ctx, list.ID, now); err != nil {
+			return err
+		}
+		logrus.Infof("Watchlist %s queued %d domains", list.Name, len(watchlist.Domains))
+	}
+	return nil
+}
+
+// notifyWatchlists sends the language changes of the domain to the callbacks of its watchlists.
+// Only successful crawls are compared, otherwise a failed crawl looks like removed languages.
+func (f *LangFinder) notifyWatchlists(ctx context.Context, prev *model.DomainHistory, domain model.Domain, current model.DomainHistory) {
+	if prev == nil || prev.ResponseCode != model.ResponseOk || current.ResponseCode != model.ResponseOk {
+		return
+	}
+	diff := model.DiffLanguages(*prev, current)
+	if !diff.Changed() {
+		return
+	}
+
+	lists, err := f.store.Watchlist().FindByDomain(ctx, domain.ID)
+	if err != nil {
+		logrus.Errorf("Get watchlists fail: %s", err)
+		return
+	}
+	for _, list := range lists {
+		if list.Callback == "" {
+			continue
+		}
+		err = f.sendCallback(ctx, list.Callback, model.WatchlistNotification{
+			Watchlist: list.Name,
+			Host:      domain.Host,
+			Diff:      diff,
+		})
+		if err != nil {
+			logrus.Errorf("Watchlist %s notification fail: %s", list.Name, err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package langworker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"restapi_langparser/internal/config"
+	"restapi_langparser/internal/langfinder"
+	"restapi_langparser/internal/model"
+	"restapi_langparser/internal/store"
+	"restapi_langparser/internal/store/sqlstore"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Start crawls the queue of the shared store until SIGINT or SIGTERM.
+// On the signal it stops taking new domains and waits for the running ones
+// during config.ShutdownTimeout.
+func Start(cfg *config.Config) error {
+	if cfg.Type != config.SQLStore {
+		return errors.New("worker needs the store shared with the api server")
+	}
+	db, err := sqlstore.Open(cfg.DatabaseURL)
+	if err != nil {
+		return err
+	}
+	st := sqlstore.New(db)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	finder := langfinder.New(ctx, st, cfg)
+	finder.Start(ctx)
+	logrus.Infof("Worker %s started with %d threads", cfg.WorkerID, cfg.MaxThreads)
+
+	heartbeatDone := make(chan struct{})
+	go func() {
+		heartbeat(ctx, st, cfg, finder)
+		close(heartbeatDone)
+	}()
+
+	<-ctx.Done()
+	<-heartbeatDone
+
+	logrus.Infof("Worker %s is draining %d domains", cfg.WorkerID, finder.Active())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err = finder.Shutdown(shutdownCtx); err != nil {
+		logrus.Errorf("Drain fail: %s, unfinished domains are returned to the queue", err)
+	}
+
+	return st.RemoveWorker(context.Background(), cfg.WorkerID)
+}
+
+func heartbeat(ctx context.Context, st store.IStore, cfg *config.Config, finder *langfinder.LangFinder) {
+	host, _ := os.Hostname()
+	worker := model.Worker{
+		ID:        cfg.WorkerID,
+		Host:      host,
+		Threads:   cfg.MaxThreads,
+		StartedAt: time.Now(),
+	}
+
+	ticker := time.NewTicker(cfg.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		worker.Active = finder.Active()
+		worker.HeartbeatAt = time.Now()
+		if err := st.Heartbeat(ctx, worker); err != nil {
+			logrus.Errorf("Heartbeat fail: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
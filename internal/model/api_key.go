@@ -0,0 +1,82 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation"
+)
+
+// Scopes of the api keys, each one includes the scopes before it
+const (
+	ScopeRead   = "read"   // lists, results and exports
+	ScopeSubmit = "submit" // requests, uploads and jobs of the tenant
+	ScopeAdmin  = "admin"  // domains, proxies, keys and the requests of all tenants
+)
+
+var scopeLevels = map[string]int{ScopeRead: 1, ScopeSubmit: 2, ScopeAdmin: 3}
+
+// apiKeyPrefix starts the secrets, so they are easy to find in the leaked configs
+const apiKeyPrefix = "lp_"
+
+// APIKey authenticates the clients of the tenant. Only the hash of the secret
+// is stored, the secret is shown once when the key is issued.
+type APIKey struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	Tenant     string     `json:"tenant" gorm:"column:tenant"`
+	Name       string     `json:"name,omitempty" gorm:"column:name"`
+	Prefix     string     `json:"prefix" gorm:"column:prefix"` // start of the secret to tell the keys apart
+	Hash       string     `json:"-" gorm:"column:hash;unique"`
+	Scope      string     `json:"scope" gorm:"column:scope"`
+	DailyHosts int        `json:"dailyHosts,omitempty" gorm:"column:daily_hosts"` // hosts submitted per UTC day, 0 is unlimited
+	RateLimit  int        `json:"rateLimit,omitempty" gorm:"column:rate_limit"`   // api calls per minute, 0 is unlimited
+	CreatedAt  time.Time  `json:"createdAt" gorm:"column:created_at"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty" gorm:"column:revoked_at"`
+}
+
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// Validate checks the key before it is issued
+func (k APIKey) Validate() error {
+	return validation.ValidateStruct(&k,
+		validation.Field(&k.Tenant, validation.Required),
+		validation.Field(&k.Scope, validation.Required, validation.In(ScopeRead, ScopeSubmit, ScopeAdmin)),
+		validation.Field(&k.DailyHosts, validation.Min(0)),
+		validation.Field(&k.RateLimit, validation.Min(0)),
+	)
+}
+
+// Allows reports whether the scope of the key includes the scope
+func (k APIKey) Allows(scope string) bool {
+	return scopeLevels[k.Scope] >= scopeLevels[scope]
+}
+
+// NewAPIKey returns the key with a new random secret and the secret
+func NewAPIKey(tenant, scope string) (*APIKey, string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	secret := apiKeyPrefix + hex.EncodeToString(b)
+	return &APIKey{
+		Tenant: tenant,
+		Scope:  scope,
+		Prefix: secret[:len(apiKeyPrefix)+6],
+		Hash:   HashAPIKey(secret),
+	}, secret, nil
+}
+
+// HashAPIKey returns the hash of the secret which the key is found by
+func HashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// UsageDay returns the UTC day of the quota usage
+func UsageDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
@@ -0,0 +1,56 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Classes of the crawl errors
+const (
+	ErrorClassRequest    = "request"    // the request to the host can not be made
+	ErrorClassDNS        = "dns"        // the host name is not resolved
+	ErrorClassTimeout    = "timeout"    // the host or the proxy does not answer in time
+	ErrorClassTLS        = "tls"        // the TLS handshake or the certificate fails
+	ErrorClassConnection = "connection" // the connection is refused or reset
+	ErrorClassHTTP       = "http"       // the host answers with the unexpected status
+	ErrorClassParse      = "parse"      // the page is not parsed
+)
+
+// Sort keys of the domain search, "-" before the key sorts in descending order
+var DomainSortKeys = map[string]string{
+	"id":           "id",
+	"host":         "host",
+	"checkedAt":    "checked_at",
+	"responseCode": "response_code",
+	"errorCount":   "error_count",
+}
+
+// DomainFilter selects the domains of the search, empty fields do not filter
+type DomainFilter struct {
+	ContentLang  string // detected language of the page text
+	DeclaredLang string // language declared by the tags or the sitemap
+	ResponseCode string
+	BlockerName  string
+	ErrorClass   string // one of the ErrorClass constants
+	TLD          string
+	CheckedFrom  *time.Time // crawled at or after
+	CheckedTo    *time.Time // crawled before
+	Sort         string     // one of DomainSortKeys, id by default
+}
+
+// SortColumn returns the column and the direction of the sort key
+func (f DomainFilter) SortColumn() (column string, desc bool, err error) {
+	key := f.Sort
+	if strings.HasPrefix(key, "-") {
+		key, desc = key[1:], true
+	}
+	if key == "" {
+		key = "id"
+	}
+	column, ok := DomainSortKeys[key]
+	if !ok {
+		return "", false, fmt.Errorf("unknown sort key %q", key)
+	}
+	return column, desc, nil
+}
@@ -5,7 +5,9 @@ import (
 	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/go-ozzo/ozzo-validation/is"
 	"gorm.io/gorm"
+	"net/url"
 	"strings"
+	"time"
 )
 
 const (
@@ -19,19 +21,28 @@ const (
 )
 
 type Domain struct {
-	gorm.Model               `json:"-"`
-	Host                     string    `json:"host" gorm:"column:host;unique"`
-	ResponseCode             string    `json:"responseCode" gorm:"column:response_code"`
-	ErrorCount               int       `json:"errorCount" gorm:"column:error_count"`
-	ContentLanguage          string    `json:"contentLang" gorm:"column:content_lang"`
-	TagsLanguages            []string  `json:"tagLanguages,omitempty" gorm:"-"`
-	SitemapLanguages         []string  `json:"sitemapLanguages,omitempty" gorm:"-"`
-	BlockerName              string    `json:"blockerName,omitempty" gorm:"column:blocker_name"`
-	IP                       string    `json:"ip" gorm:"column:ip"`
-	TagsLanguagesInternal    string    `json:"-" gorm:"column:tags_languages"`
-	SitemapLanguagesInternal string    `json:"-" gorm:"column:sitemap_languages"`
-	Requests                 []Request `json:"-" gorm:"foreignKey:DomainID;constraint:OnDelete:CASCADE;"`
-	Queue                    Queue     `json:"-" gorm:"foreignKey:DomainID;constraint:OnDelete:CASCADE;"`
+	gorm.Model       `json:"-"`
+	Host             string    `json:"host" gorm:"column:host;unique"`
+	ResponseCode     string    `json:"responseCode" gorm:"column:response_code"`
+	ErrorCount       int       `json:"errorCount" gorm:"column:error_count"`
+	ContentLanguage  string    `json:"contentLang" gorm:"column:content_lang"`
+	TagsLanguages    []string  `json:"tagLanguages,omitempty" gorm:"-"`
+	SitemapLanguages []string  `json:"sitemapLanguages,omitempty" gorm:"-"`
+	BlockerName      string    `json:"blockerName,omitempty" gorm:"column:blocker_name"`
+	ErrorClass       string    `json:"errorClass,omitempty" gorm:"column:error_class"` // kind of the last crawl error
+	TLD              string    `json:"tld" gorm:"column:tld"`                          // top level domain of the host
+	IP               string    `json:"ip" gorm:"column:ip"`
+	Requests         []Request `json:"-" gorm:"foreignKey:DomainID;constraint:OnDelete:CASCADE;"`
+	Queue            Queue     `json:"-" gorm:"foreignKey:DomainID;constraint:OnDelete:CASCADE;"` // holds the lease of the leased domain
+
+	// Languages are the rows of the content, tags and sitemap languages, they are
+	// built from the fields on update and fill the fields on read
+	Languages []DomainLanguage `json:"-" gorm:"foreignKey:DomainID;constraint:OnDelete:CASCADE;"`
+
+	CheckedAt  *time.Time `json:"checkedAt,omitempty" gorm:"column:checked_at"`       // time of the last crawl
+	AgeSeconds int64      `json:"ageSeconds,omitempty" gorm:"-"`                      // age of the crawl result
+	MaxAge     int64      `json:"maxAge,omitempty" gorm:"column:max_age"`             // seconds before recrawl, overrides the group
+	Group      string     `json:"refreshGroup,omitempty" gorm:"column:refresh_group"` // group of the freshness policy
 }
 
 func (d *Domain) Validate() error {
@@ -43,24 +54,70 @@ func (d *Domain) Validate() error {
 	)
 }
 
-func (d *Domain) languagesToString() {
-	d.TagsLanguagesInternal = strings.ToUpper(strings.Join(d.TagsLanguages, langSeparator))
-	d.SitemapLanguagesInternal = strings.ToUpper(strings.Join(d.SitemapLanguages, langSeparator))
+// BuildLanguages replaces the language rows with the content, tags and sitemap languages of the domain
+func (d *Domain) BuildLanguages() {
+	d.Languages = languageRows(d.ID, LangSourceContent, []string{d.ContentLanguage})
+	d.Languages = append(d.Languages, languageRows(d.ID, LangSourceTags, d.TagsLanguages)...)
+	d.Languages = append(d.Languages, languageRows(d.ID, LangSourceSitemap, d.SitemapLanguages)...)
+}
+
+func (d *Domain) languagesFromRows() {
+	d.TagsLanguages = languagesOf(d.Languages, LangSourceTags)
+	d.SitemapLanguages = languagesOf(d.Languages, LangSourceSitemap)
+}
+
+func joinLanguages(langs []string) string {
+	return strings.ToUpper(strings.Join(langs, langSeparator))
+}
+
+func splitLanguages(langs string) []string {
+	if langs == "" {
+		return nil
+	}
+	return strings.Split(langs, langSeparator)
+}
+
+// TLDOf returns the lower case top level domain of the host url, empty if the host has no dots
+func TLDOf(host string) string {
+	u, err := url.Parse(host)
+	if err != nil {
+		return ""
+	}
+	name := strings.ToLower(u.Hostname())
+	sep := strings.LastIndexByte(name, '.')
+	if sep < 0 {
+		return ""
+	}
+	return name[sep+1:]
 }
 
-func (d *Domain) languagesToSlice() {
-	if d.TagsLanguagesInternal != "" {
-		d.TagsLanguages = strings.Split(d.TagsLanguagesInternal, langSeparator)
+// NormalizeHost returns the host url of the submitted entry: the scheme is
+// https if it is missing, the host is lower case and the path is dropped.
+// False is returned for the empty or invalid entry.
+func NormalizeHost(entry string) (string, bool) {
+	entry = strings.Trim(strings.TrimSpace(entry), "\"'\ufeff")
+	if entry == "" {
+		return "", false
 	}
-	if d.SitemapLanguagesInternal != "" {
-		d.SitemapLanguages = strings.Split(d.SitemapLanguagesInternal, langSeparator)
+	if !strings.Contains(entry, "://") {
+		entry = "https://" + entry
 	}
+	u, err := url.Parse(entry)
+	if err != nil || u.Hostname() == "" || strings.ContainsAny(u.Host, " \t") {
+		return "", false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return "", false
+	}
+	return scheme + "://" + strings.ToLower(u.Host), true
 }
 
 func (d *Domain) BeforeCreate(*gorm.DB) (err error) {
 	if d.Host == "" {
 		return errors.New("empty host")
 	}
+	d.TLD = TLDOf(d.Host)
 	return nil
 }
 
@@ -75,13 +132,19 @@ func (d *Domain) BeforeUpdate(*gorm.DB) (err error) {
 		return err
 	}
 
-	d.languagesToString()
+	d.ContentLanguage = strings.ToUpper(d.ContentLanguage)
+	if d.Host != "" {
+		d.TLD = TLDOf(d.Host)
+	}
 
 	return nil
 }
 
 func (d *Domain) AfterFind(*gorm.DB) (err error) {
-	d.languagesToSlice()
+	d.languagesFromRows()
+	if d.CheckedAt != nil {
+		d.AgeSeconds = int64(time.Since(*d.CheckedAt).Seconds())
+	}
 	return nil
 }
 
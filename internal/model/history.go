@@ -0,0 +1,101 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DomainHistory is a snapshot of the domain made by every crawl
+type DomainHistory struct {
+	ID                       uint      `json:"id" gorm:"primaryKey"`
+	DomainID                 uint      `json:"domainId" gorm:"column:domain_id;index:idx_domain_histories_domain,priority:1"`
+	CrawledAt                time.Time `json:"crawledAt" gorm:"column:crawled_at;index:idx_domain_histories_domain,priority:2"`
+	ResponseCode             string    `json:"responseCode" gorm:"column:response_code"`
+	ContentLanguage          string    `json:"contentLang" gorm:"column:content_lang"`
+	TagsLanguages            []string  `json:"tagLanguages,omitempty" gorm:"-"`
+	SitemapLanguages         []string  `json:"sitemapLanguages,omitempty" gorm:"-"`
+	Proxy                    string    `json:"proxy" gorm:"column:proxy"`
+	Error                    string    `json:"error,omitempty" gorm:"column:error"`
+	TagsLanguagesInternal    string    `json:"-" gorm:"column:tags_languages"`
+	SitemapLanguagesInternal string    `json:"-" gorm:"column:sitemap_languages"`
+}
+
+// LanguageDiff shows how the languages of the domain changed between two crawls
+type LanguageDiff struct {
+	From               DomainHistory `json:"from"`
+	To                 DomainHistory `json:"to"`
+	ContentLangChanged bool          `json:"contentLangChanged"`
+	Added              []string      `json:"added,omitempty"`
+	Removed            []string      `json:"removed,omitempty"`
+}
+
+// NewDomainHistory makes the snapshot of the crawled domain
+func NewDomainHistory(domain Domain) DomainHistory {
+	crawledAt := time.Now()
+	if domain.CheckedAt != nil {
+		crawledAt = *domain.CheckedAt
+	}
+	return DomainHistory{
+		DomainID:         domain.ID,
+		CrawledAt:        crawledAt,
+		ResponseCode:     domain.ResponseCode,
+		ContentLanguage:  domain.ContentLanguage,
+		TagsLanguages:    domain.TagsLanguages,
+		SitemapLanguages: domain.SitemapLanguages,
+	}
+}
+
+// Languages returns the sorted set of the declared languages
+func (h *DomainHistory) Languages() []string {
+	set := make(map[string]struct{})
+	for _, list := range [][]string{h.TagsLanguages, h.SitemapLanguages} {
+		for _, lang := range list {
+			set[strings.ToUpper(lang)] = struct{}{}
+		}
+	}
+	res := make([]string, 0, len(set))
+	for lang := range set {
+		res = append(res, lang)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (h *DomainHistory) BeforeCreate(*gorm.DB) (err error) {
+	h.TagsLanguagesInternal = joinLanguages(h.TagsLanguages)
+	h.SitemapLanguagesInternal = joinLanguages(h.SitemapLanguages)
+	return nil
+}
+
+func (h *DomainHistory) AfterFind(*gorm.DB) (err error) {
+	h.TagsLanguages = splitLanguages(h.TagsLanguagesInternal)
+	h.SitemapLanguages = splitLanguages(h.SitemapLanguagesInternal)
+	return nil
+}
+
+// DiffLanguages compares two crawls of the domain
+func DiffLanguages(from, to DomainHistory) LanguageDiff {
+	diff := LanguageDiff{
+		From:               from,
+		To:                 to,
+		ContentLangChanged: !strings.EqualFold(from.ContentLanguage, to.ContentLanguage),
+	}
+	fromLangs := make(map[string]bool)
+	for _, lang := range from.Languages() {
+		fromLangs[lang] = true
+	}
+	for _, lang := range to.Languages() {
+		if fromLangs[lang] {
+			delete(fromLangs, lang)
+			continue
+		}
+		diff.Added = append(diff.Added, lang)
+	}
+	for lang := range fromLangs {
+		diff.Removed = append(diff.Removed, lang)
+	}
+	sort.Strings(diff.Removed)
+	return diff
+}
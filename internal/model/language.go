@@ -0,0 +1,55 @@
+package model
+
+import (
+	"sort"
+	"strings"
+)
+
+// Sources of the domain languages
+const (
+	LangSourceContent = "content" // detected in the page text
+	LangSourceTags    = "tags"    // declared by lang and hreflang attributes
+	LangSourceSitemap = "sitemap" // declared in the sitemap
+)
+
+// DomainLanguage is one language found on the domain
+type DomainLanguage struct {
+	DomainID   uint    `json:"-" gorm:"primaryKey;column:domain_id"`
+	Source     string  `json:"source" gorm:"primaryKey;column:source"`
+	Lang       string  `json:"lang" gorm:"primaryKey;column:lang"`
+	Confidence float64 `json:"confidence" gorm:"column:confidence"`
+}
+
+// LanguageCount is the number of domains with the language from the source
+type LanguageCount struct {
+	Lang    string `json:"lang"`
+	Source  string `json:"source"`
+	Domains int64  `json:"domains"`
+}
+
+// languageRows makes the rows of the languages declared by the source, the duplicates are skipped
+func languageRows(domainID uint, source string, langs []string) []DomainLanguage {
+	rows := make([]DomainLanguage, 0, len(langs))
+	seen := make(map[string]bool)
+	for _, lang := range langs {
+		lang = strings.ToUpper(lang)
+		if lang == "" || seen[lang] {
+			continue
+		}
+		seen[lang] = true
+		rows = append(rows, DomainLanguage{DomainID: domainID, Source: source, Lang: lang, Confidence: 1})
+	}
+	return rows
+}
+
+// languagesOf returns the sorted languages of the source
+func languagesOf(rows []DomainLanguage, source string) []string {
+	var langs []string
+	for _, row := range rows {
+		if row.Source == source {
+			langs = append(langs, row.Lang)
+		}
+	}
+	sort.Strings(langs)
+	return langs
+}
@@ -0,0 +1,90 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Sort keys of the cursors of the listings with the fixed order
+const (
+	CursorProxy   = "proxy"   // by id
+	CursorHistory = "history" // by crawl time and id from the latest
+	CursorRequest = "request" // crawled domains of the request by id
+)
+
+// ErrInvalidCursor is returned for the cursor which is broken or made by another listing
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page requests one page of the listing ordered by a unique key
+type Page struct {
+	Limit  int    // 0 means no limit
+	Cursor string // next cursor of the previous page, empty for the first page
+}
+
+// PageInfo describes the returned page
+type PageInfo struct {
+	NextCursor string `json:"next_cursor,omitempty"` // empty on the last page
+	Total      int64  `json:"total"`                 // number of all rows of the listing
+}
+
+// Cursor is the position of the last row of the page. The clients get it
+// encoded and pass it back as is.
+type Cursor struct {
+	Sort string     `json:"s"`           // sort key of the listing
+	ID   uint       `json:"id"`          // id breaks the ties of the sort key
+	Text string     `json:"t,omitempty"` // value of the text sort key
+	Num  int64      `json:"n,omitempty"` // value of the numeric sort key
+	Time *time.Time `json:"m,omitempty"` // value of the time sort key, nil if the row has no time
+}
+
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor reads the cursor of the listing with the sort key, nil for the first page
+func DecodeCursor(s, sort string) (*Cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c := &Cursor{}
+	if err = json.Unmarshal(b, c); err != nil || c.Sort != sort {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// DomainCursor returns the cursor after the domain in the listing sorted by the key
+func DomainCursor(domain Domain, sort string) Cursor {
+	c := Cursor{Sort: sort, ID: domain.ID}
+	column, _, _ := DomainFilter{Sort: sort}.SortColumn()
+	switch column {
+	case "host":
+		c.Text = domain.Host
+	case "response_code":
+		c.Text = domain.ResponseCode
+	case "error_count":
+		c.Num = int64(domain.ErrorCount)
+	case "checked_at":
+		c.Time = domain.CheckedAt
+	}
+	return c
+}
+
+// CursorDomain returns the domain with the values of the cursor for the comparison with the listed ones
+func CursorDomain(c Cursor) Domain {
+	domain := Domain{
+		Host:         c.Text,
+		ResponseCode: c.Text,
+		ErrorCount:   int(c.Num),
+		CheckedAt:    c.Time,
+	}
+	domain.ID = c.ID
+	return domain
+}
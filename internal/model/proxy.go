@@ -25,12 +25,20 @@ type Proxy struct {
 func (p *Proxy) Validate() error {
 	return validation.ValidateStruct(
 		p,
-		validation.Field(p.IP, validation.Required, is.IPv4),
-		validation.Field(p.Port, validation.Required, is.Digit),
-		validation.Field(p.Type, validation.Required),
+		validation.Field(&p.IP, validation.Required, is.IPv4),
+		validation.Field(&p.Port, validation.Required, is.Digit),
+		validation.Field(&p.Scheme, validation.Required),
 	)
 }
 
 func (p *Proxy) Type() string {
 	return strings.ToLower(p.Scheme)
 }
+
+// String describes the proxy without credentials
+func (p *Proxy) String() string {
+	if p.Type() == NoProxy {
+		return NoProxy
+	}
+	return p.Type() + "://" + p.IP + ":" + p.Port
+}
@@ -1,12 +1,35 @@
 package model
 
 import (
+	"errors"
 	"gorm.io/gorm"
 	"time"
 )
 
+// ErrLeaseLost is returned by the ack of the domain whose lease expired and
+// was taken by another worker meanwhile
+var ErrLeaseLost = errors.New("queue lease lost")
+
+// QueueLane is a part of the crawl queue with its own selection rules
+type QueueLane string
+
+const (
+	LaneUserRequest QueueLane = "user_request" // new domains requested by users
+	LaneQueue       QueueLane = "queue"        // new domains added from lists
+	LaneBadDomain   QueueLane = "bad_domain"   // retries of domains which failed before
+	LaneRefresh     QueueLane = "refresh"      // recrawl of stale domains
+)
+
+// QueueLanes lists lanes in the order of their priority
+var QueueLanes = []QueueLane{LaneUserRequest, LaneQueue, LaneBadDomain, LaneRefresh}
+
+// Queue is a domain waiting for crawling. While a worker crawls the domain the
+// row stays leased by it until the result is saved or the lease expires.
 type Queue struct {
 	gorm.Model
-	DomainID uint `gorm:"unique"`
-	UpdateAt time.Time
+	DomainID   uint `gorm:"unique"`
+	UpdateAt   time.Time
+	WorkerID   string
+	LeaseUntil *time.Time
+	Refresh    bool // queued by the freshness sweeper
 }
@@ -1,17 +1,96 @@
 package model
 
 import (
+	"errors"
+	"github.com/gofrs/uuid"
 	"gorm.io/gorm"
 	"time"
 )
 
+// Statuses of the request
+const (
+	RequestIngesting = "ingesting" // hosts of the uploaded file are being added to the request
+	RequestPending   = "pending"   // domains of the request are waiting for the crawl
+	RequestRunning   = "running"   // some domains are crawled, the status is not stored
+	RequestDone      = "done"      // all domains of the request are crawled
+	RequestPartial   = "partial"   // all domains are crawled, some of them with errors
+	RequestCancelled = "cancelled" // the request is cancelled by its owner
+	RequestExpired   = "expired"   // the request outlived its TTL, only the code is kept
+)
+
+var (
+	// ErrRequestExpired is returned for the request which outlived its TTL
+	ErrRequestExpired = errors.New("request expired")
+	// ErrRequestFinished is returned when the finished request is stopped
+	ErrRequestFinished = errors.New("request is already finished")
+)
+
+// RequestHeader is one submission of the hosts, its domains are linked by the Request rows
+type RequestHeader struct {
+	Code      string    `json:"code" gorm:"primaryKey;column:code"`
+	Owner     string    `json:"owner,omitempty" gorm:"column:owner"`
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at"`
+	Status    string    `json:"status" gorm:"column:status"`
+	Callback  string    `json:"callback,omitempty" gorm:"column:callback"`
+	Hosts     int       `json:"hosts" gorm:"column:hosts"` // number of the submitted hosts
+
+	// budget of the request, the domains over it are skipped
+	MaxDomains int        `json:"maxDomains,omitempty" gorm:"column:max_domains"` // 0 is unlimited
+	OverBudget int        `json:"-" gorm:"column:over_budget"`                    // hosts which are not queued for MaxDomains
+	Deadline   *time.Time `json:"deadline,omitempty" gorm:"column:deadline"`      // the request is stopped after it
+}
+
+func (RequestHeader) TableName() string {
+	return "requests"
+}
+
+// Overdue checks that the request passed its deadline
+func (r RequestHeader) Overdue(now time.Time) bool {
+	return r.Deadline != nil && now.After(*r.Deadline)
+}
+
+// Budget cuts the hosts to MaxDomains, the rest are counted as over budget
+func (r *RequestHeader) Budget(hosts []string) []string {
+	if r.MaxDomains > 0 && len(hosts) > r.MaxDomains {
+		r.OverBudget = len(hosts) - r.MaxDomains
+		hosts = hosts[:r.MaxDomains]
+	}
+	return hosts
+}
+
+// Expired checks that the request is expired by the janitor or outlived the ttl, 0 ttl never expires
+func (r RequestHeader) Expired(ttl time.Duration, now time.Time) bool {
+	return r.Status == RequestExpired || ttl > 0 && now.Sub(r.CreatedAt) > ttl
+}
+
+// Request links the domain to the request
 type Request struct {
 	DomainID  uint   `gorm:"primaryKey"`
 	Code      string `gorm:"primaryKey"`
 	CreatedAt time.Time
 }
 
+func (Request) TableName() string {
+	return "request_domains"
+}
+
 func (r *Request) BeforeCreate(*gorm.DB) (err error) {
 	r.CreatedAt = time.Now()
 	return nil
 }
+
+// RequestNotification is sent to the callback of the finished request
+type RequestNotification struct {
+	Code   string `json:"code"`
+	Status string `json:"status"`
+	Hosts  int    `json:"hosts"`
+}
+
+// NewRequestCode returns the random code of the new request
+func NewRequestCode() (string, error) {
+	code, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+	return code.String(), nil
+}
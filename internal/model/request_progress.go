@@ -0,0 +1,77 @@
+package model
+
+import "time"
+
+// RequestProgress counts the domains of the request by their state. The domain
+// is queued until it is crawled after the request was made, a failed domain
+// may be crawled again later but it does not hold the request.
+type RequestProgress struct {
+	Total   int64 `json:"total"`
+	Done    int64 `json:"done"`    // crawled successfully
+	Failed  int64 `json:"failed"`  // crawled with an error
+	Queued  int64 `json:"queued"`  // waiting for the crawl
+	Skipped int64 `json:"skipped"` // left by the budget, the deadline or the cancellation
+}
+
+// Settle applies the budget of the request to the counts of the store: the
+// hosts over MaxDomains and the domains left queued by the cancellation or by
+// the deadline are skipped too
+func (p RequestProgress) Settle(request RequestHeader, now time.Time) RequestProgress {
+	p.Total += int64(request.OverBudget)
+	p.Skipped += int64(request.OverBudget)
+	if request.Status == RequestCancelled || request.Overdue(now) {
+		p.Skipped += p.Queued
+		p.Queued = 0
+	}
+	return p
+}
+
+func (p RequestProgress) Ready() bool {
+	return p.Queued == 0
+}
+
+// StatusOf returns the status of the request with the progress
+func (p RequestProgress) StatusOf(request RequestHeader) string {
+	switch {
+	case request.Status == RequestCancelled, request.Status == RequestIngesting:
+		return request.Status
+	case p.Queued > 0 && p.Done+p.Failed == 0:
+		return RequestPending
+	case p.Queued > 0:
+		return RequestRunning
+	case p.Failed > 0 || p.Skipped > 0:
+		return RequestPartial
+	}
+	return RequestDone
+}
+
+// ETA estimates when the queued domains are crawled at the rate of the crawled ones, nil if it is unknown
+func (p RequestProgress) ETA(request RequestHeader, now time.Time) *time.Time {
+	crawled := p.Done + p.Failed
+	if p.Ready() || crawled == 0 {
+		return nil
+	}
+	elapsed := now.Sub(request.CreatedAt)
+	eta := now.Add(time.Duration(float64(elapsed) / float64(crawled) * float64(p.Queued)))
+	if request.Deadline != nil && eta.After(*request.Deadline) {
+		eta = *request.Deadline
+	}
+	return &eta
+}
+
+// RequestState is the request with its progress as the clients see it
+type RequestState struct {
+	RequestHeader
+	RequestProgress
+	ETA *time.Time `json:"eta,omitempty"`
+}
+
+func NewRequestState(request RequestHeader, progress RequestProgress, now time.Time) RequestState {
+	progress = progress.Settle(request, now)
+	request.Status = progress.StatusOf(request)
+	return RequestState{
+		RequestHeader:   request,
+		RequestProgress: progress,
+		ETA:             progress.ETA(request, now),
+	}
+}
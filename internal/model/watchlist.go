@@ -0,0 +1,45 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Watchlist is a named set of domains recrawled on schedule. Language changes
+// of the domains are sent to the callback.
+type Watchlist struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	Name      string     `json:"name" gorm:"column:name;unique"`
+	Owner     string     `json:"owner,omitempty" gorm:"column:owner"` // tenant of the key which created it
+	Callback  string     `json:"callback,omitempty" gorm:"column:callback"`
+	Interval  int64      `json:"interval" gorm:"column:interval"` // seconds between recrawls
+	LastRunAt *time.Time `json:"lastRunAt,omitempty" gorm:"column:last_run_at"`
+	CreatedAt time.Time  `json:"createdAt"`
+	Domains   []Domain   `json:"domains,omitempty" gorm:"many2many:watchlist_domains;constraint:OnDelete:CASCADE;"`
+}
+
+// WatchlistNotification is sent to the watchlist callback when languages of the domain change
+type WatchlistNotification struct {
+	Watchlist string       `json:"watchlist"`
+	Host      string       `json:"host"`
+	Diff      LanguageDiff `json:"diff"`
+}
+
+// Due reports whether the watchlist should be recrawled now
+func (w *Watchlist) Due(now time.Time) bool {
+	return w.LastRunAt == nil || w.LastRunAt.Add(time.Duration(w.Interval)*time.Second).Before(now)
+}
+
+func (w *Watchlist) BeforeCreate(*gorm.DB) (err error) {
+	if w.Name == "" {
+		return errors.New("empty watchlist name")
+	}
+	return nil
+}
+
+// Changed reports whether the diff should be notified
+func (d *LanguageDiff) Changed() bool {
+	return d.ContentLangChanged || len(d.Added) > 0 || len(d.Removed) > 0
+}
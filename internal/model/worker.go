@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// Worker is a heartbeat of a crawler process
+type Worker struct {
+	ID          string    `json:"id" gorm:"primaryKey;column:id"`
+	Host        string    `json:"host" gorm:"column:host"`
+	Threads     uint      `json:"threads" gorm:"column:threads"`
+	Active      int       `json:"active" gorm:"column:active"`
+	StartedAt   time.Time `json:"startedAt" gorm:"column:started_at"`
+	HeartbeatAt time.Time `json:"heartbeatAt" gorm:"column:heartbeat_at"`
+}
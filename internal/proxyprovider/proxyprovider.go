@@ -1,16 +1,21 @@
 package proxyprovider
 
 import (
+	"context"
 	"restapi_langparser/internal/config"
 	"restapi_langparser/internal/model"
 	"restapi_langparser/internal/store"
+	"sync"
 )
 
+// ProxyProvider shares the proxies between the crawl workers
 type ProxyProvider struct {
+	m            sync.Mutex
 	config       *config.Config
 	store        store.IStore
 	activeProxy  []proxyItem
 	noProxyCount uint
+	released     chan struct{}
 }
 
 type proxyItem struct {
@@ -18,25 +23,32 @@ type proxyItem struct {
 	threadCount uint
 }
 
-func New(config *config.Config, store store.IStore) *ProxyProvider {
+func New(ctx context.Context, config *config.Config, store store.IStore) *ProxyProvider {
 	pp := &ProxyProvider{
 		config:      config,
 		store:       store,
 		activeProxy: make([]proxyItem, 0),
+		released:    make(chan struct{}, 1),
 	}
-	pp.updateList()
+	pp.updateList(ctx)
 	return pp
 }
 
+// Get takes a thread of a proxy, nil if all of them are busy
 func (p *ProxyProvider) Get() *model.Proxy {
-	for _, item := range p.activeProxy {
-		if item.threadCount < p.config.ThreadsPerProxy {
-			item.threadCount++
-			return &item.proxy
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	for i := range p.activeProxy {
+		if p.activeProxy[i].threadCount < p.config.ThreadsPerProxy {
+			p.activeProxy[i].threadCount++
+			proxy := p.activeProxy[i].proxy
+			return &proxy
 		}
 	}
 
-	if p.noProxyCount < p.config.ThreadsPerProxy {
+	// the proxies are used alone unless the crawl without proxy is allowed too
+	if (p.config.UseIP || len(p.activeProxy) == 0) && p.noProxyCount < p.config.ThreadsPerProxy {
 		p.noProxyCount++
 		return &model.Proxy{
 			ID:     -1,
@@ -51,27 +63,46 @@ func (p *ProxyProvider) Release(proxy *model.Proxy) {
 	if proxy == nil {
 		return
 	}
+	p.m.Lock()
+	defer p.m.Unlock()
+	defer p.notifyReleased()
+
 	if proxy.Type() == model.NoProxy {
 		if p.noProxyCount > 0 {
 			p.noProxyCount--
 		}
 		return
 	}
-	for _, item := range p.activeProxy {
-		if item.proxy.ID == proxy.ID {
-			if item.threadCount > 0 {
-				item.threadCount--
+	for i := range p.activeProxy {
+		if p.activeProxy[i].proxy.ID == proxy.ID {
+			if p.activeProxy[i].threadCount > 0 {
+				p.activeProxy[i].threadCount--
 			}
 			return
 		}
 	}
 }
 
-func (p *ProxyProvider) updateList() {
-	list, err := p.store.Proxy().Read(0, 0)
+// Released signals when a thread of a proxy is released, the signal is dropped if one is already pending
+func (p *ProxyProvider) Released() <-chan struct{} {
+	return p.released
+}
+
+func (p *ProxyProvider) notifyReleased() {
+	select {
+	case p.released <- struct{}{}:
+	default:
+	}
+}
+
+func (p *ProxyProvider) updateList(ctx context.Context) {
+	list, _, err := p.store.Proxy().Read(ctx, model.Page{})
 	if err != nil {
 		return
 	}
+	p.m.Lock()
+	defer p.m.Unlock()
+
 	newList := make([]proxyItem, len(list))
 	for i, proxy := range list {
 		for _, item := range p.activeProxy {
@@ -0,0 +1,118 @@
+package proxyprovider
+
+import (
+	"context"
+	"restapi_langparser/internal/config"
+	"restapi_langparser/internal/model"
+	"restapi_langparser/internal/store/memstore"
+	"sync"
+	"testing"
+)
+
+func TestProxyProvider_ThreadsPerProxy(t *testing.T) {
+	ctx := context.Background()
+	st := memstore.New()
+	proxies := []model.Proxy{
+		{IP: "127.0.0.1", Port: "3128", Scheme: model.HTTPS},
+		{IP: "127.0.0.2", Port: "3128", Scheme: model.HTTPS},
+	}
+	if err := st.Proxy().Create(ctx, proxies); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.New()
+	cfg.ThreadsPerProxy = 3
+	cfg.UseIP = true
+	p := New(ctx, cfg, st)
+
+	// the threads of the proxies, then of the crawl without proxy
+	var m sync.Mutex
+	var wg sync.WaitGroup
+	taken := make([]*model.Proxy, 0)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if proxy := p.Get(); proxy != nil {
+				m.Lock()
+				taken = append(taken, proxy)
+				m.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	counts := make(map[string]int)
+	for _, proxy := range taken {
+		counts[proxy.String()]++
+	}
+	want := map[string]int{"https://127.0.0.1:3128": 3, "https://127.0.0.2:3128": 3, model.NoProxy: 3}
+	if len(counts) != len(want) {
+		t.Fatalf("threads %v, want %v", counts, want)
+	}
+	for proxy, count := range want {
+		if counts[proxy] != count {
+			t.Fatalf("threads %v, want %v", counts, want)
+		}
+	}
+
+	// the released thread is taken again
+	p.Release(taken[0])
+	if proxy := p.Get(); proxy == nil || proxy.String() != taken[0].String() {
+		t.Fatalf("proxy %v after release of %v", proxy, taken[0])
+	}
+	if proxy := p.Get(); proxy != nil {
+		t.Fatalf("proxy %v over the thread limit", proxy)
+	}
+}
+
+func TestProxyProvider_UseIP(t *testing.T) {
+	ctx := context.Background()
+	testCases := []struct {
+		name    string
+		proxies []model.Proxy
+		useIP   bool
+		want    []string
+	}{
+		{
+			name:    "proxies only",
+			proxies: []model.Proxy{{IP: "127.0.0.1", Port: "3128", Scheme: model.HTTPS}},
+			want:    []string{"https://127.0.0.1:3128", "https://127.0.0.1:3128", ""},
+		},
+		{
+			name:    "proxies and ip",
+			proxies: []model.Proxy{{IP: "127.0.0.1", Port: "3128", Scheme: model.HTTPS}},
+			useIP:   true,
+			want:    []string{"https://127.0.0.1:3128", "https://127.0.0.1:3128", model.NoProxy, model.NoProxy, ""},
+		},
+		{
+			name: "no proxies",
+			want: []string{model.NoProxy, model.NoProxy, ""},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			st := memstore.New()
+			if len(tc.proxies) > 0 {
+				if err := st.Proxy().Create(ctx, tc.proxies); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cfg := config.New()
+			cfg.ThreadsPerProxy = 2
+			cfg.UseIP = tc.useIP
+			p := New(ctx, cfg, st)
+
+			for i, want := range tc.want {
+				proxy := p.Get()
+				got := ""
+				if proxy != nil {
+					got = proxy.String()
+				}
+				if got != want {
+					t.Fatalf("thread %d: proxy %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
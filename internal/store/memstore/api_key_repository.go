@@ -0,0 +1,113 @@
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"restapi_langparser/internal/model"
+	"restapi_langparser/internal/store"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type APIKeyRepository struct {
+	s *Store
+}
+
+func NewAPIKeyRepository(s *Store) *APIKeyRepository {
+	return &APIKeyRepository{
+		s: s,
+	}
+}
+
+func (k *APIKeyRepository) Create(_ context.Context, key *model.APIKey) error {
+	if err := key.Validate(); err != nil {
+		return err
+	}
+
+	k.s.m.Lock()
+	defer k.s.m.Unlock()
+
+	for _, current := range k.s.apiKeys {
+		if current.Hash == key.Hash {
+			return fmt.Errorf("api key %s %w", key.Prefix, store.ErrConflict)
+		}
+	}
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+	k.s.lastAPIKeyID++
+	key.ID = k.s.lastAPIKeyID
+	k.s.apiKeys[key.ID] = *key
+	return nil
+}
+
+func (k *APIKeyRepository) Read(_ context.Context) ([]model.APIKey, error) {
+	k.s.m.RLock()
+	defer k.s.m.RUnlock()
+
+	keys := make([]model.APIKey, 0, len(k.s.apiKeys))
+	for _, key := range k.s.apiKeys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, nil
+}
+
+func (k *APIKeyRepository) FindByHash(_ context.Context, hash string) (*model.APIKey, error) {
+	k.s.m.RLock()
+	defer k.s.m.RUnlock()
+
+	for _, key := range k.s.apiKeys {
+		if key.Hash == hash && key.RevokedAt == nil {
+			return &key, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (k *APIKeyRepository) Revoke(_ context.Context, id uint, at time.Time) error {
+	k.s.m.Lock()
+	defer k.s.m.Unlock()
+
+	key, exists := k.s.apiKeys[id]
+	if !exists {
+		return gorm.ErrRecordNotFound
+	}
+	if key.RevokedAt == nil {
+		key.RevokedAt = &at
+		k.s.apiKeys[id] = key
+	}
+	return nil
+}
+
+func (k *APIKeyRepository) AddUsage(_ context.Context, id uint, day string, hosts, limit int) (bool, error) {
+	k.s.m.Lock()
+	defer k.s.m.Unlock()
+
+	return k.s.addUsage(id, day, hosts, limit), nil
+}
+
+// addUsage adds the hosts to the usage of the key, false if they are over the limit
+func (s *Store) addUsage(id uint, day string, hosts, limit int) bool {
+	usage := s.keyUsage[id]
+	if usage == nil {
+		usage = make(map[string]int)
+		s.keyUsage[id] = usage
+	}
+	if limit > 0 && usage[day]+hosts > limit {
+		return false
+	}
+	usage[day] += hosts
+	return true
+}
+
+func (k *APIKeyRepository) Usage(_ context.Context, id uint, day string) (int, error) {
+	k.s.m.RLock()
+	defer k.s.m.RUnlock()
+
+	return k.s.keyUsage[id][day], nil
+}
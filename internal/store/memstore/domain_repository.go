@@ -1,114 +1,306 @@
 package memstore
 
 import (
-	"errors"
-	"fmt"
+	"context"
 	"restapi_langparser/internal/model"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
 )
 
 type DomainRepository struct {
-	store  map[int]*model.Domain
-	lastID uint
+	s *Store
 }
 
-func NewDomainRepository() *DomainRepository {
+func NewDomainRepository(s *Store) *DomainRepository {
 	return &DomainRepository{
-		store: make(map[int]*model.Domain),
+		s: s,
 	}
 }
 
-func (d *DomainRepository) getID() (uint, error) {
-	prev := d.lastID
-	d.lastID++
-	cycle := prev < d.lastID
-	for _, exists := d.store[int(d.lastID)]; exists; _, exists = d.store[int(d.lastID)] {
-		if prev > d.lastID && cycle {
-			return 0, errors.New("no id available")
-		}
-		d.lastID++
-		cycle = prev < d.lastID
+func (d *DomainRepository) Create(_ context.Context, domains ...model.Domain) error {
+	d.s.m.Lock()
+	defer d.s.m.Unlock()
+	return d.s.createDomains(domains...)
+}
+
+func (d *DomainRepository) Update(_ context.Context, target model.Domain) error {
+	d.s.m.Lock()
+	defer d.s.m.Unlock()
+	return d.s.updateDomain(target)
+}
+
+// updateDomain finds the domain by host if the id is unknown and keeps the host if it is empty
+func (s *Store) updateDomain(target model.Domain) error {
+	switch {
+	case target.ID == 0 && target.Host != "":
+		target.ID = s.hosts[target.Host]
+	case target.ID > 0 && target.Host == "":
+		target.Host = s.domains[target.ID].Host
 	}
-	return d.lastID, nil
+	return s.saveDomain(target)
 }
 
-func (d *DomainRepository) Create(domains ...model.Domain) error {
-	var err error
-	for _, domain := range domains {
-		domain.ID, err = d.getID()
-		if err != nil {
-			return fmt.Errorf(err.Error())
-		}
-		d.store[int(domain.ID)] = &domain
+func (d *DomainRepository) Read(ctx context.Context, page model.Page) ([]model.Domain, *model.PageInfo, error) {
+	return d.Search(ctx, model.DomainFilter{}, page)
+}
+
+// pageEnd returns the end of the page of the list from the start, more tells
+// that the rows follow the page. Limit 0 means no limit.
+func pageEnd(start, length, limit int) (end int, more bool) {
+	if limit > 0 && length-start > limit {
+		return start + limit, true
+	}
+	return length, false
+}
+
+func (d *DomainRepository) Delete(_ context.Context, target ...model.Domain) error {
+	d.s.m.Lock()
+	defer d.s.m.Unlock()
+	for _, t := range target {
+		d.s.deleteDomain(t.ID, true)
 	}
 	return nil
 }
 
-func (d *DomainRepository) Read(limit, offset int) ([]model.Domain, error) {
-	if len(d.store) < offset+limit {
-		return nil, errors.New("out of range")
+func (d *DomainRepository) FindByID(_ context.Context, id int) (*model.Domain, error) {
+	d.s.m.RLock()
+	defer d.s.m.RUnlock()
+	domain, exists := d.s.readDomain(uint(id))
+	if !exists {
+		return nil, gorm.ErrRecordNotFound
 	}
-	res := make([]model.Domain, 0)
-	for _, item := range d.store {
-		if offset > 0 {
-			offset--
-			continue
-		}
-		res = append(res, *item)
-		limit--
-		if limit == 0 {
-			break
+	return &domain, nil
+}
+
+func (d *DomainRepository) FindByTagLang(ctx context.Context, lang string) ([]model.Domain, error) {
+	return d.findByLang(ctx, model.LangSourceTags, lang)
+}
+
+func (d *DomainRepository) FindBySMLang(ctx context.Context, lang string) ([]model.Domain, error) {
+	return d.findByLang(ctx, model.LangSourceSitemap, lang)
+}
+
+func (d *DomainRepository) FindByContentLang(ctx context.Context, lang string) ([]model.Domain, error) {
+	return d.findByLang(ctx, model.LangSourceContent, lang)
+}
+
+func (d *DomainRepository) findByLang(_ context.Context, source, lang string) ([]model.Domain, error) {
+	lang = strings.ToUpper(lang)
+	d.s.m.RLock()
+	defer d.s.m.RUnlock()
+	return d.s.sortedDomains(func(domain model.Domain) bool {
+		for _, row := range domain.Languages {
+			if row.Source == source && row.Lang == lang {
+				return true
+			}
 		}
-	}
-	return res, nil
+		return false
+	}), nil
 }
 
-func (d *DomainRepository) Update(target model.Domain) error {
-	return nil
+func (d *DomainRepository) Search(_ context.Context, filter model.DomainFilter, page model.Page) ([]model.Domain, *model.PageInfo, error) {
+	column, desc, err := filter.SortColumn()
+	if err != nil {
+		return nil, nil, err
+	}
+	cursor, err := model.DecodeCursor(page.Cursor, filter.Sort)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d.s.m.RLock()
+	defer d.s.m.RUnlock()
+	list := d.s.sortedDomains(matchDomain(filter))
+
+	less := lessDomains(column, desc)
+	sort.Slice(list, func(i, j int) bool {
+		return less(list[i], list[j])
+	})
+
+	info := &model.PageInfo{Total: int64(len(list))}
+	start := 0
+	if cursor != nil {
+		pivot := model.CursorDomain(*cursor)
+		start = sort.Search(len(list), func(i int) bool {
+			return less(pivot, list[i])
+		})
+	}
+	end, more := pageEnd(start, len(list), page.Limit)
+	list = list[start:end]
+	if more {
+		info.NextCursor = model.DomainCursor(list[len(list)-1], filter.Sort).Encode()
+	}
+	return list, info, nil
 }
 
-func (d *DomainRepository) Delete(target ...model.Domain) error {
-	for _, t := range target {
-		delete(d.store, int(t.ID))
+// Export calls fn for each domain matching the filter in the order of id, with
+// the request code only the crawled domains of the request are exported
+func (d *DomainRepository) Export(_ context.Context, filter model.DomainFilter, requestCode string, fn func(model.Domain) error) error {
+	match := matchDomain(filter)
+
+	d.s.m.RLock()
+	if requestCode != "" {
+		if _, exists := d.s.requestHeaders[requestCode]; !exists {
+			d.s.m.RUnlock()
+			return gorm.ErrRecordNotFound
+		}
+		links, filterMatch := d.s.requests[requestCode], match
+		match = func(domain model.Domain) bool {
+			createdAt, ok := links[domain.ID]
+			return ok && !d.s.requestQueued(domain, createdAt) && filterMatch(domain)
+		}
+	}
+	list := d.s.sortedDomains(match)
+	d.s.m.RUnlock()
+
+	for _, domain := range list {
+		if err := fn(domain); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func (d *DomainRepository) FindByID(id int) (*model.Domain, error) {
-	domain, exists := d.store[id]
-	if !exists {
-		return nil, fmt.Errorf("record with id=%d not found", id)
+// matchDomain checks that the domain matches all filters as the SQL store does
+func matchDomain(filter model.DomainFilter) func(domain model.Domain) bool {
+	contentLang := strings.ToUpper(filter.ContentLang)
+	declaredLang := strings.ToUpper(filter.DeclaredLang)
+	tld := strings.ToLower(filter.TLD)
+
+	return func(domain model.Domain) bool {
+		switch {
+		case contentLang != "" && !hasLanguage(domain, contentLang, model.LangSourceContent),
+			declaredLang != "" && !hasLanguage(domain, declaredLang, model.LangSourceTags, model.LangSourceSitemap),
+			filter.ResponseCode != "" && domain.ResponseCode != filter.ResponseCode,
+			filter.BlockerName != "" && domain.BlockerName != filter.BlockerName,
+			filter.ErrorClass != "" && domain.ErrorClass != filter.ErrorClass,
+			tld != "" && domain.TLD != tld:
+			return false
+		}
+		if filter.CheckedFrom != nil || filter.CheckedTo != nil {
+			if domain.CheckedAt == nil ||
+				filter.CheckedFrom != nil && domain.CheckedAt.Before(*filter.CheckedFrom) ||
+				filter.CheckedTo != nil && !domain.CheckedAt.Before(*filter.CheckedTo) {
+				return false
+			}
+		}
+		return true
 	}
-	return domain, nil
 }
 
-func (d *DomainRepository) FindByTagLang(lang string) ([]model.Domain, error) {
-	return nil, errors.New("method not implemented")
+// lessDomains orders the domains by the sort column and id as the SQL store does
+func lessDomains(column string, desc bool) func(a, b model.Domain) bool {
+	compare := compareDomains(column)
+	return func(a, b model.Domain) bool {
+		if column == "checked_at" && (a.CheckedAt == nil) != (b.CheckedAt == nil) {
+			return b.CheckedAt == nil // not crawled domains are the last in both directions
+		}
+		c := compare(a, b)
+		if c == 0 {
+			c = int(a.ID) - int(b.ID)
+		}
+		if desc {
+			c = -c
+		}
+		return c < 0
+	}
 }
 
-func (d *DomainRepository) FindBySMLang(lang string) ([]model.Domain, error) {
-	return nil, errors.New("method not implemented")
+func hasLanguage(domain model.Domain, lang string, sources ...string) bool {
+	for _, row := range domain.Languages {
+		if row.Lang != lang {
+			continue
+		}
+		for _, source := range sources {
+			if row.Source == source {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-func (d *DomainRepository) FindByContentLang(lang string) ([]model.Domain, error) {
-	return nil, errors.New("method not implemented")
+// compareDomains returns the comparison of the sort column as the SQL store orders it
+func compareDomains(column string) func(a, b model.Domain) int {
+	switch column {
+	case "host":
+		return func(a, b model.Domain) int { return strings.Compare(a.Host, b.Host) }
+	case "response_code":
+		return func(a, b model.Domain) int { return strings.Compare(a.ResponseCode, b.ResponseCode) }
+	case "error_count":
+		return func(a, b model.Domain) int { return a.ErrorCount - b.ErrorCount }
+	case "checked_at":
+		return func(a, b model.Domain) int {
+			switch {
+			case a.CheckedAt == nil || b.CheckedAt == nil:
+				return 0
+			case a.CheckedAt.Before(*b.CheckedAt):
+				return -1
+			case b.CheckedAt.Before(*a.CheckedAt):
+				return 1
+			}
+			return 0
+		}
+	}
+	return func(a, b model.Domain) int { return 0 }
 }
 
-func (d *DomainRepository) FindByHost(hosts ...string) ([]model.Domain, error) {
-	return nil, errors.New("method not implemented")
-}
+func (d *DomainRepository) CountByLang(_ context.Context) ([]model.LanguageCount, error) {
+	d.s.m.RLock()
+	defer d.s.m.RUnlock()
 
-func (d *DomainRepository) GetUserRequest() (*model.Domain, error) {
-	return nil, errors.New("method not implemented")
+	index := make(map[model.LanguageCount]int)
+	counts := make([]model.LanguageCount, 0)
+	for _, domain := range d.s.domains {
+		for _, row := range domain.Languages {
+			key := model.LanguageCount{Lang: row.Lang, Source: row.Source}
+			i, ok := index[key]
+			if !ok {
+				i = len(counts)
+				index[key] = i
+				counts = append(counts, key)
+			}
+			counts[i].Domains++
+		}
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		a, b := counts[i], counts[j]
+		if a.Domains != b.Domains {
+			return a.Domains > b.Domains
+		}
+		if a.Lang != b.Lang {
+			return a.Lang < b.Lang
+		}
+		return a.Source < b.Source
+	})
+	return counts, nil
 }
 
-func (d *DomainRepository) GetErrorHost() (*model.Domain, error) {
-	return nil, errors.New("method not implemented")
+func (d *DomainRepository) FindByHost(_ context.Context, hosts ...string) ([]model.Domain, error) {
+	d.s.m.RLock()
+	defer d.s.m.RUnlock()
+	return d.s.findByHost(hosts...), nil
 }
 
-func (d *DomainRepository) GetListHost() (*model.Domain, error) {
-	return nil, errors.New("method not implemented")
+func (s *Store) findByHost(hosts ...string) []model.Domain {
+	set := make(map[string]struct{}, len(hosts))
+	for _, host := range hosts {
+		set[host] = struct{}{}
+	}
+	return s.sortedDomains(func(domain model.Domain) bool {
+		_, ok := set[domain.Host]
+		return ok
+	})
 }
 
-func (d *DomainRepository) CreateWithHost(from string, hosts ...string) ([]model.Domain, error) {
-	return nil, errors.New("method not implemented")
+func (d *DomainRepository) CreateWithHost(_ context.Context, hosts ...string) ([]model.Domain, error) {
+	d.s.m.Lock()
+	defer d.s.m.Unlock()
+
+	if err := d.s.createDomains(model.CreateDomainsList(hosts)...); err != nil {
+		return nil, err
+	}
+	return d.s.findByHost(hosts...), nil
 }
@@ -0,0 +1,83 @@
+package memstore
+
+import (
+	"context"
+	"restapi_langparser/internal/model"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+// SaveCrawlResult updates the domain and adds the crawl to its history at once
+func (s *Store) SaveCrawlResult(_ context.Context, domain model.Domain, history model.DomainHistory) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if err := s.updateDomain(domain); err != nil {
+		return err
+	}
+
+	_ = history.BeforeCreate(nil)
+	s.lastHistoryID++
+	history.ID = s.lastHistoryID
+	s.history[history.DomainID] = append(s.history[history.DomainID], history)
+	return nil
+}
+
+// GetHistory returns crawls of the domain from the latest one
+func (s *Store) GetHistory(_ context.Context, domainID uint, page model.Page) ([]model.DomainHistory, *model.PageInfo, error) {
+	cursor, err := model.DecodeCursor(page.Cursor, model.CursorHistory)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cursor != nil && cursor.Time == nil {
+		return nil, nil, model.ErrInvalidCursor
+	}
+
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	history := make([]model.DomainHistory, 0, len(s.history[domainID]))
+	for _, record := range s.history[domainID] {
+		_ = record.AfterFind(nil)
+		history = append(history, record)
+	}
+	later := func(a, b model.DomainHistory) bool {
+		if a.CrawledAt.Equal(b.CrawledAt) {
+			return a.ID > b.ID
+		}
+		return a.CrawledAt.After(b.CrawledAt)
+	}
+	sort.Slice(history, func(i, j int) bool {
+		return later(history[i], history[j])
+	})
+
+	info := &model.PageInfo{Total: int64(len(history))}
+	start := 0
+	if cursor != nil {
+		pivot := model.DomainHistory{ID: cursor.ID, CrawledAt: *cursor.Time}
+		start = sort.Search(len(history), func(i int) bool {
+			return later(pivot, history[i])
+		})
+	}
+	end, more := pageEnd(start, len(history), page.Limit)
+	history = history[start:end]
+	if more {
+		last := history[len(history)-1]
+		info.NextCursor = model.Cursor{Sort: model.CursorHistory, ID: last.ID, Time: &last.CrawledAt}.Encode()
+	}
+	return history, info, nil
+}
+
+func (s *Store) GetHistoryRecord(_ context.Context, domainID, id uint) (*model.DomainHistory, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	for _, record := range s.history[domainID] {
+		if record.ID == id {
+			_ = record.AfterFind(nil)
+			return &record, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
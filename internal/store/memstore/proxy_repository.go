@@ -1,81 +1,105 @@
 package memstore
 
 import (
-	"errors"
-	"fmt"
+	"context"
 	"restapi_langparser/internal/model"
-	"restapi_langparser/internal/store"
+	"sort"
+
+	"gorm.io/gorm"
 )
 
 type ProxyRepository struct {
-	store  map[int]model.Proxy
-	lastID int
+	s *Store
 }
 
-func (p *ProxyRepository) getID() (int, error) {
-	prev := p.lastID
-	p.lastID++
-	cycle := prev < p.lastID
-	for _, exists := p.store[p.lastID]; exists; _, exists = p.store[p.lastID] {
-		if prev > p.lastID && cycle {
-			return 0, errors.New("no id available")
-		}
-		p.lastID++
-		cycle = prev < p.lastID
-	}
-	return p.lastID, nil
-}
-
-func NewProxyRepository() store.IProxyRepository {
+func NewProxyRepository(s *Store) *ProxyRepository {
 	return &ProxyRepository{
-		store: make(map[int]model.Proxy),
+		s: s,
 	}
 }
 
-func (p *ProxyRepository) Add(list []model.Proxy) error {
-	var err error
-	for i, proxy := range list {
-		proxy.ID, err = p.getID()
-		if err != nil {
-			return fmt.Errorf("%v %d records processed", err, i-1)
+func (p *ProxyRepository) Create(_ context.Context, list []model.Proxy) error {
+	p.s.m.Lock()
+	defer p.s.m.Unlock()
+
+	for _, proxy := range list {
+		if proxy.Validate() != nil { // skip if not valid
+			continue
 		}
-		p.store[proxy.ID] = proxy
+		p.s.lastProxyID++
+		proxy.ID = p.s.lastProxyID
+		p.s.proxies[proxy.ID] = proxy
 	}
-
 	return nil
 }
 
-func (p *ProxyRepository) List(limit, offset int) ([]model.Proxy, error) {
-	if len(p.store) < offset+limit {
-		return nil, errors.New("out of range")
+func (p *ProxyRepository) Read(_ context.Context, page model.Page) ([]model.Proxy, *model.PageInfo, error) {
+	cursor, err := model.DecodeCursor(page.Cursor, model.CursorProxy)
+	if err != nil {
+		return nil, nil, err
 	}
-	res := make([]model.Proxy, 0)
-	for _, item := range p.store {
-		if offset > 0 {
-			offset--
-			continue
-		}
-		res = append(res, item)
-		limit--
-		if limit == 0 {
-			break
-		}
+
+	p.s.m.RLock()
+	defer p.s.m.RUnlock()
+
+	list := make([]model.Proxy, 0, len(p.s.proxies))
+	for _, proxy := range p.s.proxies {
+		list = append(list, proxy)
 	}
-	return res, nil
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+
+	info := &model.PageInfo{Total: int64(len(list))}
+	start := 0
+	if cursor != nil {
+		start = sort.Search(len(list), func(i int) bool {
+			return uint(list[i].ID) > cursor.ID
+		})
+	}
+	end, more := pageEnd(start, len(list), page.Limit)
+	list = list[start:end]
+	if more {
+		info.NextCursor = model.Cursor{Sort: model.CursorProxy, ID: uint(list[len(list)-1].ID)}.Encode()
+	}
+	return list, info, nil
 }
 
-func (p *ProxyRepository) Remove(ids []int) error {
+func (p *ProxyRepository) Delete(_ context.Context, ids []int) error {
+	p.s.m.Lock()
+	defer p.s.m.Unlock()
+
+	for _, id := range ids {
+		delete(p.s.proxies, id)
+	}
 	return nil
 }
 
-func (p *ProxyRepository) Update(list []model.Proxy) error {
+func (p *ProxyRepository) Update(_ context.Context, list []model.Proxy) error {
+	for _, proxy := range list {
+		if err := proxy.Validate(); err != nil {
+			return err
+		}
+	}
+
+	p.s.m.Lock()
+	defer p.s.m.Unlock()
+
+	for _, proxy := range list {
+		if _, exists := p.s.proxies[proxy.ID]; exists {
+			p.s.proxies[proxy.ID] = proxy
+		}
+	}
 	return nil
 }
 
-func (p *ProxyRepository) FindByID(id int64) (*model.Proxy, error) {
-	domain, exists := p.store[int(id)]
+func (p *ProxyRepository) FindByID(_ context.Context, id int64) (*model.Proxy, error) {
+	p.s.m.RLock()
+	defer p.s.m.RUnlock()
+
+	proxy, exists := p.s.proxies[int(id)]
 	if !exists {
-		return nil, fmt.Errorf("record with id=%d not found", id)
+		return nil, gorm.ErrRecordNotFound
 	}
-	return &domain, nil
+	return &proxy, nil
 }
@@ -0,0 +1,364 @@
+package memstore
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"restapi_langparser/internal/model"
+	"time"
+)
+
+// queueItem is the queue row in the heap of its lane, or in the heap of the
+// leases while it is leased
+type queueItem struct {
+	*model.Queue
+	heap  *queueHeap
+	index int
+}
+
+// queueHeap orders the rows of the lane by update_at and id as the lane query
+// of sqlstore does, the heap of the leases orders them by the lease end
+type queueHeap struct {
+	items   []*queueItem
+	byLease bool
+}
+
+func (h *queueHeap) Len() int { return len(h.items) }
+
+func (h *queueHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	if h.byLease && !a.LeaseUntil.Equal(*b.LeaseUntil) {
+		return a.LeaseUntil.Before(*b.LeaseUntil)
+	}
+	if !a.UpdateAt.Equal(b.UpdateAt) {
+		return a.UpdateAt.Before(b.UpdateAt)
+	}
+	return a.ID < b.ID
+}
+
+func (h *queueHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.items[i].index = i
+	h.items[j].index = j
+}
+
+func (h *queueHeap) Push(x interface{}) {
+	q := x.(*queueItem)
+	q.index = len(h.items)
+	h.items = append(h.items, q)
+}
+
+func (h *queueHeap) Pop() interface{} {
+	n := len(h.items) - 1
+	q := h.items[n]
+	h.items[n] = nil
+	h.items = h.items[:n]
+	return q
+}
+
+func newLanes() map[model.QueueLane]*queueHeap {
+	return map[model.QueueLane]*queueHeap{
+		model.LaneUserRequest: {},
+		model.LaneQueue:       {},
+		model.LaneBadDomain:   {},
+		model.LaneRefresh:     {},
+	}
+}
+
+func (s *Store) enqueue(domainID uint, updateAt time.Time, refresh bool) {
+	s.lastQueueID++
+	now := time.Now()
+	q := &queueItem{Queue: &model.Queue{
+		DomainID: domainID,
+		UpdateAt: updateAt,
+		Refresh:  refresh,
+	}}
+	q.ID = s.lastQueueID
+	q.CreatedAt = now
+	q.UpdatedAt = now
+	s.queue[domainID] = q
+	s.relane(q)
+}
+
+// dequeue removes the queue row of the domain
+func (s *Store) dequeue(domainID uint) {
+	q, exists := s.queue[domainID]
+	if !exists {
+		return
+	}
+	if q.heap != nil {
+		heap.Remove(q.heap, q.index)
+	}
+	delete(s.queue, domainID)
+}
+
+// relane moves the queue row to the heap of its lane after the row, its
+// domain or the requests of the domain are changed
+func (s *Store) relane(q *queueItem) {
+	target := s.heapOf(q, time.Now())
+	if q.heap == target {
+		if target != nil {
+			heap.Fix(target, q.index)
+		}
+		return
+	}
+	if q.heap != nil {
+		heap.Remove(q.heap, q.index)
+	}
+	if q.heap = target; target != nil {
+		heap.Push(target, q)
+	}
+}
+
+// relaneDomain is relane of the queue row of the domain, if it is queued
+func (s *Store) relaneDomain(domainID uint) {
+	if q, queued := s.queue[domainID]; queued {
+		s.relane(q)
+	}
+}
+
+// heapOf returns the heap of the lane of the row, the update time is checked
+// when the row is leased. It is the same condition as the lane query of sqlstore.
+func (s *Store) heapOf(q *queueItem, now time.Time) *queueHeap {
+	domain, exists := s.domains[q.DomainID]
+	if !exists {
+		return nil
+	}
+	if q.LeaseUntil != nil && !q.LeaseUntil.Before(now) {
+		return s.leased
+	}
+	requested := s.hasRequest(domain.ID)
+
+	switch {
+	case domain.ResponseCode != model.ResponseNull && domain.ResponseCode != model.ResponseOk:
+		return s.lanes[model.LaneBadDomain]
+	case requested && !q.Refresh:
+		return s.lanes[model.LaneUserRequest]
+	case domain.ResponseCode == model.ResponseNull && !requested:
+		return s.lanes[model.LaneQueue]
+	case domain.ResponseCode == model.ResponseOk && q.Refresh:
+		return s.lanes[model.LaneRefresh]
+	}
+	return nil
+}
+
+// expireLeases returns the rows of the expired leases to their lanes
+func (s *Store) expireLeases(now time.Time) {
+	for len(s.leased.items) > 0 && s.leased.items[0].LeaseUntil.Before(now) {
+		s.relane(s.leased.items[0])
+	}
+}
+
+// lane returns the heap of the lane with the expired leases returned to it
+func (s *Store) lane(lane model.QueueLane, now time.Time) (*queueHeap, error) {
+	h, exists := s.lanes[lane]
+	if !exists {
+		return nil, fmt.Errorf("unknown queue lane %q", lane)
+	}
+	s.expireLeases(now)
+	return h, nil
+}
+
+// notifyQueue wakes up a waiting worker, the signal is dropped if one is already pending
+func (s *Store) notifyQueue() {
+	select {
+	case s.queueEvents <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Store) QueueNotify() <-chan struct{} {
+	return s.queueEvents
+}
+
+// due checks that the row of the lane can be crawled, the user requests do
+// not wait for their update time
+func due(lane model.QueueLane, q *queueItem, now time.Time) bool {
+	return lane == model.LaneUserRequest || q.UpdateAt.Before(now)
+}
+
+func (s *Store) LeaseFromQueue(_ context.Context, lane model.QueueLane, workerID string, ttl time.Duration) (*model.Domain, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	now := time.Now()
+	h, err := s.lane(lane, now)
+	if err != nil {
+		return nil, err
+	}
+	// the first row is the least recently updated one of the lane
+	if h.Len() == 0 || !due(lane, h.items[0], now) {
+		return nil, nil
+	}
+
+	q := h.items[0]
+	leaseUntil := now.Add(ttl)
+	q.WorkerID = workerID
+	q.LeaseUntil = &leaseUntil
+	s.relane(q)
+
+	domain, _ := s.readDomain(q.DomainID)
+	domain.Queue = model.Queue{DomainID: domain.ID, WorkerID: workerID, LeaseUntil: &leaseUntil}
+	return &domain, nil
+}
+
+// QueueDepth takes the write lock as the expired leases are returned to the lanes
+func (s *Store) QueueDepth(_ context.Context, lane model.QueueLane) (int64, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	now := time.Now()
+	h, err := s.lane(lane, now)
+	if err != nil {
+		return 0, err
+	}
+	var depth int64
+	for _, q := range h.items {
+		if due(lane, q, now) {
+			depth++
+		}
+	}
+	return depth, nil
+}
+
+func (s *Store) AddToQueue(_ context.Context, updateAt time.Time, list ...model.Domain) error {
+	if len(list) == 0 {
+		return nil
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for _, domain := range list {
+		if _, exists := s.queue[domain.ID]; !exists {
+			s.enqueue(domain.ID, updateAt, false)
+		}
+	}
+	s.notifyQueue()
+	return nil
+}
+
+func (s *Store) ReturnToQueue(_ context.Context, updateAt time.Time, domain model.Domain) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	q, exists := s.queue[domain.ID]
+	if err := checkLease(q, exists, domain); err != nil || !exists {
+		return err
+	}
+	q.UpdateAt = updateAt
+	q.WorkerID = ""
+	q.LeaseUntil = nil
+	q.UpdatedAt = time.Now()
+	s.relane(q)
+	return nil
+}
+
+func (s *Store) RemoveFromQueue(_ context.Context, domain model.Domain) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	q, exists := s.queue[domain.ID]
+	if err := checkLease(q, exists, domain); err != nil {
+		return err
+	}
+	s.dequeue(domain.ID)
+	return nil
+}
+
+// checkLease returns model.ErrLeaseLost when the leased domain is not leased
+// by its lease anymore, the domain which was not leased passes
+func checkLease(q *queueItem, exists bool, domain model.Domain) error {
+	lease := domain.Queue
+	if lease.LeaseUntil == nil {
+		return nil
+	}
+	if !exists || q.WorkerID != lease.WorkerID || q.LeaseUntil == nil || !q.LeaseUntil.Equal(*lease.LeaseUntil) {
+		return model.ErrLeaseLost
+	}
+	return nil
+}
+
+// RefreshDomains queues the domains for the crawl even if they are done. The
+// background crawl waits for the other lanes, otherwise it is the user request.
+func (s *Store) RefreshDomains(_ context.Context, list []model.Domain, background bool) error {
+	if len(list) == 0 {
+		return nil
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for _, domain := range list {
+		if q, exists := s.queue[domain.ID]; exists {
+			// the background refresh does not lower the priority of the queued user request
+			q.UpdateAt = time.Now()
+			q.Refresh = q.Refresh && background
+			s.relane(q)
+			continue
+		}
+		s.enqueue(domain.ID, time.Now(), background)
+	}
+	s.notifyQueue()
+	return nil
+}
+
+// RequeueStale queues at most limit successfully crawled domains which are older than their max age.
+// The max age is taken from the domain itself, then from its group, then the default one.
+func (s *Store) RequeueStale(_ context.Context, maxAge time.Duration, groupMaxAge map[string]time.Duration, limit int) (int64, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	now := time.Now()
+	stale := s.sortedDomains(func(domain model.Domain) bool {
+		if domain.ResponseCode != model.ResponseOk {
+			return false
+		}
+		if _, queued := s.queue[domain.ID]; queued {
+			return false
+		}
+
+		age := maxAge
+		if domain.MaxAge > 0 {
+			age = time.Duration(domain.MaxAge) * time.Second
+		} else if groupAge, ok := groupMaxAge[domain.Group]; ok {
+			age = groupAge
+		}
+		if age <= 0 {
+			return false
+		}
+
+		checkedAt := domain.UpdatedAt
+		if domain.CheckedAt != nil {
+			checkedAt = *domain.CheckedAt
+		}
+		return checkedAt.Before(now.Add(-age))
+	})
+	if limit > 0 && len(stale) > limit {
+		stale = stale[:limit]
+	}
+
+	for _, domain := range stale {
+		s.enqueue(domain.ID, now, true)
+	}
+	if len(stale) > 0 {
+		s.notifyQueue()
+	}
+	return int64(len(stale)), nil
+}
+
+// NextQueueUpdate returns the nearest time when a queued domain becomes due, zero time for the empty queue
+func (s *Store) NextQueueUpdate(_ context.Context) (time.Time, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	var next time.Time
+	for _, q := range s.queue {
+		due := q.UpdateAt
+		if q.LeaseUntil != nil && q.LeaseUntil.After(due) {
+			due = *q.LeaseUntil
+		}
+		if next.IsZero() || due.Before(next) {
+			next = due
+		}
+	}
+	return next, nil
+}
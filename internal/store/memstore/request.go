@@ -0,0 +1,396 @@
+package memstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"restapi_langparser/internal/model"
+	"restapi_langparser/internal/store"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func (s *Store) CreateRequest(_ context.Context, request model.RequestHeader, list []model.Domain) (*model.RequestHeader, error) {
+	code, err := model.NewRequestCode()
+	if err != nil {
+		return nil, err
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	request.Code = code
+	request.CreatedAt = time.Now()
+	ingesting := request.Status == model.RequestIngesting
+	if !ingesting {
+		request.Status = model.RequestDone
+	}
+	request.Hosts = len(list)
+
+	s.requests[code] = make(map[uint]time.Time)
+	for _, domain := range list {
+		if _, queued := s.queue[domain.ID]; queued && !ingesting {
+			request.Status = model.RequestPending
+		}
+		s.requests[code][domain.ID] = request.CreatedAt
+		if s.domainRequests[domain.ID] == nil {
+			s.domainRequests[domain.ID] = make(map[string]struct{})
+		}
+		s.domainRequests[domain.ID][code] = struct{}{}
+		s.relaneDomain(domain.ID)
+	}
+	s.requestHeaders[code] = request
+	return &request, nil
+}
+
+func (s *Store) GetRequestHeader(_ context.Context, code string) (*model.RequestHeader, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	request, exists := s.requestHeaders[code]
+	if !exists {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &request, nil
+}
+
+// requestQueued checks that the domain of the request made at createdAt waits for the crawl
+func (s *Store) requestQueued(domain model.Domain, createdAt time.Time) bool {
+	if _, queued := s.queue[domain.ID]; !queued {
+		return false
+	}
+	return domain.CheckedAt == nil || domain.CheckedAt.Before(createdAt)
+}
+
+func (s *Store) GetRequestProgress(_ context.Context, code string) (*model.RequestProgress, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	if _, exists := s.requestHeaders[code]; !exists {
+		return nil, gorm.ErrRecordNotFound
+	}
+	progress := &model.RequestProgress{}
+	for id, createdAt := range s.requests[code] {
+		domain, exists := s.domains[id]
+		if !exists {
+			continue
+		}
+		progress.Total++
+		switch {
+		case s.requestQueued(domain, createdAt):
+			progress.Queued++
+		case domain.ResponseCode == model.ResponseOk:
+			progress.Done++
+		case domain.CheckedAt == nil || domain.CheckedAt.Before(createdAt):
+			progress.Skipped++ // left the queue without the crawl
+		default:
+			progress.Failed++
+		}
+	}
+	return progress, nil
+}
+
+func (s *Store) GetRequest(_ context.Context, requestCode string, page model.Page) ([]model.Domain, *model.PageInfo, error) {
+	cursor, err := model.DecodeCursor(page.Cursor, model.CursorRequest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	if _, exists := s.requestHeaders[requestCode]; !exists {
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+	links := s.requests[requestCode]
+	list := s.sortedDomains(func(domain model.Domain) bool {
+		createdAt, ok := links[domain.ID]
+		return ok && !s.requestQueued(domain, createdAt)
+	})
+
+	info := &model.PageInfo{Total: int64(len(list))}
+	start := 0
+	if cursor != nil {
+		start = sort.Search(len(list), func(i int) bool {
+			return list[i].ID > cursor.ID
+		})
+	}
+	end, more := pageEnd(start, len(list), page.Limit)
+	list = list[start:end]
+	if more {
+		info.NextCursor = model.Cursor{Sort: model.CursorRequest, ID: list[len(list)-1].ID}.Encode()
+	}
+	return list, info, nil
+}
+
+// requestCompleted checks that no domain of the request waits for the crawl
+func (s *Store) requestCompleted(code string) bool {
+	for id, createdAt := range s.requests[code] {
+		if domain, exists := s.domains[id]; exists && s.requestQueued(domain, createdAt) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Store) GetCompletedRequests(_ context.Context, domain model.Domain) ([]model.RequestHeader, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	requests := make([]model.RequestHeader, 0)
+	for code := range s.domainRequests[domain.ID] {
+		request := s.requestHeaders[code]
+		if request.Status == model.RequestPending && s.requestCompleted(code) {
+			requests = append(requests, request)
+		}
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].Code < requests[j].Code
+	})
+	return requests, nil
+}
+
+func (s *Store) DeleteDomains(_ context.Context, ids []uint, hard bool) ([]model.RequestHeader, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	codes := make(map[string]struct{})
+	for _, id := range ids {
+		for code := range s.domainRequests[id] {
+			codes[code] = struct{}{}
+		}
+		s.deleteDomain(id, hard)
+	}
+	requests := make([]model.RequestHeader, 0)
+	for code := range codes {
+		request := s.requestHeaders[code]
+		if request.Status == model.RequestPending && s.requestCompleted(code) {
+			requests = append(requests, request)
+		}
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].Code < requests[j].Code
+	})
+	return requests, nil
+}
+
+func (s *Store) FinishRequest(_ context.Context, code, status string) (bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	request, exists := s.requestHeaders[code]
+	if !exists || request.Status != model.RequestPending {
+		return false, nil
+	}
+	request.Status = status
+	s.requestHeaders[code] = request
+	return true, nil
+}
+
+// oldRequests returns at most limit codes of the requests made before the time
+// from the oldest one, the expired or the live ones
+func (s *Store) oldRequests(expired bool, before time.Time, limit int) []string {
+	requests := make([]model.RequestHeader, 0)
+	for _, request := range s.requestHeaders {
+		if (request.Status == model.RequestExpired) == expired && request.CreatedAt.Before(before) {
+			requests = append(requests, request)
+		}
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].CreatedAt.Before(requests[j].CreatedAt)
+	})
+	if limit > 0 && len(requests) > limit {
+		requests = requests[:limit]
+	}
+	codes := make([]string, len(requests))
+	for i, request := range requests {
+		codes[i] = request.Code
+	}
+	return codes
+}
+
+func (s *Store) ExpireRequests(_ context.Context, before time.Time, limit int) (int64, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	codes := s.oldRequests(false, before, limit)
+	for _, code := range codes {
+		for id := range s.requests[code] {
+			delete(s.domainRequests[id], code)
+			if len(s.domainRequests[id]) == 0 {
+				delete(s.domainRequests, id)
+			}
+			s.relaneDomain(id)
+		}
+		delete(s.requests, code)
+
+		request := s.requestHeaders[code]
+		request.Status = model.RequestExpired
+		request.Callback = ""
+		s.requestHeaders[code] = request
+	}
+	return int64(len(codes)), nil
+}
+
+func (s *Store) DeleteExpiredRequests(_ context.Context, before time.Time, limit int) (int64, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	codes := s.oldRequests(true, before, limit)
+	for _, code := range codes {
+		delete(s.requestHeaders, code)
+	}
+	return int64(len(codes)), nil
+}
+
+func (s *Store) StopRequest(_ context.Context, code, status string) (int64, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	request, exists := s.requestHeaders[code]
+	if !exists {
+		return 0, gorm.ErrRecordNotFound
+	}
+	if request.Status != model.RequestPending && request.Status != model.RequestIngesting {
+		return 0, model.ErrRequestFinished
+	}
+	request.Status = status
+	s.requestHeaders[code] = request
+
+	now := time.Now()
+	var removed int64
+	for id, createdAt := range s.requests[code] {
+		domain, exists := s.domains[id]
+		if !exists || !s.requestQueued(domain, createdAt) || s.pendingElsewhere(id, code) {
+			continue
+		}
+		// the leased domains are being crawled already
+		if q := s.queue[id]; q.LeaseUntil == nil || q.LeaseUntil.Before(now) {
+			s.dequeue(id)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// pendingElsewhere checks that another pending request needs the domain
+func (s *Store) pendingElsewhere(domainID uint, code string) bool {
+	for other := range s.domainRequests[domainID] {
+		if other != code && s.requestHeaders[other].Status == model.RequestPending {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) GetOverdueRequests(_ context.Context, now time.Time, limit int) ([]model.RequestHeader, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	requests := make([]model.RequestHeader, 0)
+	for _, request := range s.requestHeaders {
+		if request.Status == model.RequestPending && request.Overdue(now) {
+			requests = append(requests, request)
+		}
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].Deadline.Before(*requests[j].Deadline)
+	})
+	if limit > 0 && len(requests) > limit {
+		requests = requests[:limit]
+	}
+	return requests, nil
+}
+
+func (s *Store) IngestRequest(_ context.Context, code string, key *model.APIKey, next func() ([]string, error)) (int64, error) {
+	var added int64
+	for {
+		hosts, err := next()
+		if errors.Is(err, io.EOF) {
+			return added, nil
+		}
+		if err != nil {
+			return added, err
+		}
+		linked, err := s.ingestBatch(code, key, hosts)
+		added += linked
+		if err != nil {
+			return added, err
+		}
+	}
+}
+
+func (s *Store) ingestBatch(code string, key *model.APIKey, hosts []string) (int64, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	request, exists := s.requestHeaders[code]
+	if !exists {
+		return 0, gorm.ErrRecordNotFound
+	}
+	if request.Status != model.RequestIngesting {
+		return 0, model.ErrRequestFinished
+	}
+	now := time.Now()
+	if key != nil && key.DailyHosts > 0 {
+		unique := make(map[string]struct{}, len(hosts))
+		for _, host := range hosts {
+			unique[host] = struct{}{}
+		}
+		if !s.addUsage(key.ID, model.UsageDay(now), len(unique), key.DailyHosts) {
+			return 0, fmt.Errorf("%w: %d hosts are over the quota of %d hosts per day", store.ErrQuotaExceeded, len(unique), key.DailyHosts)
+		}
+	}
+	if err := s.createDomains(model.CreateDomainsList(hosts)...); err != nil {
+		return 0, err
+	}
+
+	var linked int64
+	for _, host := range hosts {
+		id := s.hosts[host]
+		if _, exists := s.requests[code][id]; exists {
+			continue
+		}
+		if s.domains[id].ResponseCode != model.ResponseOk {
+			if q, queued := s.queue[id]; queued {
+				q.UpdateAt = now
+			} else {
+				s.enqueue(id, now, false)
+			}
+		}
+		s.requests[code][id] = now
+		if s.domainRequests[id] == nil {
+			s.domainRequests[id] = make(map[string]struct{})
+		}
+		s.domainRequests[id][code] = struct{}{}
+		s.relaneDomain(id)
+		linked++
+	}
+	request.Hosts += int(linked)
+	s.requestHeaders[code] = request
+	s.notifyQueue()
+	return linked, nil
+}
+
+func (s *Store) FinishIngest(_ context.Context, code string, overBudget int) (string, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	request, exists := s.requestHeaders[code]
+	if !exists {
+		return "", gorm.ErrRecordNotFound
+	}
+	if request.Status != model.RequestIngesting {
+		return "", model.ErrRequestFinished
+	}
+	request.Status = model.RequestDone
+	if !s.requestCompleted(code) {
+		request.Status = model.RequestPending
+	}
+	request.OverBudget = overBudget
+	s.requestHeaders[code] = request
+	return request.Status, nil
+}
@@ -1,19 +1,86 @@
 package memstore
 
 import (
+	"context"
+	"fmt"
+	"restapi_langparser/internal/model"
 	"restapi_langparser/internal/store"
+	"sort"
+	"sync"
+	"time"
 )
 
+// Store keeps everything in memory with the same semantics as sqlstore.
+// All repositories share the lock of the store.
 type Store struct {
+	m sync.RWMutex
+
+	domains      map[uint]model.Domain
+	hosts        map[string]uint
+	lastDomainID uint
+	deleted      map[string]model.Domain // soft deleted domains by host, restored when the host is added again
+
+	proxies     map[int]model.Proxy
+	lastProxyID int
+
+	queue       map[uint]*queueItem // by domain id
+	lanes       map[model.QueueLane]*queueHeap
+	leased      *queueHeap
+	lastQueueID uint
+	queueEvents chan struct{}
+
+	requests       map[string]map[uint]time.Time // code -> domain id -> created at
+	domainRequests map[uint]map[string]struct{}  // domain id -> codes
+	requestHeaders map[string]model.RequestHeader
+
+	history       map[uint][]model.DomainHistory // by domain id
+	lastHistoryID uint
+
+	watchlists       map[uint]model.Watchlist
+	watchlistDomains map[uint]map[uint]struct{} // watchlist id -> domain ids
+	lastWatchlistID  uint
+
+	workers map[string]model.Worker
+
+	apiKeys      map[uint]model.APIKey
+	keyUsage     map[uint]map[string]int // key id -> day -> hosts
+	lastAPIKeyID uint
+
 	ProxyRepository  store.IProxyRepository
 	DomainRepository store.IDomainRepository
+	WatchlistRepo    store.IWatchlistRepository
+	APIKeyRepo       store.IAPIKeyRepository
 }
 
-func New() *Store {
-	return &Store{
-		DomainRepository: NewDomainRepository(),
-		ProxyRepository:  NewProxyRepository(),
+func New() store.IStore {
+	s := &Store{
+		domains:          make(map[uint]model.Domain),
+		hosts:            make(map[string]uint),
+		deleted:          make(map[string]model.Domain),
+		proxies:          make(map[int]model.Proxy),
+		queue:            make(map[uint]*queueItem),
+		lanes:            newLanes(),
+		leased:           &queueHeap{byLease: true},
+		queueEvents:      make(chan struct{}, 1),
+		requests:         make(map[string]map[uint]time.Time),
+		domainRequests:   make(map[uint]map[string]struct{}),
+		requestHeaders:   make(map[string]model.RequestHeader),
+		history:          make(map[uint][]model.DomainHistory),
+		watchlists:       make(map[uint]model.Watchlist),
+		watchlistDomains: make(map[uint]map[uint]struct{}),
+		workers:          make(map[string]model.Worker),
+		apiKeys:          make(map[uint]model.APIKey),
+		keyUsage:         make(map[uint]map[string]int),
 	}
+	s.DomainRepository = NewDomainRepository(s)
+	s.ProxyRepository = NewProxyRepository(s)
+	s.WatchlistRepo = NewWatchlistRepository(s)
+	s.APIKeyRepo = NewAPIKeyRepository(s)
+	return s
+}
+
+func (s *Store) Migrate() error {
+	return nil
 }
 
 func (s *Store) Proxy() store.IProxyRepository {
@@ -24,6 +91,198 @@ func (s *Store) Domain() store.IDomainRepository {
 	return s.DomainRepository
 }
 
-func (s *Store) Migrate() error {
+func (s *Store) Watchlist() store.IWatchlistRepository {
+	return s.WatchlistRepo
+}
+
+func (s *Store) APIKey() store.IAPIKeyRepository {
+	return s.APIKeyRepo
+}
+
+// createDomains adds the domains with new hosts, the existing ones are skipped
+func (s *Store) createDomains(domains ...model.Domain) error {
+	for _, domain := range domains {
+		if err := domain.BeforeCreate(nil); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	for _, domain := range domains {
+		if _, exists := s.hosts[domain.Host]; exists {
+			continue
+		}
+		if restored, exists := s.deleted[domain.Host]; exists {
+			delete(s.deleted, domain.Host)
+			restored.UpdatedAt = now
+			s.domains[restored.ID] = restored
+			s.hosts[restored.Host] = restored.ID
+			continue
+		}
+		domain.TLD = model.TLDOf(domain.Host)
+		if domain.ID == 0 {
+			s.lastDomainID++
+			domain.ID = s.lastDomainID
+		} else if domain.ID > s.lastDomainID {
+			s.lastDomainID = domain.ID
+		}
+		domain.CreatedAt = now
+		domain.UpdatedAt = now
+		s.domains[domain.ID] = domain
+		s.hosts[domain.Host] = domain.ID
+	}
+	return nil
+}
+
+// saveDomain updates all fields of the domain, the unknown domain is created
+func (s *Store) saveDomain(target model.Domain) error {
+	if err := target.BeforeUpdate(nil); err != nil {
+		return err
+	}
+
+	current, exists := s.domains[target.ID]
+	if !exists {
+		if err := s.createDomains(target); err != nil {
+			return err
+		}
+		target = s.domains[s.hosts[target.Host]]
+		target.BuildLanguages()
+		s.domains[target.ID] = target
+		return nil
+	}
+	if target.Host != current.Host {
+		if id, used := s.hosts[target.Host]; used && id != target.ID {
+			return fmt.Errorf("host %s %w", target.Host, store.ErrConflict)
+		}
+		delete(s.hosts, current.Host)
+		s.hosts[target.Host] = target.ID
+	}
+	target.CreatedAt = current.CreatedAt
+	target.UpdatedAt = time.Now()
+	target.BuildLanguages()
+	s.domains[target.ID] = target
+	s.relaneDomain(target.ID)
+	return nil
+}
+
+// readDomain returns a copy of the stored domain as it is read from the database
+func (s *Store) readDomain(id uint) (model.Domain, bool) {
+	domain, exists := s.domains[id]
+	if !exists {
+		return domain, false
+	}
+	domain.Languages = append([]model.DomainLanguage(nil), domain.Languages...)
+	_ = domain.AfterFind(nil)
+	return domain, true
+}
+
+// deleteDomain removes the domain with its queue, request, history and
+// watchlist links, the soft deleted domain is kept aside until its host is
+// added again. The host counts of its requests are decreased.
+func (s *Store) deleteDomain(id uint, hard bool) {
+	domain, exists := s.domains[id]
+	if !exists {
+		return
+	}
+	delete(s.hosts, domain.Host)
+	delete(s.domains, id)
+	if !hard {
+		s.deleted[domain.Host] = domain
+	}
+	s.dequeue(id)
+	delete(s.history, id)
+	for code := range s.domainRequests[id] {
+		delete(s.requests[code], id)
+		if request, exists := s.requestHeaders[code]; exists {
+			request.Hosts--
+			s.requestHeaders[code] = request
+		}
+	}
+	delete(s.domainRequests, id)
+	for _, ids := range s.watchlistDomains {
+		delete(ids, id)
+	}
+}
+
+func (s *Store) sortedDomains(filter func(domain model.Domain) bool) []model.Domain {
+	res := make([]model.Domain, 0)
+	for id := range s.domains {
+		domain, _ := s.readDomain(id)
+		if filter == nil || filter(domain) {
+			res = append(res, domain)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+	return res
+}
+
+func (s *Store) hasRequest(domainID uint) bool {
+	return len(s.domainRequests[domainID]) > 0
+}
+
+// AddDomains create new domains and add it to queue
+func (s *Store) AddDomains(_ context.Context, list *[]model.Domain) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if err := s.createDomains(*list...); err != nil {
+		return err
+	}
+
+	hosts := make(map[string]struct{}, len(*list))
+	for _, domain := range *list {
+		hosts[domain.Host] = struct{}{}
+	}
+	*list = s.sortedDomains(func(domain model.Domain) bool {
+		_, ok := hosts[domain.Host]
+		return ok
+	})
+
+	// add to queue
+	queued := false
+	for _, domain := range *list { // exclude processed domains
+		if domain.ResponseCode == model.ResponseOk {
+			continue
+		}
+		if q, exists := s.queue[domain.ID]; exists {
+			q.UpdateAt = time.Now()
+			s.relane(q)
+		} else {
+			s.enqueue(domain.ID, time.Now(), false)
+		}
+		queued = true
+	}
+	if queued {
+		s.notifyQueue()
+	}
 	return nil
 }
+
+func (s *Store) GetDomains(_ context.Context, hosts []string) ([]model.Domain, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	domains := make([]model.Domain, 0)
+	for _, host := range hosts {
+		id, exists := s.hosts[host]
+		if !exists {
+			continue
+		}
+		if _, queued := s.queue[id]; queued {
+			continue
+		}
+		domain, _ := s.readDomain(id)
+		domains = append(domains, domain)
+	}
+	if len(domains) != len(hosts) {
+		return nil, nil
+	}
+	return domains, nil
+}
+
+func (s *Store) SaveDomain(_ context.Context, domain model.Domain) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.saveDomain(domain)
+}
@@ -0,0 +1,14 @@
+package memstore_test
+
+import (
+	"restapi_langparser/internal/store"
+	"restapi_langparser/internal/store/memstore"
+	"restapi_langparser/internal/store/storetest"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.IStore {
+		return memstore.New()
+	})
+}
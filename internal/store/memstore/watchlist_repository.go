@@ -0,0 +1,143 @@
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"restapi_langparser/internal/model"
+	"restapi_langparser/internal/store"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type WatchlistRepository struct {
+	s *Store
+}
+
+func NewWatchlistRepository(s *Store) *WatchlistRepository {
+	return &WatchlistRepository{
+		s: s,
+	}
+}
+
+func (w *WatchlistRepository) Create(_ context.Context, watchlist *model.Watchlist) error {
+	if err := watchlist.BeforeCreate(nil); err != nil {
+		return err
+	}
+
+	w.s.m.Lock()
+	defer w.s.m.Unlock()
+
+	for _, current := range w.s.watchlists {
+		if current.Name == watchlist.Name {
+			return fmt.Errorf("watchlist %s %w", watchlist.Name, store.ErrConflict)
+		}
+	}
+	if err := w.s.createDomains(watchlist.Domains...); err != nil {
+		return err
+	}
+
+	w.s.lastWatchlistID++
+	watchlist.ID = w.s.lastWatchlistID
+	watchlist.CreatedAt = time.Now()
+	w.s.watchlistDomains[watchlist.ID] = make(map[uint]struct{})
+	w.s.linkDomains(watchlist.ID, watchlist.Domains)
+
+	stored := *watchlist
+	stored.Domains = nil
+	w.s.watchlists[watchlist.ID] = stored
+	return nil
+}
+
+// linkDomains adds the domains to the watchlist, the domains are found by host when id is unknown
+func (s *Store) linkDomains(id uint, domains []model.Domain) {
+	for _, domain := range domains {
+		if domain.ID == 0 {
+			domain.ID = s.hosts[domain.Host]
+		}
+		if _, exists := s.domains[domain.ID]; exists {
+			s.watchlistDomains[id][domain.ID] = struct{}{}
+		}
+	}
+}
+
+func (w *WatchlistRepository) Read(_ context.Context) ([]model.Watchlist, error) {
+	w.s.m.RLock()
+	defer w.s.m.RUnlock()
+
+	lists := make([]model.Watchlist, 0, len(w.s.watchlists))
+	for _, watchlist := range w.s.watchlists {
+		lists = append(lists, watchlist)
+	}
+	sort.Slice(lists, func(i, j int) bool {
+		return lists[i].ID < lists[j].ID
+	})
+	return lists, nil
+}
+
+func (w *WatchlistRepository) Delete(_ context.Context, id uint) error {
+	w.s.m.Lock()
+	defer w.s.m.Unlock()
+
+	delete(w.s.watchlists, id)
+	delete(w.s.watchlistDomains, id)
+	return nil
+}
+
+func (w *WatchlistRepository) FindByID(_ context.Context, id uint) (*model.Watchlist, error) {
+	w.s.m.RLock()
+	defer w.s.m.RUnlock()
+
+	watchlist, exists := w.s.watchlists[id]
+	if !exists {
+		return nil, gorm.ErrRecordNotFound
+	}
+	ids := w.s.watchlistDomains[id]
+	watchlist.Domains = w.s.sortedDomains(func(domain model.Domain) bool {
+		_, ok := ids[domain.ID]
+		return ok
+	})
+	return &watchlist, nil
+}
+
+func (w *WatchlistRepository) FindByDomain(_ context.Context, domainID uint) ([]model.Watchlist, error) {
+	w.s.m.RLock()
+	defer w.s.m.RUnlock()
+
+	lists := make([]model.Watchlist, 0)
+	for id, ids := range w.s.watchlistDomains {
+		if _, ok := ids[domainID]; ok {
+			lists = append(lists, w.s.watchlists[id])
+		}
+	}
+	sort.Slice(lists, func(i, j int) bool {
+		return lists[i].ID < lists[j].ID
+	})
+	return lists, nil
+}
+
+func (w *WatchlistRepository) AddDomains(_ context.Context, id uint, domains []model.Domain) error {
+	w.s.m.Lock()
+	defer w.s.m.Unlock()
+
+	if _, exists := w.s.watchlists[id]; !exists {
+		return gorm.ErrRecordNotFound
+	}
+	if err := w.s.createDomains(domains...); err != nil {
+		return err
+	}
+	w.s.linkDomains(id, domains)
+	return nil
+}
+
+func (w *WatchlistRepository) SetLastRun(_ context.Context, id uint, at time.Time) error {
+	w.s.m.Lock()
+	defer w.s.m.Unlock()
+
+	if watchlist, exists := w.s.watchlists[id]; exists {
+		watchlist.LastRunAt = &at
+		w.s.watchlists[id] = watchlist
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package memstore
+
+import (
+	"context"
+	"restapi_langparser/internal/model"
+	"sort"
+	"time"
+)
+
+func (s *Store) Heartbeat(_ context.Context, worker model.Worker) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if current, exists := s.workers[worker.ID]; exists {
+		worker.StartedAt = current.StartedAt
+	}
+	s.workers[worker.ID] = worker
+	return nil
+}
+
+func (s *Store) GetWorkers(_ context.Context, aliveSince time.Time) ([]model.Worker, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	workers := make([]model.Worker, 0)
+	for _, worker := range s.workers {
+		if worker.HeartbeatAt.After(aliveSince) {
+			workers = append(workers, worker)
+		}
+	}
+	sort.Slice(workers, func(i, j int) bool {
+		return workers[i].ID < workers[j].ID
+	})
+	return workers, nil
+}
+
+func (s *Store) RemoveWorker(_ context.Context, id string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	delete(s.workers, id)
+	return nil
+}
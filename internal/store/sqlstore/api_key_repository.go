@@ -0,0 +1,76 @@
+package sqlstore
+
+import (
+	"context"
+	"restapi_langparser/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type APIKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyRepository(db *gorm.DB) *APIKeyRepository {
+	return &APIKeyRepository{
+		db: db,
+	}
+}
+
+func (k *APIKeyRepository) Create(ctx context.Context, key *model.APIKey) error {
+	if err := key.Validate(); err != nil {
+		return err
+	}
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+	return conflict(k.db.WithContext(ctx).Create(key).Error)
+}
+
+func (k *APIKeyRepository) Read(ctx context.Context) ([]model.APIKey, error) {
+	keys := make([]model.APIKey, 0)
+	err := k.db.WithContext(ctx).Order("id").Find(&keys).Error
+	return keys, err
+}
+
+func (k *APIKeyRepository) FindByHash(ctx context.Context, hash string) (*model.APIKey, error) {
+	key := &model.APIKey{}
+	err := k.db.WithContext(ctx).Where("hash=? and revoked_at is null", hash).Take(key).Error
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (k *APIKeyRepository) Revoke(ctx context.Context, id uint, at time.Time) error {
+	return k.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Take(&model.APIKey{}, id).Error; err != nil {
+			return err
+		}
+		return tx.Model(&model.APIKey{}).Where("id=? and revoked_at is null", id).Update("revoked_at", at).Error
+	})
+}
+
+func (k *APIKeyRepository) AddUsage(ctx context.Context, id uint, day string, hosts, limit int) (bool, error) {
+	return addUsage(k.db.WithContext(ctx), id, day, hosts, limit)
+}
+
+// addUsage adds the hosts to the usage of the key, false if they are over the limit
+func addUsage(db *gorm.DB, id uint, day string, hosts, limit int) (bool, error) {
+	if limit > 0 && hosts > limit {
+		return false, nil
+	}
+	// the check and the increment are one statement, so the parallel submissions do not overrun the limit
+	res := db.Exec(`insert into api_key_usage (key_id, day, hosts) values (?, ?, ?)
+		on conflict (key_id, day) do update set hosts=api_key_usage.hosts+excluded.hosts
+		where ?=0 or api_key_usage.hosts+excluded.hosts<=?`, id, day, hosts, limit, limit)
+	return res.RowsAffected > 0, res.Error
+}
+
+func (k *APIKeyRepository) Usage(ctx context.Context, id uint, day string) (int, error) {
+	var hosts int
+	err := k.db.WithContext(ctx).Raw("select coalesce(sum(hosts), 0) from api_key_usage where key_id=? and day=?", id, day).
+		Scan(&hosts).Error
+	return hosts, err
+}
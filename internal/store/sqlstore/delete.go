@@ -0,0 +1,109 @@
+package sqlstore
+
+import (
+	"context"
+	"restapi_langparser/internal/model"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// deleteBatch is the number of the domain ids in one statement of the deletion
+const deleteBatch = 500
+
+// requestCompleted is the condition of the requests without queued domains
+func requestCompleted(dialect dialect) string {
+	return `not exists (select 1 from request_domains r
+		join domains d on d.id=r.domain_id and d.deleted_at is null
+		left join queues q on q.domain_id=r.domain_id and q.deleted_at is null
+		where r.code=requests.code and ` + requestQueued(dialect, "d") + `)`
+}
+
+// deleteDomains removes the queue, language, history, request and watchlist
+// rows of the domains and the domains, the soft deletion only marks the
+// domains and keeps their languages. The host counts of the requests are
+// decreased. It returns the codes of the requests which had the domains.
+func deleteDomains(tx *gorm.DB, ids []uint, hard bool) ([]string, error) {
+	seen := make(map[string]struct{})
+	codes := make([]string, 0)
+	for start := 0; start < len(ids); start += deleteBatch {
+		end := start + deleteBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batch := ids[start:end]
+
+		var batchCodes []string
+		if err := tx.Model(&model.Request{}).Distinct("code").Where("domain_id in ?", batch).Pluck("code", &batchCodes).Error; err != nil {
+			return nil, err
+		}
+		for _, code := range batchCodes {
+			if _, ok := seen[code]; !ok {
+				seen[code] = struct{}{}
+				codes = append(codes, code)
+			}
+		}
+		err := tx.Exec(`update requests set hosts=hosts-(select count(*) from request_domains r
+				where r.code=requests.code and r.domain_id in @ids)
+			where code in (select code from request_domains where domain_id in @ids)`,
+			map[string]interface{}{"ids": batch}).Error
+		if err != nil {
+			return nil, err
+		}
+
+		tables := []string{"queues", "request_domains", "domain_histories", "watchlist_domains"}
+		if hard {
+			tables = append(tables, "domain_languages")
+		}
+		for _, table := range tables {
+			if err = tx.Exec("delete from "+table+" where domain_id in ?", batch).Error; err != nil {
+				return nil, err
+			}
+		}
+		if hard {
+			err = tx.Exec("delete from domains where id in ?", batch).Error
+		} else {
+			err = tx.Exec("update domains set deleted_at=? where id in ? and deleted_at is null", time.Now(), batch).Error
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+func (s *Store) DeleteDomains(ctx context.Context, ids []uint, hard bool) ([]model.RequestHeader, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	requests := make([]model.RequestHeader, 0)
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		codes, err := deleteDomains(tx, ids, hard)
+		if err != nil || len(codes) == 0 {
+			return err
+		}
+		for start := 0; start < len(codes); start += deleteBatch {
+			end := start + deleteBatch
+			if end > len(codes) {
+				end = len(codes)
+			}
+			var completed []model.RequestHeader
+			err = tx.Where("status=? and code in ?", model.RequestPending, codes[start:end]).
+				Where(requestCompleted(s.dialect)).
+				Find(&completed).Error
+			if err != nil {
+				return err
+			}
+			requests = append(requests, completed...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].Code < requests[j].Code
+	})
+	return requests, nil
+}
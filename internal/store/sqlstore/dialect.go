@@ -0,0 +1,123 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"restapi_langparser/internal/model"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"gorm.io/gorm"
+)
+
+// dialect covers the differences of the raw SQL between Postgres and SQLite.
+// The current time is always passed from the application, so the queries do
+// not depend on the database clock.
+type dialect struct {
+	name string
+	// timestampType is the column type of the timestamps
+	timestampType string
+	// lockRow is appended to the select of the leased queue row
+	lockRow string
+	// time makes the timestamp expression comparable
+	time func(expr string) string
+	// olderThan checks that the timestamp is older than the age in seconds before now
+	olderThan func(expr, now, seconds string) string
+	// greatest returns the later of two timestamps
+	greatest func(a, b string) string
+	// loadHosts fills the ingest_hosts temporary table of the connection with the host and tld rows
+	loadHosts func(ctx context.Context, conn *sql.Conn, rows [][]interface{}) error
+}
+
+var postgresDialect = dialect{
+	name:          "postgres",
+	timestampType: "timestamptz",
+	lockRow:       " for update of q skip locked",
+	time: func(expr string) string {
+		return expr
+	},
+	olderThan: func(expr, now, seconds string) string {
+		return fmt.Sprintf("%s < %s - %s * interval '1 second'", expr, now, seconds)
+	},
+	greatest: func(a, b string) string {
+		return fmt.Sprintf("greatest(%s, %s)", a, b)
+	},
+	loadHosts: copyHosts,
+}
+
+// SQLite keeps timestamps as text with the time zone, julianday makes them
+// comparable. Writes are serialized by the database, so the lease does not
+// lock rows.
+var sqliteDialect = dialect{
+	name:          "sqlite",
+	timestampType: "datetime",
+	lockRow:       "",
+	time: func(expr string) string {
+		return fmt.Sprintf("julianday(%s)", expr)
+	},
+	olderThan: func(expr, now, seconds string) string {
+		return fmt.Sprintf("julianday(%s) < julianday(%s) - %s / 86400.0", expr, now, seconds)
+	},
+	greatest: func(a, b string) string {
+		return fmt.Sprintf("max(julianday(%s), julianday(%s))", a, b)
+	},
+	loadHosts: insertHosts,
+}
+
+// copyHosts loads the rows with COPY of the pgx connection under database/sql
+func copyHosts(ctx context.Context, conn *sql.Conn, rows [][]interface{}) error {
+	return conn.Raw(func(driverConn interface{}) error {
+		pgxConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("copy needs the pgx connection, got %T", driverConn)
+		}
+		_, err := pgxConn.Conn().CopyFrom(ctx, pgx.Identifier{"ingest_hosts"}, []string{"host", "tld"}, pgx.CopyFromRows(rows))
+		return err
+	})
+}
+
+// insertHosts loads the rows with multi-row inserts, the chunks keep under the parameter limit
+func insertHosts(ctx context.Context, conn *sql.Conn, rows [][]interface{}) error {
+	const chunk = 400
+	for start := 0; start < len(rows); start += chunk {
+		end := start + chunk
+		if end > len(rows) {
+			end = len(rows)
+		}
+		values := make([]string, 0, end-start)
+		args := make([]interface{}, 0, (end-start)*2)
+		for _, row := range rows[start:end] {
+			values = append(values, "(?, ?)")
+			args = append(args, row...)
+		}
+		query := "insert into ingest_hosts (host, tld) values " + strings.Join(values, ", ")
+		if _, err := conn.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dialectOf(db *gorm.DB) dialect {
+	if db.Dialector.Name() == sqliteDialect.name {
+		return sqliteDialect
+	}
+	return postgresDialect
+}
+
+// laneQuery returns the from and where part of the lane query, @now is the current time
+func (d dialect) laneQuery(lane model.QueueLane) (string, error) {
+	condition, ok := lanes[lane]
+	if !ok {
+		return "", fmt.Errorf("unknown queue lane %q", lane)
+	}
+	due := d.time("q.update_at") + "<" + d.time("@now")
+	return `from domains d
+		join queues q on d.id=q.domain_id
+		where d.deleted_at is null
+		and q.deleted_at is null
+		and (q.lease_until is null or ` + d.time("q.lease_until") + "<" + d.time("@now") + `)
+		and ` + strings.ReplaceAll(condition, "{due}", due), nil
+}
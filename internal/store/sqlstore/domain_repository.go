@@ -1,6 +1,7 @@
 package sqlstore
 
 import (
+	"context"
 	"restapi_langparser/internal/model"
 	"strings"
 
@@ -18,58 +19,56 @@ func NewDomainRepository(db *gorm.DB) *DomainRepository {
 	}
 }
 
-func (d *DomainRepository) Create(domains ...model.Domain) error {
-	return d.db.Clauses(clause.OnConflict{
-		Columns:   []clause.Column{{Name: "host"}},
-		DoNothing: true,
-	}).Create(domains).Error
+func (d *DomainRepository) Create(ctx context.Context, domains ...model.Domain) error {
+	return conflict(d.db.WithContext(ctx).Clauses(restoreDeleted).Create(domains).Error)
 }
 
-func (d *DomainRepository) Update(target model.Domain) error {
-	tx := d.db.Omit("id")
+// Update saves the domain and replaces its language rows
+func (d *DomainRepository) Update(ctx context.Context, target model.Domain) error {
+	omit := []string{"id", clause.Associations}
 
 	switch {
 	case target.ID == 0 && target.Host != "":
 		var id int64
-		d.db.Table("domains").Select("id").Where("host=?", target.Host).Scan(&id)
+		d.db.WithContext(ctx).Table("domains").Select("id").Where("host=?", target.Host).Scan(&id)
 		target.ID = uint(id)
 	case target.ID > 0 && target.Host == "":
-		tx = tx.Omit("host")
+		omit = append(omit, "host", "tld")
 	}
 
-	return tx.Save(&target).Error
+	return d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(omit...).Save(&target).Error; err != nil {
+			return conflict(err)
+		}
+		target.BuildLanguages()
+		if err := tx.Where("domain_id=?", target.ID).Delete(&model.DomainLanguage{}).Error; err != nil {
+			return err
+		}
+		if len(target.Languages) == 0 {
+			return nil
+		}
+		return tx.Create(&target.Languages).Error
+	})
 }
 
-func (d *DomainRepository) Read(limit, offset int) ([]model.Domain, error) {
-	domains := make([]model.Domain, 0)
-	tx := d.db
-	err := tx.Model(&model.Domain{}).
-		Preload(clause.Associations).
-		Limit(limit).
-		Offset(offset).
-		Order("id").
-		Find(&domains).
-		Error
-	if err != nil {
-		return nil, err
-	}
-
-	return domains, nil
+func (d *DomainRepository) Read(ctx context.Context, page model.Page) ([]model.Domain, *model.PageInfo, error) {
+	return d.Search(ctx, model.DomainFilter{}, page)
 }
 
-func (d *DomainRepository) Delete(target ...model.Domain) error {
-	err := d.db.Delete(target).Error
-	if err != nil {
-		return err
+func (d *DomainRepository) Delete(ctx context.Context, target ...model.Domain) error {
+	ids := make([]uint, len(target))
+	for i, domain := range target {
+		ids[i] = domain.ID
 	}
-	d.db.Model(&model.Request{}).Select("domain_id").Joins("left join domains as d on d.id=r.domain_id where d.id isnull").Find(&model.Domain{})
-	err = d.db.Exec(`delete from requests where domain_id in (select domain_id from requests as r left join domains as d on d.id=r.domain_id where d.id isnull)`).Error
-	return err
+	return d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		_, err := deleteDomains(tx, ids, true)
+		return err
+	})
 }
 
-func (d *DomainRepository) FindByID(id int) (*model.Domain, error) {
+func (d *DomainRepository) FindByID(ctx context.Context, id int) (*model.Domain, error) {
 	domain := &model.Domain{}
-	tx := d.db
+	tx := d.db.WithContext(ctx)
 	err := tx.Model(&model.Domain{}).
 		Preload(clause.Associations).
 		First(domain, id).
@@ -81,67 +80,220 @@ func (d *DomainRepository) FindByID(id int) (*model.Domain, error) {
 	return domain, nil
 }
 
-func (d *DomainRepository) getDomain(query string) (*model.Domain, error) {
-	var domain *model.Domain
-	tx := d.db
-
-	err := tx.Where(query).
-		First(domain).
-		Error
-	if err != nil {
-		return nil, err
-	}
-
-	return domain, tx.Save(domain).Error
+func (d *DomainRepository) FindByTagLang(ctx context.Context, lang string) ([]model.Domain, error) {
+	return d.findByLang(ctx, model.LangSourceTags, lang)
 }
 
-func (d *DomainRepository) GetUserRequest() (*model.Domain, error) {
-	return d.getDomain("update < now() and from = 'user'")
+func (d *DomainRepository) FindBySMLang(ctx context.Context, lang string) ([]model.Domain, error) {
+	return d.findByLang(ctx, model.LangSourceSitemap, lang)
 }
 
-func (d *DomainRepository) GetErrorHost() (*model.Domain, error) {
-	return d.getDomain("update < now() and from = 'list' and response_code != 'ok' and response_code != ''")
+func (d *DomainRepository) FindByContentLang(ctx context.Context, lang string) ([]model.Domain, error) {
+	return d.findByLang(ctx, model.LangSourceContent, lang)
 }
 
-func (d *DomainRepository) GetListHost() (*model.Domain, error) {
-	return d.getDomain("update < now() and from = 'list' and response_code = ''")
-}
-
-func (d *DomainRepository) FindByTagLang(lang string) ([]model.Domain, error) {
+// findByLang returns the domains with exactly the language from the source
+func (d *DomainRepository) findByLang(ctx context.Context, source, lang string) ([]model.Domain, error) {
 	domains := make([]model.Domain, 0)
-	tx := d.db
-	err := tx.Model(&model.Domain{}).Where(`"tags_languages" like ?`, "%"+lang+"%").Find(&domains).Error
+	err := d.db.WithContext(ctx).
+		Preload("Languages").
+		Where("id in (select domain_id from domain_languages where lang=? and source=?)", strings.ToUpper(lang), source).
+		Order("id").
+		Find(&domains).
+		Error
 	if err != nil {
 		return nil, err
 	}
 	return domains, nil
 }
 
-func (d *DomainRepository) FindBySMLang(lang string) ([]model.Domain, error) {
+// Search returns the page of the domains matching all filters in the order of the sort key
+func (d *DomainRepository) Search(ctx context.Context, filter model.DomainFilter, page model.Page) ([]model.Domain, *model.PageInfo, error) {
+	column, desc, err := filter.SortColumn()
+	if err != nil {
+		return nil, nil, err
+	}
+	cursor, err := model.DecodeCursor(page.Cursor, filter.Sort)
+	if err != nil {
+		return nil, nil, err
+	}
+	dialect := dialectOf(d.db)
+
+	info := &model.PageInfo{}
+	if err = d.filter(ctx, dialect, filter).Count(&info.Total).Error; err != nil {
+		return nil, nil, err
+	}
+
+	tx := d.filter(ctx, dialect, filter).Preload("Languages")
+	if cursor != nil {
+		tx = tx.Where(domainKeyset(dialect, column, desc, *cursor))
+	}
+
+	// domains without the value are the last in both directions
+	order, direction := column, ""
+	if desc {
+		direction = " desc"
+	}
+	if column == "checked_at" {
+		order = "checked_at is null, " + dialect.time(column)
+	}
+	if column != "id" {
+		order += direction + ", id"
+	}
+	if page.Limit > 0 {
+		tx = tx.Limit(page.Limit + 1)
+	}
+
 	domains := make([]model.Domain, 0)
-	tx := d.db
-	err := tx.Model(&model.Domain{}).Where(`"sitemap_languages" like ?`, "%"+lang+"%").Find(&domains).Error
+	if err = tx.Order(order + direction).Find(&domains).Error; err != nil {
+		return nil, nil, err
+	}
+	if page.Limit > 0 && len(domains) > page.Limit {
+		domains = domains[:page.Limit]
+		info.NextCursor = model.DomainCursor(domains[page.Limit-1], filter.Sort).Encode()
+	}
+	return domains, info, nil
+}
+
+// exportColumns are read by Export, each domain is repeated for its languages
+const exportColumns = `domains.id, domains.created_at, domains.updated_at, host,
+	coalesce(tld, ''), coalesce(response_code, ''), coalesce(error_count, 0), coalesce(error_class, ''),
+	coalesce(content_lang, ''), coalesce(blocker_name, ''), coalesce(ip, ''), checked_at,
+	coalesce(max_age, 0), coalesce(refresh_group, ''), l.source, l.lang`
+
+// Export calls fn for each domain matching the filter in the order of id. The
+// domains are joined with their languages and read from one cursor, the rows
+// of the domain are collected before fn is called.
+func (d *DomainRepository) Export(ctx context.Context, filter model.DomainFilter, requestCode string, fn func(model.Domain) error) error {
+	dialect := dialectOf(d.db)
+	tx := d.filter(ctx, dialect, filter)
+	if requestCode != "" {
+		if err := d.db.WithContext(ctx).Take(&model.RequestHeader{}, "code=?", requestCode).Error; err != nil {
+			return err
+		}
+		tx = tx.Where(`domains.id in (select r.domain_id from request_domains r
+			left join queues q on q.domain_id=r.domain_id and q.deleted_at is null
+			where r.code=? and not (`+requestQueued(dialect, "domains")+`))`, requestCode)
+	}
+
+	rows, err := tx.Select(exportColumns).
+		Joins("left join domain_languages l on l.domain_id=domains.id").
+		Order("domains.id, l.source, l.lang").
+		Rows()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return domains, nil
+	defer rows.Close()
+
+	var domain *model.Domain
+	emit := func() error {
+		if domain == nil {
+			return nil
+		}
+		_ = domain.AfterFind(nil) // fills the language fields from the rows
+		return fn(*domain)
+	}
+	for rows.Next() {
+		var next model.Domain
+		var source, lang *string
+		err = rows.Scan(&next.ID, &next.CreatedAt, &next.UpdatedAt, &next.Host, &next.TLD, &next.ResponseCode,
+			&next.ErrorCount, &next.ErrorClass, &next.ContentLanguage, &next.BlockerName, &next.IP,
+			&next.CheckedAt, &next.MaxAge, &next.Group, &source, &lang)
+		if err != nil {
+			return err
+		}
+		if domain == nil || domain.ID != next.ID {
+			if err = emit(); err != nil {
+				return err
+			}
+			domain = &next
+		}
+		if source != nil && lang != nil {
+			domain.Languages = append(domain.Languages, model.DomainLanguage{DomainID: next.ID, Source: *source, Lang: *lang})
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	return emit()
 }
 
-func (d *DomainRepository) FindByContentLang(lang string) ([]model.Domain, error) {
-	lang = strings.ToUpper(lang)
-	domains := make([]model.Domain, 0)
-	tx := d.db
-	err := tx.Model(&model.Domain{}).Where(`"contentLang" = ?`, lang).Find(&domains).Error
+// filter selects the domains matching all filters
+func (d *DomainRepository) filter(ctx context.Context, dialect dialect, filter model.DomainFilter) *gorm.DB {
+	tx := d.db.WithContext(ctx).Model(&model.Domain{})
+	if filter.ContentLang != "" {
+		tx = tx.Where("id in (select domain_id from domain_languages where lang=? and source=?)",
+			strings.ToUpper(filter.ContentLang), model.LangSourceContent)
+	}
+	if filter.DeclaredLang != "" {
+		tx = tx.Where("id in (select domain_id from domain_languages where lang=? and source in ?)",
+			strings.ToUpper(filter.DeclaredLang), []string{model.LangSourceTags, model.LangSourceSitemap})
+	}
+	if filter.ResponseCode != "" {
+		tx = tx.Where("response_code=?", filter.ResponseCode)
+	}
+	if filter.BlockerName != "" {
+		tx = tx.Where("blocker_name=?", filter.BlockerName)
+	}
+	if filter.ErrorClass != "" {
+		tx = tx.Where("error_class=?", filter.ErrorClass)
+	}
+	if filter.TLD != "" {
+		tx = tx.Where("tld=?", strings.ToLower(filter.TLD))
+	}
+	if filter.CheckedFrom != nil {
+		tx = tx.Where(dialect.time("checked_at")+">="+dialect.time("?"), *filter.CheckedFrom)
+	}
+	if filter.CheckedTo != nil {
+		tx = tx.Where(dialect.time("checked_at")+"<"+dialect.time("?"), *filter.CheckedTo)
+	}
+	return tx
+}
+
+// domainKeyset selects the domains after the cursor in the order of the sort column and id
+func domainKeyset(d dialect, column string, desc bool, c model.Cursor) clause.Expr {
+	op := ">"
+	if desc {
+		op = "<"
+	}
+	switch column {
+	case "id":
+		return gorm.Expr("id"+op+"?", c.ID)
+	case "checked_at":
+		if c.Time == nil { // only the domains without the time follow
+			return gorm.Expr("checked_at is null and id"+op+"?", c.ID)
+		}
+		checkedAt, value := d.time("checked_at"), d.time("?")
+		return gorm.Expr("(checked_at is null or "+checkedAt+op+value+" or "+checkedAt+"="+value+" and id"+op+"?)",
+			*c.Time, *c.Time, c.ID)
+	}
+	var value interface{} = c.Text
+	if column == "error_count" {
+		value = c.Num
+	}
+	return gorm.Expr("("+column+op+"? or "+column+"=? and id"+op+"?)", value, value, c.ID)
+}
+
+// CountByLang returns the number of domains per language and source, the most common first
+func (d *DomainRepository) CountByLang(ctx context.Context) ([]model.LanguageCount, error) {
+	counts := make([]model.LanguageCount, 0)
+	err := d.db.WithContext(ctx).Raw(`select l.lang, l.source, count(*) as domains
+		from domain_languages l
+		join domains d on d.id=l.domain_id
+		where d.deleted_at is null
+		group by l.lang, l.source
+		order by domains desc, l.lang, l.source`).
+		Scan(&counts).Error
 	if err != nil {
 		return nil, err
 	}
-	return domains, nil
+	return counts, nil
 }
 
-func (d *DomainRepository) FindByHost(hosts ...string) ([]model.Domain, error) {
+func (d *DomainRepository) FindByHost(ctx context.Context, hosts ...string) ([]model.Domain, error) {
 	var domains []model.Domain
 
-	err := d.db.Preload(clause.Associations).Where("host in ?", hosts).Find(&domains).Error
+	err := d.db.WithContext(ctx).Preload(clause.Associations).Where("host in ?", hosts).Order("id").Find(&domains).Error
 	if err != nil {
 		return nil, err
 	}
@@ -149,15 +301,15 @@ func (d *DomainRepository) FindByHost(hosts ...string) ([]model.Domain, error) {
 	return domains, nil
 }
 
-func (d *DomainRepository) CreateWithHost(hosts ...string) ([]model.Domain, error) {
+func (d *DomainRepository) CreateWithHost(ctx context.Context, hosts ...string) ([]model.Domain, error) {
 	domains := make([]model.Domain, 0)
 	for _, host := range hosts {
 		domains = append(domains, model.Domain{Host: host})
 	}
 
-	if err := d.Create(domains...); err != nil {
+	if err := d.Create(ctx, domains...); err != nil {
 		return nil, err
 	}
 
-	return d.FindByHost(hosts...)
+	return d.FindByHost(ctx, hosts...)
 }
@@ -0,0 +1,28 @@
+package sqlstore
+
+import (
+	"errors"
+	"fmt"
+	"restapi_langparser/internal/store"
+
+	"github.com/glebarez/go-sqlite"
+	"github.com/jackc/pgconn"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// pgUniqueViolation is the SQLSTATE of the duplicate key
+const pgUniqueViolation = "23505"
+
+// conflict wraps the unique violation of Postgres or SQLite with store.ErrConflict
+func conflict(err error) error {
+	var pgErr *pgconn.PgError
+	var sqliteErr *sqlite.Error
+	switch {
+	case errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation:
+	case errors.As(err, &sqliteErr) &&
+		(sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY):
+	default:
+		return err
+	}
+	return fmt.Errorf("%w: %s", store.ErrConflict, err)
+}
@@ -0,0 +1,64 @@
+package sqlstore
+
+import (
+	"context"
+	"restapi_langparser/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// SaveCrawlResult updates the domain and adds the crawl to its history at once
+func (s *Store) SaveCrawlResult(ctx context.Context, domain model.Domain, history model.DomainHistory) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := NewDomainRepository(tx).Update(ctx, domain); err != nil {
+			return err
+		}
+		return tx.Create(&history).Error
+	})
+}
+
+// GetHistory returns crawls of the domain from the latest one
+func (s *Store) GetHistory(ctx context.Context, domainID uint, page model.Page) ([]model.DomainHistory, *model.PageInfo, error) {
+	cursor, err := model.DecodeCursor(page.Cursor, model.CursorHistory)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cursor != nil && cursor.Time == nil {
+		return nil, nil, model.ErrInvalidCursor
+	}
+
+	info := &model.PageInfo{}
+	err = s.db.WithContext(ctx).Model(&model.DomainHistory{}).Where("domain_id=?", domainID).Count(&info.Total).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	crawledAt := s.dialect.time("crawled_at")
+	tx := s.db.WithContext(ctx).Where("domain_id=?", domainID)
+	if cursor != nil {
+		value := s.dialect.time("?")
+		tx = tx.Where("("+crawledAt+"<"+value+" or "+crawledAt+"="+value+" and id<?)", *cursor.Time, *cursor.Time, cursor.ID)
+	}
+	if page.Limit > 0 {
+		tx = tx.Limit(page.Limit + 1)
+	}
+	history := make([]model.DomainHistory, 0)
+	if err = tx.Order(crawledAt + " desc, id desc").Find(&history).Error; err != nil {
+		return nil, nil, err
+	}
+	if page.Limit > 0 && len(history) > page.Limit {
+		history = history[:page.Limit]
+		last := history[page.Limit-1]
+		info.NextCursor = model.Cursor{Sort: model.CursorHistory, ID: last.ID, Time: &last.CrawledAt}.Encode()
+	}
+	return history, info, nil
+}
+
+func (s *Store) GetHistoryRecord(ctx context.Context, domainID, id uint) (*model.DomainHistory, error) {
+	history := &model.DomainHistory{}
+	err := s.db.WithContext(ctx).Where("domain_id=?", domainID).First(history, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
@@ -0,0 +1,187 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"restapi_langparser/internal/model"
+	"restapi_langparser/internal/store"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// IngestRequest takes a connection for each batch only, so next and the other
+// queries are not blocked by the upload when the pool has one connection, like
+// on SQLite. The batch is loaded into the ingest_hosts temporary table of the
+// connection, then the domains, the queue rows and the request links are made
+// from it by a few statements in one transaction.
+func (s *Store) IngestRequest(ctx context.Context, code string, key *model.APIKey, next func() ([]string, error)) (int64, error) {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return 0, err
+	}
+
+	var added int64
+	for {
+		hosts, err := next()
+		if errors.Is(err, io.EOF) {
+			return added, nil
+		}
+		if err != nil {
+			return added, err
+		}
+		if len(hosts) == 0 {
+			continue
+		}
+
+		linked, err := s.ingestHosts(ctx, sqlDB, code, key, hosts)
+		added += linked
+		if err != nil {
+			return added, err
+		}
+		s.notifyQueue(ctx)
+	}
+}
+
+// ingestHosts stages the hosts on its own connection and adds them to the
+// request. The store lock is taken before the connection, as the writers
+// holding the lock wait for a connection of the pool.
+func (s *Store) ingestHosts(ctx context.Context, sqlDB *sql.DB, code string, key *model.APIKey, hosts []string) (int64, error) {
+	rows := make([][]interface{}, 0, len(hosts))
+	seen := make(map[string]struct{}, len(hosts))
+	for _, host := range hosts {
+		if _, dup := seen[host]; dup {
+			continue
+		}
+		seen[host] = struct{}{}
+		rows = append(rows, []interface{}{host, model.TLDOf(host)})
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, "create temp table if not exists ingest_hosts (host text primary key, tld text not null)")
+	if err != nil {
+		return 0, err
+	}
+	defer conn.ExecContext(context.Background(), "drop table if exists ingest_hosts") //nolint:errcheck
+
+	if _, err = conn.ExecContext(ctx, "delete from ingest_hosts"); err != nil {
+		return 0, err
+	}
+	if err = s.dialect.loadHosts(ctx, conn, rows); err != nil {
+		return 0, err
+	}
+
+	db := s.db.Session(&gorm.Session{NewDB: true, Context: ctx})
+	db.Statement.ConnPool = conn
+	return s.ingestBatch(db, code, key, len(rows))
+}
+
+// ingestBatch adds the staged hosts to the request and charges them to the
+// quota of the key, the batch is rolled back if the request is stopped or the
+// quota is exceeded
+func (s *Store) ingestBatch(db *gorm.DB, code string, key *model.APIKey, hosts int) (int64, error) {
+	args := map[string]interface{}{
+		"now":       time.Now(),
+		"code":      code,
+		"ok":        model.ResponseOk,
+		"ingesting": model.RequestIngesting,
+	}
+	var linked int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`insert into domains (created_at, updated_at, host, tld, response_code, error_count,
+				content_lang, blocker_name, error_class, ip, max_age, refresh_group)
+			select @now, @now, host, tld, '', 0, '', '', '', '', 0, '' from ingest_hosts where true
+			on conflict (host) do update set deleted_at=null where domains.deleted_at is not null`, args).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Exec(`insert into queues (created_at, updated_at, domain_id, update_at, worker_id, refresh)
+			select @now, @now, d.id, @now, '', false from ingest_hosts i
+			join domains d on d.host=i.host
+			where d.deleted_at is null and coalesce(d.response_code, '')<>@ok
+			on conflict (domain_id) do update set update_at=excluded.update_at, deleted_at=null`, args).Error
+		if err != nil {
+			return err
+		}
+
+		res := tx.Exec(`insert into request_domains (domain_id, code, created_at)
+			select d.id, @code, @now from ingest_hosts i
+			join domains d on d.host=i.host
+			where d.deleted_at is null
+			on conflict do nothing`, args)
+		if res.Error != nil {
+			return res.Error
+		}
+		linked = res.RowsAffected
+
+		args["linked"] = linked
+		res = tx.Exec("update requests set hosts=hosts+@linked where code=@code and status=@ingesting", args)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return model.ErrRequestFinished
+		}
+
+		if key == nil || key.DailyHosts == 0 {
+			return nil
+		}
+		charged, err := addUsage(tx, key.ID, model.UsageDay(time.Now()), hosts, key.DailyHosts)
+		if err == nil && !charged {
+			err = fmt.Errorf("%w: %d hosts are over the quota of %d hosts per day", store.ErrQuotaExceeded, hosts, key.DailyHosts)
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return linked, nil
+}
+
+func (s *Store) FinishIngest(ctx context.Context, code string, overBudget int) (string, error) {
+	status := model.RequestPending
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var queued int64
+		err := tx.Raw(`select count(*) from request_domains r
+			join domains d on d.id=r.domain_id and d.deleted_at is null
+			join queues q on q.domain_id=r.domain_id and q.deleted_at is null
+			where r.code=? and `+requestQueued(s.dialect, "d"), code).
+			Scan(&queued).Error
+		if err != nil {
+			return err
+		}
+		if queued == 0 {
+			status = model.RequestDone
+		}
+
+		res := tx.Model(&model.RequestHeader{}).
+			Where("code=? and status=?", code, model.RequestIngesting).
+			Updates(map[string]interface{}{"status": status, "over_budget": overBudget})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			if err = tx.Where("code=?", code).First(&model.RequestHeader{}).Error; err != nil {
+				return err
+			}
+			return model.ErrRequestFinished
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return status, nil
+}
@@ -0,0 +1,218 @@
+package sqlstore
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// ErrNewerSchema is returned when the database was migrated by a newer version of the application
+var ErrNewerSchema = errors.New("database schema is newer than the application")
+
+// migrationLock is the key of the Postgres advisory lock held while migrating
+const migrationLock = 0x6c616e67
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// Migrator applies and rolls back the versioned migrations embedded in the
+// binary. Applied versions are recorded in the schema_version table.
+type Migrator struct {
+	db         *gorm.DB
+	dialect    dialect
+	migrations []migration
+}
+
+func NewMigrator(db *gorm.DB) (*Migrator, error) {
+	d := dialectOf(db)
+	migrations, err := loadMigrations(d.name)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		db:         db,
+		dialect:    d,
+		migrations: migrations,
+	}, nil
+}
+
+// loadMigrations reads migrations/<dialect>/<version>_<name>.(up|down).sql
+func loadMigrations(dialect string) ([]migration, error) {
+	dir := path.Join("migrations", dialect)
+	files, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), ".sql")
+		direction := path.Ext(name)
+		name = strings.TrimSuffix(name, direction)
+		sep := strings.IndexByte(name, '_')
+		if sep < 0 || (direction != ".up" && direction != ".down") {
+			return nil, fmt.Errorf("bad migration file name %s", file.Name())
+		}
+		version, err := strconv.Atoi(name[:sep])
+		if err != nil {
+			return nil, fmt.Errorf("bad migration file name %s", file.Name())
+		}
+
+		body, err := migrationFiles.ReadFile(path.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: name[sep+1:]}
+			byVersion[version] = m
+		}
+		if direction == ".up" {
+			m.up = string(body)
+		} else {
+			m.down = string(body)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d %s needs both up and down files", m.version, m.name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
+
+// Latest returns the schema version of the application
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].version
+}
+
+// Version returns the schema version of the database, 0 for the empty one
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	return m.version(m.db.WithContext(ctx))
+}
+
+func (m *Migrator) version(tx *gorm.DB) (int, error) {
+	err := tx.Exec(`create table if not exists schema_version (
+		version integer primary key,
+		name text not null,
+		applied_at ` + m.dialect.timestampType + ` not null)`).Error
+	if err != nil {
+		return 0, err
+	}
+	var version *int
+	err = tx.Raw("select max(version) from schema_version").Scan(&version).Error
+	if err != nil || version == nil {
+		return 0, err
+	}
+	return *version, nil
+}
+
+// Check refuses the database migrated by a newer version of the application
+func (m *Migrator) Check(ctx context.Context) error {
+	version, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if version > m.Latest() {
+		return fmt.Errorf("%w: version %d, supported %d", ErrNewerSchema, version, m.Latest())
+	}
+	return nil
+}
+
+// Up applies the migrations up to the target version, 0 means the latest one
+func (m *Migrator) Up(ctx context.Context, target int) error {
+	if target == 0 {
+		target = m.Latest()
+	}
+	if err := m.Check(ctx); err != nil {
+		return err
+	}
+	for _, mig := range m.migrations {
+		if mig.version > target {
+			break
+		}
+		err := m.step(ctx, func(tx *gorm.DB, version int) error {
+			if version >= mig.version {
+				return nil // applied by a concurrent process
+			}
+			if err := tx.Exec(mig.up).Error; err != nil {
+				return fmt.Errorf("migration %d %s: %w", mig.version, mig.name, err)
+			}
+			return tx.Exec("insert into schema_version (version, name, applied_at) values (?, ?, ?)", mig.version, mig.name, time.Now()).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Down rolls back the given number of the latest applied migrations
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	if err := m.Check(ctx); err != nil {
+		return err
+	}
+	for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+		mig := m.migrations[i]
+		applied := false
+		err := m.step(ctx, func(tx *gorm.DB, version int) error {
+			if version < mig.version {
+				return nil
+			}
+			if err := tx.Exec(mig.down).Error; err != nil {
+				return fmt.Errorf("rollback %d %s: %w", mig.version, mig.name, err)
+			}
+			applied = true
+			return tx.Exec("delete from schema_version where version=?", mig.version).Error
+		})
+		if err != nil {
+			return err
+		}
+		if applied {
+			steps--
+		}
+	}
+	return nil
+}
+
+// step runs one migration in a transaction, concurrent migrations of other
+// processes wait for the advisory lock on Postgres
+func (m *Migrator) step(ctx context.Context, fn func(tx *gorm.DB, version int) error) error {
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if m.dialect.name == postgresDialect.name {
+			if err := tx.Exec("select pg_advisory_xact_lock(?)", migrationLock).Error; err != nil {
+				return err
+			}
+		}
+		version, err := m.version(tx)
+		if err != nil {
+			return err
+		}
+		return fn(tx, version)
+	})
+}
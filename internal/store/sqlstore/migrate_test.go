@@ -0,0 +1,267 @@
+package sqlstore_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"restapi_langparser/internal/model"
+	"restapi_langparser/internal/store/sqlstore"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func testMigrator(t *testing.T) (*gorm.DB, *sqlstore.Migrator) {
+	t.Helper()
+	db, err := sqlstore.ConnectSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := sqlstore.NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, m
+}
+
+func assertVersion(t *testing.T, m *sqlstore.Migrator, want int) {
+	t.Helper()
+	version, err := m.Version(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != want {
+		t.Fatalf("schema version %d, want %d", version, want)
+	}
+}
+
+func TestMigrator_UpDown(t *testing.T) {
+	ctx := context.Background()
+	db, m := testMigrator(t)
+	assertVersion(t, m, 0)
+
+	if err := m.Up(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(t, m, 1)
+	if err := m.Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(t, m, m.Latest())
+	if !db.Migrator().HasIndex("queues", "idx_queues_update_at") {
+		t.Fatal("queue index is not created")
+	}
+
+	if err := m.Down(ctx, m.Latest()-1); err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(t, m, 1)
+	if db.Migrator().HasIndex("queues", "idx_queues_update_at") {
+		t.Fatal("queue index is not dropped")
+	}
+
+	if err := m.Down(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(t, m, 0)
+	if db.Migrator().HasTable("domains") {
+		t.Fatal("domains table is not dropped")
+	}
+
+	if err := m.Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(t, m, m.Latest())
+}
+
+// the schema created by AutoMigrate before the versioned migrations is adopted
+func TestMigrator_AutoMigratedSchema(t *testing.T) {
+	ctx := context.Background()
+	db, m := testMigrator(t)
+	if err := m.Up(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("delete from schema_version").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(t, m, m.Latest())
+}
+
+func TestMigrator_DomainLanguages(t *testing.T) {
+	ctx := context.Background()
+	db, m := testMigrator(t)
+	if err := m.Up(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	err := db.Exec(`insert into domains (host, content_lang, tags_languages, sitemap_languages)
+		values ('https://a.example', 'en', 'EN,DE', 'fr'), ('https://b.example', '', 'ENG', '')`).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Up(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	var langs []model.DomainLanguage
+	if err = db.Order("domain_id, source, lang").Find(&langs).Error; err != nil {
+		t.Fatal(err)
+	}
+	want := []model.DomainLanguage{
+		{DomainID: 1, Source: model.LangSourceContent, Lang: "EN", Confidence: 1},
+		{DomainID: 1, Source: model.LangSourceSitemap, Lang: "FR", Confidence: 1},
+		{DomainID: 1, Source: model.LangSourceTags, Lang: "DE", Confidence: 1},
+		{DomainID: 1, Source: model.LangSourceTags, Lang: "EN", Confidence: 1},
+		{DomainID: 2, Source: model.LangSourceTags, Lang: "ENG", Confidence: 1},
+	}
+	if !reflect.DeepEqual(langs, want) {
+		t.Fatalf("languages %+v, want %+v", langs, want)
+	}
+
+	if err = m.Down(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	var tags string
+	if err = db.Raw("select tags_languages from domains where id=2").Scan(&tags).Error; err != nil {
+		t.Fatal(err)
+	}
+	if tags != "ENG" {
+		t.Fatalf("tags languages %q after rollback", tags)
+	}
+}
+
+func TestMigrator_NewerSchema(t *testing.T) {
+	ctx := context.Background()
+	db, m := testMigrator(t)
+	if err := m.Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	err := db.Exec("insert into schema_version (version, name, applied_at) values (?, 'future', current_timestamp)", m.Latest()+1).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, fn := range map[string]func() error{
+		"check": func() error { return m.Check(ctx) },
+		"up":    func() error { return m.Up(ctx, 0) },
+		"down":  func() error { return m.Down(ctx, 1) },
+	} {
+		if err = fn(); !errors.Is(err, sqlstore.ErrNewerSchema) {
+			t.Fatalf("%s on newer schema: %v", name, err)
+		}
+	}
+}
+
+func TestMigrator_DomainTLD(t *testing.T) {
+	ctx := context.Background()
+	db, m := testMigrator(t)
+	if err := m.Up(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	hosts := []string{"https://www.Example.COM:8080/a.b?c=d.e", "http://a.example.org", "https://localhost/", "example.net"}
+	for _, host := range hosts {
+		if err := db.Exec("insert into domains (host) values (?)", host).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Up(ctx, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, host := range hosts {
+		var tld string
+		if err := db.Raw("select tld from domains where host=?", host).Scan(&tld).Error; err != nil {
+			t.Fatal(err)
+		}
+		if want := model.TLDOf(host); tld != want {
+			t.Fatalf("tld of %s is %q, want %q", host, tld, want)
+		}
+	}
+}
+
+func TestMigrator_RequestHeaders(t *testing.T) {
+	ctx := context.Background()
+	db, m := testMigrator(t)
+	if err := m.Up(ctx, 4); err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{
+		"insert into domains (id, host) values (1, 'https://a.example'), (2, 'https://b.example')",
+		"insert into requests (domain_id, code, created_at) values (1, 'both', ?), (2, 'both', ?), (1, 'single', ?)",
+		"insert into queues (domain_id, update_at) values (2, ?)",
+	} {
+		now := time.Now()
+		if err := db.Exec(query, now, now, now).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Up(ctx, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	var requests []model.RequestHeader
+	if err := db.Order("code").Find(&requests).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 ||
+		requests[0].Code != "both" || requests[0].Status != model.RequestPending || requests[0].Hosts != 2 ||
+		requests[1].Code != "single" || requests[1].Status != model.RequestDone || requests[1].Hosts != 1 {
+		t.Fatalf("requests %+v", requests)
+	}
+	var links int64
+	if err := db.Model(&model.Request{}).Count(&links).Error; err != nil || links != 3 {
+		t.Fatalf("request domains %d %v", links, err)
+	}
+}
+
+func TestMigrator_BaselineSchema(t *testing.T) {
+	ctx := context.Background()
+	db, m := testMigrator(t)
+	// the tables of AutoMigrate, their columns have no defaults
+	for _, stmt := range []string{
+		`create table domains (id integer primary key, created_at datetime, updated_at datetime, deleted_at datetime,
+			host text unique, response_code text, error_count integer, content_lang text, blocker_name text, ip text,
+			tags_languages text, sitemap_languages text, checked_at datetime, max_age integer, refresh_group text)`,
+		`create table proxies (id integer primary key, ip text, port text, login text, password text, type text)`,
+		`create table requests (domain_id integer, code text, created_at datetime, primary key (domain_id, code))`,
+		`create table queues (id integer primary key, created_at datetime, updated_at datetime, deleted_at datetime,
+			domain_id integer unique, update_at datetime, worker_id text, lease_until datetime, refresh numeric)`,
+		`insert into domains (id, host, response_code, error_count, content_lang, blocker_name, ip, tags_languages, sitemap_languages)
+			values (1, 'https://a.example', '', 0, '', '', '', '', '')`,
+		`insert into requests (domain_id, code, created_at) values (1, 'code', '2020-01-01 00:00:00+00:00')`,
+		`insert into queues (domain_id, update_at) values (1, '2020-01-01 00:00:00+00:00')`,
+	} {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(t, m, m.Latest())
+
+	// the queue rows added after the upgrade get the defaults too
+	err := db.Exec(`insert into domains (id, host, max_age, refresh_group) values (2, 'https://b.example', 0, '')`).Error
+	if err == nil {
+		err = db.Exec(`insert into queues (domain_id, update_at) values (2, '2020-01-01 00:00:00+00:00')`).Error
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nulls int64
+	err = db.Raw(`select count(*) from queues q join domains d on d.id=q.domain_id
+		where q.worker_id is null or q.refresh is null or d.max_age is null or d.refresh_group is null`).Scan(&nulls).Error
+	if err != nil || nulls != 0 {
+		t.Fatalf("%d rows without defaults %v", nulls, err)
+	}
+
+	depth, err := sqlstore.New(db).QueueDepth(ctx, model.LaneUserRequest)
+	if err != nil || depth != 1 {
+		t.Fatalf("user request depth of the upgraded database %d %v", depth, err)
+	}
+}
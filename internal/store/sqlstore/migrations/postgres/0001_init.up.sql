@@ -0,0 +1,100 @@
+-- baseline of the schema created by gorm AutoMigrate, existing tables are kept
+create table if not exists domains (
+    id bigserial primary key,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    host text unique,
+    response_code text,
+    error_count bigint,
+    content_lang text,
+    blocker_name text,
+    ip text,
+    tags_languages text,
+    sitemap_languages text,
+    checked_at timestamptz,
+    max_age bigint not null default 0,
+    refresh_group text not null default ''
+);
+create index if not exists idx_domains_deleted_at on domains (deleted_at);
+
+create table if not exists proxies (
+    id bigserial primary key,
+    ip text,
+    port text,
+    login text,
+    password text,
+    type text
+);
+
+create table if not exists requests (
+    domain_id bigint,
+    code text,
+    created_at timestamptz,
+    primary key (domain_id, code),
+    constraint fk_domains_requests foreign key (domain_id) references domains (id) on delete cascade
+);
+
+create table if not exists queues (
+    id bigserial primary key,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    domain_id bigint unique,
+    update_at timestamptz,
+    worker_id text not null default '',
+    lease_until timestamptz,
+    refresh boolean not null default false,
+    constraint fk_domains_queue foreign key (domain_id) references domains (id) on delete cascade
+);
+create index if not exists idx_queues_deleted_at on queues (deleted_at);
+
+create table if not exists workers (
+    id text primary key,
+    host text,
+    threads bigint,
+    active bigint,
+    started_at timestamptz,
+    heartbeat_at timestamptz
+);
+
+create table if not exists domain_histories (
+    id bigserial primary key,
+    domain_id bigint,
+    crawled_at timestamptz,
+    response_code text,
+    content_lang text,
+    proxy text,
+    error text,
+    tags_languages text,
+    sitemap_languages text
+);
+create index if not exists idx_domain_histories_domain on domain_histories (domain_id, crawled_at);
+
+create table if not exists watchlists (
+    id bigserial primary key,
+    name text unique,
+    callback text,
+    interval bigint,
+    last_run_at timestamptz,
+    created_at timestamptz
+);
+
+create table if not exists watchlist_domains (
+    watchlist_id bigint,
+    domain_id bigint,
+    primary key (watchlist_id, domain_id),
+    constraint fk_watchlist_domains_watchlist foreign key (watchlist_id) references watchlists (id) on delete cascade,
+    constraint fk_watchlist_domains_domain foreign key (domain_id) references domains (id) on delete cascade
+);
+
+-- columns added to the models after the baseline, the tables created by AutoMigrate
+-- miss them, 0009 adds their defaults
+alter table domains
+    add column if not exists checked_at timestamptz,
+    add column if not exists max_age bigint,
+    add column if not exists refresh_group text;
+alter table queues
+    add column if not exists worker_id text,
+    add column if not exists lease_until timestamptz,
+    add column if not exists refresh boolean;
@@ -0,0 +1,4 @@
+drop index if exists idx_workers_heartbeat_at;
+drop index if exists idx_domains_response_code;
+drop index if exists idx_requests_code;
+drop index if exists idx_queues_update_at;
@@ -0,0 +1,25 @@
+-- languages of the domains move from the comma-joined columns to their own table
+create table domain_languages (
+    domain_id bigint not null,
+    source text not null,
+    lang text not null,
+    confidence double precision not null default 1,
+    primary key (domain_id, source, lang),
+    constraint fk_domains_languages foreign key (domain_id) references domains (id) on delete cascade
+);
+create index idx_domain_languages_lang on domain_languages (lang, source);
+
+insert into domain_languages (domain_id, source, lang)
+select d.id, 'content', upper(d.content_lang) from domains d
+where d.content_lang <> '';
+
+insert into domain_languages (domain_id, source, lang)
+select distinct d.id, 'tags', upper(l.lang) from domains d, unnest(string_to_array(d.tags_languages, ',')) as l(lang)
+where l.lang <> '';
+
+insert into domain_languages (domain_id, source, lang)
+select distinct d.id, 'sitemap', upper(l.lang) from domains d, unnest(string_to_array(d.sitemap_languages, ',')) as l(lang)
+where l.lang <> '';
+
+alter table domains drop column tags_languages;
+alter table domains drop column sitemap_languages;
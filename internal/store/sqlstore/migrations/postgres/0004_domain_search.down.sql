@@ -0,0 +1,7 @@
+drop index if exists idx_domains_checked_at;
+drop index if exists idx_domains_blocker_name;
+drop index if exists idx_domains_error_class;
+drop index if exists idx_domains_tld;
+
+alter table domains drop column tld;
+alter table domains drop column error_class;
@@ -0,0 +1,10 @@
+-- error class and top level domain of the domain search, indexes of the search filters
+alter table domains add column error_class text not null default '';
+alter table domains add column tld text not null default '';
+
+update domains set tld = coalesce(lower(substring(host from '^[^:/]+://[^/?#:]*\.([^./?#:]+)')), '');
+
+create index idx_domains_tld on domains (tld) where deleted_at is null;
+create index idx_domains_error_class on domains (error_class) where deleted_at is null;
+create index idx_domains_blocker_name on domains (blocker_name) where deleted_at is null;
+create index idx_domains_checked_at on domains (checked_at) where deleted_at is null;
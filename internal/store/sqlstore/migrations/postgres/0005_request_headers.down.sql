@@ -0,0 +1,4 @@
+drop table requests;
+
+alter index idx_request_domains_code rename to idx_requests_code;
+alter table request_domains rename to requests;
@@ -0,0 +1 @@
+drop index if exists idx_requests_created_at;
@@ -0,0 +1,2 @@
+-- the janitor expires and deletes the requests from the oldest ones
+create index if not exists idx_requests_created_at on requests (created_at);
@@ -0,0 +1,24 @@
+-- api keys of the tenants, only the hashes of the secrets are stored
+create table api_keys (
+    id bigserial primary key,
+    tenant text not null,
+    name text not null default '',
+    prefix text not null,
+    hash text not null unique,
+    scope text not null,
+    daily_hosts integer not null default 0,
+    rate_limit integer not null default 0,
+    created_at timestamptz not null,
+    revoked_at timestamptz
+);
+
+-- hosts submitted by the key per UTC day
+create table api_key_usage (
+    key_id bigint not null,
+    day text not null,
+    hosts integer not null default 0,
+    primary key (key_id, day),
+    constraint fk_api_key_usage_key foreign key (key_id) references api_keys (id) on delete cascade
+);
+
+alter table watchlists add column owner text not null default '';
@@ -0,0 +1,17 @@
+-- defaults of the columns added to the tables created by AutoMigrate, the null
+-- refresh rows were missed by the lanes
+update domains set max_age = 0 where max_age is null;
+update domains set refresh_group = '' where refresh_group is null;
+update queues set worker_id = '' where worker_id is null;
+update queues set refresh = false where refresh is null;
+
+alter table domains
+    alter column max_age set default 0,
+    alter column max_age set not null,
+    alter column refresh_group set default '',
+    alter column refresh_group set not null;
+alter table queues
+    alter column worker_id set default '',
+    alter column worker_id set not null,
+    alter column refresh set default false,
+    alter column refresh set not null;
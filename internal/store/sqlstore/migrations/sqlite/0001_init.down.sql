@@ -0,0 +1,8 @@
+drop table if exists watchlist_domains;
+drop table if exists watchlists;
+drop table if exists domain_histories;
+drop table if exists workers;
+drop table if exists queues;
+drop table if exists requests;
+drop table if exists proxies;
+drop table if exists domains;
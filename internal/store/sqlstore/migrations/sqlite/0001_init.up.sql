@@ -0,0 +1,89 @@
+-- baseline of the schema created by gorm AutoMigrate, existing tables are kept
+create table if not exists domains (
+    id integer primary key,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    host text unique,
+    response_code text,
+    error_count integer,
+    content_lang text,
+    blocker_name text,
+    ip text,
+    tags_languages text,
+    sitemap_languages text,
+    checked_at datetime,
+    max_age integer not null default 0,
+    refresh_group text not null default ''
+);
+create index if not exists idx_domains_deleted_at on domains (deleted_at);
+
+create table if not exists proxies (
+    id integer primary key,
+    ip text,
+    port text,
+    login text,
+    password text,
+    type text
+);
+
+create table if not exists requests (
+    domain_id integer,
+    code text,
+    created_at datetime,
+    primary key (domain_id, code),
+    constraint fk_domains_requests foreign key (domain_id) references domains (id) on delete cascade
+);
+
+create table if not exists queues (
+    id integer primary key,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    domain_id integer unique,
+    update_at datetime,
+    worker_id text not null default '',
+    lease_until datetime,
+    refresh numeric not null default false,
+    constraint fk_domains_queue foreign key (domain_id) references domains (id) on delete cascade
+);
+create index if not exists idx_queues_deleted_at on queues (deleted_at);
+
+create table if not exists workers (
+    id text primary key,
+    host text,
+    threads integer,
+    active integer,
+    started_at datetime,
+    heartbeat_at datetime
+);
+
+create table if not exists domain_histories (
+    id integer primary key,
+    domain_id integer,
+    crawled_at datetime,
+    response_code text,
+    content_lang text,
+    proxy text,
+    error text,
+    tags_languages text,
+    sitemap_languages text
+);
+create index if not exists idx_domain_histories_domain on domain_histories (domain_id, crawled_at);
+
+create table if not exists watchlists (
+    id integer primary key,
+    name text unique,
+    callback text,
+    interval integer,
+    last_run_at datetime,
+    created_at datetime
+);
+
+create table if not exists watchlist_domains (
+    watchlist_id integer,
+    domain_id integer,
+    primary key (watchlist_id, domain_id),
+    constraint fk_watchlist_domains_watchlist foreign key (watchlist_id) references watchlists (id) on delete cascade,
+    constraint fk_watchlist_domains_domain foreign key (domain_id) references domains (id) on delete cascade
+);
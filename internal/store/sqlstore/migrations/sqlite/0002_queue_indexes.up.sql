@@ -0,0 +1,5 @@
+-- indexes of the lane queries, the completed requests and the worker list
+create index if not exists idx_queues_update_at on queues (update_at, id) where deleted_at is null;
+create index if not exists idx_requests_code on requests (code);
+create index if not exists idx_domains_response_code on domains (response_code) where deleted_at is null;
+create index if not exists idx_workers_heartbeat_at on workers (heartbeat_at);
@@ -0,0 +1,8 @@
+alter table domains add column tags_languages text;
+alter table domains add column sitemap_languages text;
+
+update domains set
+    tags_languages = (select group_concat(l.lang, ',') from domain_languages l where l.domain_id=domains.id and l.source='tags'),
+    sitemap_languages = (select group_concat(l.lang, ',') from domain_languages l where l.domain_id=domains.id and l.source='sitemap');
+
+drop table domain_languages;
@@ -0,0 +1,25 @@
+-- languages of the domains move from the comma-joined columns to their own table
+create table domain_languages (
+    domain_id integer not null,
+    source text not null,
+    lang text not null,
+    confidence real not null default 1,
+    primary key (domain_id, source, lang),
+    constraint fk_domains_languages foreign key (domain_id) references domains (id) on delete cascade
+);
+create index idx_domain_languages_lang on domain_languages (lang, source);
+
+insert into domain_languages (domain_id, source, lang)
+select d.id, 'content', upper(d.content_lang) from domains d
+where d.content_lang <> '';
+
+insert or ignore into domain_languages (domain_id, source, lang)
+select d.id, 'tags', upper(l.value) from domains d, json_each('["' || replace(d.tags_languages, ',', '","') || '"]') l
+where d.tags_languages <> '' and l.value <> '';
+
+insert or ignore into domain_languages (domain_id, source, lang)
+select d.id, 'sitemap', upper(l.value) from domains d, json_each('["' || replace(d.sitemap_languages, ',', '","') || '"]') l
+where d.sitemap_languages <> '' and l.value <> '';
+
+alter table domains drop column tags_languages;
+alter table domains drop column sitemap_languages;
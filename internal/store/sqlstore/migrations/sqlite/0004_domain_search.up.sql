@@ -0,0 +1,19 @@
+-- error class and top level domain of the domain search, indexes of the search filters
+alter table domains add column error_class text not null default '';
+alter table domains add column tld text not null default '';
+
+-- the host name is cut out of the url step by step, the last label of it is the tld
+update domains set tld = case when instr(host, '://') > 0 then substr(host, instr(host, '://') + 3) else '' end;
+update domains set tld = substr(tld, 1, instr(tld, '/') - 1) where instr(tld, '/') > 0;
+update domains set tld = substr(tld, 1, instr(tld, '?') - 1) where instr(tld, '?') > 0;
+update domains set tld = substr(tld, 1, instr(tld, '#') - 1) where instr(tld, '#') > 0;
+update domains set tld = substr(tld, 1, instr(tld, ':') - 1) where instr(tld, ':') > 0;
+update domains set tld = case
+    when instr(tld, '.') > 0 then lower(substr(tld, length(rtrim(tld, replace(tld, '.', ''))) + 1))
+    else ''
+end;
+
+create index idx_domains_tld on domains (tld) where deleted_at is null;
+create index idx_domains_error_class on domains (error_class) where deleted_at is null;
+create index idx_domains_blocker_name on domains (blocker_name) where deleted_at is null;
+create index idx_domains_checked_at on domains (checked_at) where deleted_at is null;
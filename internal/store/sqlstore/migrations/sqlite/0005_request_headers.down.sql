@@ -0,0 +1,5 @@
+drop table requests;
+
+drop index if exists idx_request_domains_code;
+alter table request_domains rename to requests;
+create index idx_requests_code on requests (code);
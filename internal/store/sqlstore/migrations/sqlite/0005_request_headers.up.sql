@@ -0,0 +1,22 @@
+-- every submission gets its own request row, the domains are linked by request_domains
+alter table requests rename to request_domains;
+drop index if exists idx_requests_code;
+create index idx_request_domains_code on request_domains (code);
+
+create table requests (
+    code text primary key,
+    owner text not null default '',
+    created_at datetime not null,
+    status text not null,
+    callback text not null default '',
+    hosts integer not null default 0
+);
+create index idx_requests_owner on requests (owner, created_at);
+
+insert into requests (code, created_at, status, hosts)
+select r.code, coalesce(min(r.created_at), current_timestamp),
+    case when count(q.domain_id)=0 then 'done' else 'pending' end,
+    count(distinct r.domain_id)
+from request_domains r
+left join queues q on q.domain_id=r.domain_id and q.deleted_at is null
+group by r.code;
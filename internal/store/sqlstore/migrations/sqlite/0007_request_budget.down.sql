@@ -0,0 +1,4 @@
+drop index if exists idx_requests_deadline;
+alter table requests drop column deadline;
+alter table requests drop column over_budget;
+alter table requests drop column max_domains;
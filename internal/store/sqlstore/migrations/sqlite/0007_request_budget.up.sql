@@ -0,0 +1,5 @@
+-- budgets of the requests, the janitor stops the pending requests after their deadline
+alter table requests add column max_domains integer not null default 0;
+alter table requests add column over_budget integer not null default 0;
+alter table requests add column deadline datetime;
+create index if not exists idx_requests_deadline on requests (deadline) where status='pending';
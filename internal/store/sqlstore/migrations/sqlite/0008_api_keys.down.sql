@@ -0,0 +1,3 @@
+alter table watchlists drop column owner;
+drop table if exists api_key_usage;
+drop table if exists api_keys;
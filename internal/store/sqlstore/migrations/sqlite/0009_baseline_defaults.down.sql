@@ -0,0 +1,2 @@
+-- the defaults are a part of the baseline, they are kept
+select 1;
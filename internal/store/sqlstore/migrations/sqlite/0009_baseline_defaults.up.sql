@@ -0,0 +1,25 @@
+-- defaults of the columns of the tables created by AutoMigrate, the null
+-- refresh rows were missed by the lanes. SQLite does not alter columns, the
+-- queue is rebuilt, the domains referenced by the other tables are only filled.
+update domains set max_age = 0 where max_age is null;
+update domains set refresh_group = '' where refresh_group is null;
+
+create table queues_new (
+    id integer primary key,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    domain_id integer unique,
+    update_at datetime,
+    worker_id text not null default '',
+    lease_until datetime,
+    refresh numeric not null default false,
+    constraint fk_domains_queue foreign key (domain_id) references domains (id) on delete cascade
+);
+insert into queues_new (id, created_at, updated_at, deleted_at, domain_id, update_at, worker_id, lease_until, refresh)
+select id, created_at, updated_at, deleted_at, domain_id, update_at, coalesce(worker_id, ''), lease_until, coalesce(refresh, false)
+from queues;
+drop table queues;
+alter table queues_new rename to queues;
+create index idx_queues_deleted_at on queues (deleted_at);
+create index idx_queues_update_at on queues (update_at, id) where deleted_at is null;
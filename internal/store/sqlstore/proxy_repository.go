@@ -1,7 +1,7 @@
 package sqlstore
 
 import (
-	"errors"
+	"context"
 	"gorm.io/gorm"
 	"restapi_langparser/internal/model"
 )
@@ -16,31 +16,72 @@ func NewProxyRepository(db *gorm.DB) *ProxyRepository {
 	}
 }
 
-func (p *ProxyRepository) Create(list []model.Proxy) error {
+func (p *ProxyRepository) Create(ctx context.Context, list []model.Proxy) error {
 	batch := make([]model.Proxy, 0, len(list))
 	for _, proxy := range list {
 		if proxy.Validate() == nil { // skip if not valid
 			batch = append(batch, proxy)
 		}
 	}
-	return p.db.Create(batch).Error
+	if len(batch) == 0 {
+		return nil
+	}
+	return p.db.WithContext(ctx).Create(batch).Error
 }
 
-func (p *ProxyRepository) Read(limit, offset int) ([]model.Proxy, error) {
-	return nil, errors.New("not implemented")
+func (p *ProxyRepository) Read(ctx context.Context, page model.Page) ([]model.Proxy, *model.PageInfo, error) {
+	cursor, err := model.DecodeCursor(page.Cursor, model.CursorProxy)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info := &model.PageInfo{}
+	if err = p.db.WithContext(ctx).Model(&model.Proxy{}).Count(&info.Total).Error; err != nil {
+		return nil, nil, err
+	}
+
+	tx := p.db.WithContext(ctx).Order("id")
+	if cursor != nil {
+		tx = tx.Where("id>?", cursor.ID)
+	}
+	if page.Limit > 0 {
+		tx = tx.Limit(page.Limit + 1)
+	}
+	list := make([]model.Proxy, 0)
+	if err = tx.Find(&list).Error; err != nil {
+		return nil, nil, err
+	}
+	if page.Limit > 0 && len(list) > page.Limit {
+		list = list[:page.Limit]
+		info.NextCursor = model.Cursor{Sort: model.CursorProxy, ID: uint(list[page.Limit-1].ID)}.Encode()
+	}
+	return list, info, nil
 }
 
-func (p *ProxyRepository) Delete(ids []int) error {
-	return errors.New("not implemented")
+func (p *ProxyRepository) Delete(ctx context.Context, ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return p.db.WithContext(ctx).Delete(&model.Proxy{}, ids).Error
 }
 
-func (p *ProxyRepository) Update(list []model.Proxy) error {
-	return errors.New("not implemented")
+func (p *ProxyRepository) Update(ctx context.Context, list []model.Proxy) error {
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, proxy := range list {
+			if err := proxy.Validate(); err != nil {
+				return err
+			}
+			if err := tx.Model(&proxy).Select("*").Updates(&proxy).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
-func (p *ProxyRepository) FindByID(id int64) (*model.Proxy, error) {
+func (p *ProxyRepository) FindByID(ctx context.Context, id int64) (*model.Proxy, error) {
 	proxy := &model.Proxy{}
-	tx := p.db
+	tx := p.db.WithContext(ctx)
 	err := tx.Model(&model.Proxy{}).
 		First(proxy, id).
 		Error
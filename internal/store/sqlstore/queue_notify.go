@@ -0,0 +1,60 @@
+package sqlstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+	"gorm.io/driver/postgres"
+)
+
+const queueChannel = "queue_added"
+
+// notifyQueue wakes up the workers waiting on QueueNotify
+func (s *Store) notifyQueue(ctx context.Context) {
+	if s.dialect.name == sqliteDialect.name { // single node, the workers are in this process
+		select {
+		case s.queueEvents <- struct{}{}:
+		default:
+		}
+		return
+	}
+	if err := s.db.WithContext(ctx).Exec("select pg_notify(?, '')", queueChannel).Error; err != nil {
+		logrus.Errorf("Queue notify fail: %s", err)
+	}
+}
+
+// QueueNotify returns a channel signalled when domains are added to the queue by any process
+func (s *Store) QueueNotify() <-chan struct{} {
+	s.listenOnce.Do(s.listen)
+	return s.queueEvents
+}
+
+func (s *Store) listen() {
+	dialector, ok := s.db.Dialector.(*postgres.Dialector)
+	if !ok {
+		return
+	}
+
+	listener := pq.NewListener(dialector.DSN, time.Second, time.Minute, func(_ pq.ListenerEventType, err error) {
+		if err != nil {
+			logrus.Errorf("Queue listener fail: %s", err)
+		}
+	})
+	if err := listener.Listen(queueChannel); err != nil {
+		logrus.Errorf("Queue listen fail: %s", err)
+		listener.Close()
+		return
+	}
+
+	go func() {
+		// nil notification comes after reconnect, events could be lost meanwhile
+		for range listener.Notify {
+			select {
+			case s.queueEvents <- struct{}{}:
+			default:
+			}
+		}
+	}()
+}
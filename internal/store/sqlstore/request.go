@@ -0,0 +1,255 @@
+package sqlstore
+
+import (
+	"context"
+	"restapi_langparser/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CreateRequest saves the submission of the domains under a new random code.
+// The request is done at once if none of its domains is queued.
+func (s *Store) CreateRequest(ctx context.Context, request model.RequestHeader, list []model.Domain) (*model.RequestHeader, error) {
+	code, err := model.NewRequestCode()
+	if err != nil {
+		return nil, err
+	}
+	request.Code = code
+	request.CreatedAt = time.Now()
+	ingesting := request.Status == model.RequestIngesting
+	if !ingesting {
+		request.Status = model.RequestPending
+	}
+	request.Hosts = len(list)
+
+	links := make([]model.Request, len(list))
+	ids := make([]uint, len(list))
+	for i, domain := range list {
+		links[i] = model.Request{DomainID: domain.ID, Code: code}
+		ids[i] = domain.ID
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var queued int64
+		err := tx.Model(&model.Queue{}).Where("domain_id in ?", ids).Count(&queued).Error
+		if err != nil {
+			return err
+		}
+		if queued == 0 && !ingesting {
+			request.Status = model.RequestDone
+		}
+		if err = tx.Create(&request).Error; err != nil {
+			return err
+		}
+		if len(links) == 0 {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&links).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// GetRequestHeader returns the request, gorm.ErrRecordNotFound for the unknown code
+func (s *Store) GetRequestHeader(ctx context.Context, code string) (*model.RequestHeader, error) {
+	request := &model.RequestHeader{}
+	if err := s.db.WithContext(ctx).Where("code=?", code).First(request).Error; err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// requestQueued is the condition of the request domain r which waits for the
+// crawl: it is in the queue q and was not crawled after the request was made
+func requestQueued(d dialect, domains string) string {
+	checkedAt := domains + ".checked_at"
+	return "q.domain_id is not null and (" + checkedAt + " is null or " + d.time(checkedAt) + "<" + d.time("r.created_at") + ")"
+}
+
+// GetRequestProgress counts the domains of the request by their state, gorm.ErrRecordNotFound for the unknown code
+func (s *Store) GetRequestProgress(ctx context.Context, code string) (*model.RequestProgress, error) {
+	if _, err := s.GetRequestHeader(ctx, code); err != nil {
+		return nil, err
+	}
+
+	// the domain which left the queue without the crawl is skipped
+	queued := requestQueued(s.dialect, "d")
+	skipped := "(d.checked_at is null or " + s.dialect.time("d.checked_at") + "<" + s.dialect.time("r.created_at") + ")"
+	progress := &model.RequestProgress{}
+	err := s.db.WithContext(ctx).Raw(`select count(*) as total,
+		coalesce(sum(case when `+queued+` then 1 else 0 end), 0) as queued,
+		coalesce(sum(case when not (`+queued+`) and d.response_code=@ok then 1 else 0 end), 0) as done,
+		coalesce(sum(case when not (`+queued+`) and d.response_code<>@ok and `+skipped+` then 1 else 0 end), 0) as skipped
+		from request_domains r
+		join domains d on d.id=r.domain_id and d.deleted_at is null
+		left join queues q on q.domain_id=r.domain_id and q.deleted_at is null
+		where r.code=@code`, map[string]interface{}{"ok": model.ResponseOk, "code": code}).
+		Scan(progress).Error
+	if err != nil {
+		return nil, err
+	}
+	progress.Failed = progress.Total - progress.Queued - progress.Done - progress.Skipped
+	return progress, nil
+}
+
+// GetRequest returns the crawled domains of the request by id, the queued ones are added to the later pages
+func (s *Store) GetRequest(ctx context.Context, requestCode string, page model.Page) ([]model.Domain, *model.PageInfo, error) {
+	cursor, err := model.DecodeCursor(page.Cursor, model.CursorRequest)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err = s.GetRequestHeader(ctx, requestCode); err != nil {
+		return nil, nil, err
+	}
+
+	crawled := func() *gorm.DB {
+		return s.db.WithContext(ctx).Model(&model.Domain{}).
+			Joins("join request_domains r on domains.id=r.domain_id").
+			Joins("left join queues q on q.domain_id=r.domain_id and q.deleted_at is null").
+			Where("r.code=?", requestCode).
+			Where("not (" + requestQueued(s.dialect, "domains") + ")")
+	}
+	info := &model.PageInfo{}
+	if err = crawled().Count(&info.Total).Error; err != nil {
+		return nil, nil, err
+	}
+
+	tx := crawled().Preload("Languages").Order("domains.id")
+	if cursor != nil {
+		tx = tx.Where("domains.id>?", cursor.ID)
+	}
+	if page.Limit > 0 {
+		tx = tx.Limit(page.Limit + 1)
+	}
+	domains := make([]model.Domain, 0)
+	if err = tx.Find(&domains).Error; err != nil {
+		return nil, nil, err
+	}
+	if page.Limit > 0 && len(domains) > page.Limit {
+		domains = domains[:page.Limit]
+		info.NextCursor = model.Cursor{Sort: model.CursorRequest, ID: domains[page.Limit-1].ID}.Encode()
+	}
+	return domains, info, nil
+}
+
+// GetCompletedRequests returns the pending requests with the domain which have no queued domains
+func (s *Store) GetCompletedRequests(ctx context.Context, domain model.Domain) ([]model.RequestHeader, error) {
+	requests := make([]model.RequestHeader, 0)
+	err := s.db.WithContext(ctx).
+		Where("status=?", model.RequestPending).
+		Where("code in (select code from request_domains where domain_id=?)", domain.ID).
+		Where(requestCompleted(s.dialect)).
+		Order("code").
+		Find(&requests).Error
+	return requests, err
+}
+
+// FinishRequest moves the pending request to the final status, false if it is not pending anymore
+func (s *Store) FinishRequest(ctx context.Context, code, status string) (bool, error) {
+	res := s.db.WithContext(ctx).Model(&model.RequestHeader{}).
+		Where("code=? and status=?", code, model.RequestPending).
+		Update("status", status)
+	return res.RowsAffected > 0, res.Error
+}
+
+// oldRequests returns at most limit codes of the requests made before the time
+// from the oldest one, the expired or the live ones
+func (s *Store) oldRequests(tx *gorm.DB, expired bool, before time.Time, limit int) ([]string, error) {
+	condition := "status<>?"
+	if expired {
+		condition = "status=?"
+	}
+	tx = tx.Model(&model.RequestHeader{}).
+		Where(condition, model.RequestExpired).
+		Where(s.dialect.time("created_at")+"<"+s.dialect.time("?"), before).
+		Order("created_at")
+	if limit > 0 {
+		tx = tx.Limit(limit)
+	}
+	codes := make([]string, 0)
+	err := tx.Pluck("code", &codes).Error
+	return codes, err
+}
+
+func (s *Store) ExpireRequests(ctx context.Context, before time.Time, limit int) (int64, error) {
+	var expired int64
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		codes, err := s.oldRequests(tx, false, before, limit)
+		if err != nil || len(codes) == 0 {
+			return err
+		}
+		if err = tx.Where("code in ?", codes).Delete(&model.Request{}).Error; err != nil {
+			return err
+		}
+		res := tx.Model(&model.RequestHeader{}).
+			Where("code in ?", codes).
+			Updates(map[string]interface{}{"status": model.RequestExpired, "callback": ""})
+		expired = res.RowsAffected
+		return res.Error
+	})
+	return expired, err
+}
+
+func (s *Store) DeleteExpiredRequests(ctx context.Context, before time.Time, limit int) (int64, error) {
+	var deleted int64
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		codes, err := s.oldRequests(tx, true, before, limit)
+		if err != nil || len(codes) == 0 {
+			return err
+		}
+		res := tx.Where("code in ?", codes).Delete(&model.RequestHeader{})
+		deleted = res.RowsAffected
+		return res.Error
+	})
+	return deleted, err
+}
+
+func (s *Store) StopRequest(ctx context.Context, code, status string) (int64, error) {
+	var removed int64
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&model.RequestHeader{}).
+			Where("code=? and status in ?", code, []string{model.RequestPending, model.RequestIngesting}).
+			Update("status", status)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			if err := tx.Where("code=?", code).First(&model.RequestHeader{}).Error; err != nil {
+				return err
+			}
+			return model.ErrRequestFinished
+		}
+
+		// the leased domains are being crawled already
+		res = tx.Exec(`delete from queues
+			where domain_id in (select r.domain_id from request_domains r
+				join domains d on d.id=r.domain_id
+				join queues q on q.domain_id=r.domain_id
+				where r.code=@code and `+requestQueued(s.dialect, "d")+`)
+			and (lease_until is null or `+s.dialect.time("lease_until")+"<"+s.dialect.time("@now")+`)
+			and not exists (select 1 from request_domains o
+				join requests h on h.code=o.code
+				where o.domain_id=queues.domain_id and o.code<>@code and h.status=@pending)`,
+			map[string]interface{}{"code": code, "now": time.Now(), "pending": model.RequestPending})
+		removed = res.RowsAffected
+		return res.Error
+	})
+	return removed, err
+}
+
+func (s *Store) GetOverdueRequests(ctx context.Context, now time.Time, limit int) ([]model.RequestHeader, error) {
+	tx := s.db.WithContext(ctx).
+		Where("status=? and deadline is not null", model.RequestPending).
+		Where(s.dialect.time("deadline")+"<"+s.dialect.time("?"), now).
+		Order("deadline")
+	if limit > 0 {
+		tx = tx.Limit(limit)
+	}
+	requests := make([]model.RequestHeader, 0)
+	err := tx.Find(&requests).Error
+	return requests, err
+}
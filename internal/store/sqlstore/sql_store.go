@@ -1,71 +1,138 @@
 package sqlstore
 
 import (
-	"crypto/md5"
-	"errors"
-	"fmt"
+	"context"
+	"github.com/glebarez/sqlite"
 	_ "github.com/lib/pq" //nolint:goimports
-	"github.com/sirupsen/logrus"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 	"restapi_langparser/internal/model"
 	"restapi_langparser/internal/store"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 )
 
+// Lane conditions of the queue. Each one is completed by the join of the
+// domains with the queue in front, see dialect.laneQuery. Rows leased by alive
+// workers are skipped, the expired leases are taken again. {due} is replaced
+// by the check of the update time.
 const (
-	BadDomain = `select d.* from domains d
-					left join queues q on d.id=q.domain_id
-					left join requests r on d.id=r.domain_id
-					where not q.domain_id isnull
-					and d.response_code != ''
-					and q.update_at<now()
-					and q.deleted_at isnull
-					order by q.update_at
-					limit 1`
-	UserRequest = `select d.* from domains d
-					left join queues q on d.id=q.domain_id
-					left join requests r on d.id=r.domain_id
-					where not q.domain_id isnull
-					and not r.code isnull
-					and d.response_code=''
-					and q.deleted_at isnull
-					order by q.update_at
-					limit 1`
-	Queue = `select d.* from domains d
-					left join queues q on d.id=q.domain_id
-					left join requests r on d.id=r.domain_id
-					where not q.domain_id isnull
-					and d.response_code=''
-					and q.update_at<now()
-					and r.code isnull
-					and q.deleted_at isnull
-					order by q.update_at
-					limit 1`
+	BadDomain = `d.response_code != ''
+					and d.response_code != 'ok'
+					and {due}`
+	UserRequest = `exists (select 1 from request_domains r where r.domain_id=d.id)
+					and (d.response_code='' or d.response_code='ok')
+					and not q.refresh`
+	Queue = `d.response_code=''
+					and {due}
+					and not exists (select 1 from request_domains r where r.domain_id=d.id)`
+	Refresh = `q.refresh
+					and d.response_code='ok'
+					and {due}`
 )
 
+var lanes = map[model.QueueLane]string{
+	model.LaneUserRequest: UserRequest,
+	model.LaneQueue:       Queue,
+	model.LaneBadDomain:   BadDomain,
+	model.LaneRefresh:     Refresh,
+}
+
+// restoreDeleted skips the known hosts of the inserted domains, the soft
+// deleted domains of the hosts are restored
+var restoreDeleted = clause.OnConflict{
+	Columns:   []clause.Column{{Name: "host"}},
+	DoUpdates: clause.Assignments(map[string]interface{}{"deleted_at": nil}),
+	Where:     clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "domains.deleted_at is not null"}}},
+}
+
 type Store struct {
 	db               *gorm.DB
+	dialect          dialect
 	ProxyRepository  store.IProxyRepository
 	DomainRepository store.IDomainRepository
-	callbacks        map[string]string
+	WatchlistRepo    store.IWatchlistRepository
+	APIKeyRepo       store.IAPIKeyRepository
 	m                sync.Mutex
+	queueEvents      chan struct{}
+	listenOnce       sync.Once
 }
 
 func New(db *gorm.DB) store.IStore {
 	return &Store{
 		db:               db,
+		dialect:          dialectOf(db),
 		DomainRepository: NewDomainRepository(db),
 		ProxyRepository:  NewProxyRepository(db),
-		callbacks:        make(map[string]string),
+		WatchlistRepo:    NewWatchlistRepository(db),
+		APIKeyRepo:       NewAPIKeyRepository(db),
+		queueEvents:      make(chan struct{}, 1),
+	}
+}
+
+// Open connects to the database shared by the api server and the workers and
+// applies the pending migrations
+func Open(databaseURL string) (*gorm.DB, error) {
+	db, err := Connect(databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	return db, migrateOnStart(db)
+}
+
+// Connect connects to the Postgres database without migrating it
+func Connect(databaseURL string) (*gorm.DB, error) {
+	if databaseURL == "" {
+		databaseURL = "user=postgres dbname=postgres password=password sslmode=disable"
+	}
+	return gorm.Open(postgres.New(postgres.Config{
+		DSN:                  databaseURL,
+		PreferSimpleProtocol: true, // disables implicit prepared statement usage
+	}), &gorm.Config{})
+}
+
+// OpenSQLite opens the database file of the single node deployment and applies
+// the pending migrations
+func OpenSQLite(path string) (*gorm.DB, error) {
+	db, err := ConnectSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	return db, migrateOnStart(db)
+}
+
+// ConnectSQLite opens the database file without migrating it
+func ConnectSQLite(path string) (*gorm.DB, error) {
+	if path == "" {
+		path = "langparser.db"
+	}
+	db, err := gorm.Open(sqlite.Open(path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	// SQLite has one writer, the single connection avoids "database is locked"
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// migrateOnStart refuses the schema of a newer application, otherwise brings it up to date
+func migrateOnStart(db *gorm.DB) error {
+	m, err := NewMigrator(db)
+	if err != nil {
+		return err
 	}
+	return m.Up(context.Background(), 0)
 }
 
 func (s *Store) Migrate() error {
-	m := s.db.Migrator()
-	return m.AutoMigrate(&model.Proxy{}, &model.Domain{}, &model.Request{}, &model.Queue{})
+	return migrateOnStart(s.db)
 }
 
 func (s *Store) Proxy() store.IProxyRepository {
@@ -76,21 +143,34 @@ func (s *Store) Domain() store.IDomainRepository {
 	return s.DomainRepository
 }
 
+func (s *Store) Watchlist() store.IWatchlistRepository {
+	return s.WatchlistRepo
+}
+
+func (s *Store) APIKey() store.IAPIKeyRepository {
+	return s.APIKeyRepo
+}
+
 // AddDomains create new domains and add it to queue
-func (s *Store) AddDomains(list *[]model.Domain) error {
+func (s *Store) AddDomains(ctx context.Context, list *[]model.Domain) error {
 	s.m.Lock()
 	defer s.m.Unlock()
 
-	err := s.db.
-		Clauses(clause.OnConflict{
-			Columns: []clause.Column{{Name: "host"}},
-			//DoUpdates: clause.AssignmentColumns([]string{"host"}),
-			DoNothing: true,
-		}).Clauses(clause.Returning{}).Create(&list).Error
+	err := s.db.WithContext(ctx).
+		Clauses(restoreDeleted).Create(list).Error
 	if err != nil {
 		return err
 	}
 
+	// conflicting rows are not returned, read all of them
+	hosts := make([]string, len(*list))
+	for i, domain := range *list {
+		hosts[i] = domain.Host
+	}
+	if err = s.db.WithContext(ctx).Where("host in ?", hosts).Order("id").Find(list).Error; err != nil {
+		return err
+	}
+
 	// add to queue
 	queue := make([]model.Queue, 0)
 	for _, td := range *list { // exclude processed domains
@@ -105,15 +185,21 @@ func (s *Store) AddDomains(list *[]model.Domain) error {
 	if len(queue) == 0 {
 		return nil
 	}
-	return s.db.Clauses(clause.OnConflict{
+	err = s.db.WithContext(ctx).Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "domain_id"}},
-		UpdateAll: true,
+		DoUpdates: clause.AssignmentColumns([]string{"update_at", "deleted_at"}),
 	}).Create(&queue).Error
+	if err != nil {
+		return err
+	}
+
+	s.notifyQueue(ctx)
+	return nil
 }
 
-func (s *Store) GetDomains(hosts []string) ([]model.Domain, error) {
+func (s *Store) GetDomains(ctx context.Context, hosts []string) ([]model.Domain, error) {
 	var domains []model.Domain
-	err := s.db.Joins("left join queues on queues.domain_id=domains.id").Where("host in ?", hosts).Where("queues.domain_id isnull").Find(&domains).Error
+	err := s.db.WithContext(ctx).Preload("Languages").Joins("left join queues on queues.domain_id=domains.id").Where("host in ?", hosts).Where("queues.domain_id is null").Find(&domains).Error
 	if err != nil {
 		return nil, err
 	}
@@ -124,128 +210,191 @@ func (s *Store) GetDomains(hosts []string) ([]model.Domain, error) {
 }
 
 // SaveDomain
-func (s *Store) SaveDomain(domain model.Domain) error {
-	return s.db.Save(&domain).Error
-}
-
-func (s *Store) GetCompletedRequests(domain model.Domain) ([]string, error) {
-	var codes []string
-	err := s.db.Raw(`select r.code from requests r
-		left join queues q on r.domain_id=q.domain_id
-		left join domains d on d.id=r.domain_id
-		left join (select code from requests where domain_id=?) c on c.code=r.code
-		group by r.code
-		having count(q.domain_id)=0`, domain.ID).Scan(&codes).Error
-	return codes, err
-}
-
-func (s *Store) GetCallbacks(codes []string) map[string]string {
-	res := make(map[string]string)
-	for _, code := range codes {
-		if callback, exist := s.callbacks[code]; exist {
-			res[code] = callback
-		}
-	}
-	return res
+func (s *Store) SaveDomain(ctx context.Context, domain model.Domain) error {
+	return s.DomainRepository.Update(ctx, domain)
 }
 
-//todo remove it
-func (s *Store) Test() {
-	s.callbacks["fddc1482c9d29f47961855ec9bacc9aa"] = "localhost:8080/echo"
-	testDomain := model.Domain{}
-	testDomain.ID = 1
-	callbacks, err := s.GetCompletedRequests(testDomain)
+// LeaseFromQueue marks the first due row of the lane as taken by the worker.
+// Rows locked by concurrent transactions are skipped, so several workers never
+// get the same domain.
+func (s *Store) LeaseFromQueue(ctx context.Context, lane model.QueueLane, workerID string, ttl time.Duration) (*model.Domain, error) {
+	query, err := s.dialect.laneQuery(lane)
 	if err != nil {
-		logrus.Error(err)
-		return
+		return nil, err
 	}
-	logrus.Infof("found callbacks: %v", callbacks)
-}
 
-func (s *Store) GetFromQueue(priority string) *model.Domain {
-	var domain model.Domain
+	// the lease is compared by the ack, so it has the precision of the database
+	now := time.Now()
+	until := now.Add(ttl).Truncate(time.Microsecond)
+	var domainIDs []uint
+	err = s.db.WithContext(ctx).Raw(`update queues set worker_id=@worker, lease_until=@until
+		where id = (select q.id `+query+` order by `+s.dialect.time("q.update_at")+`, q.id limit 1`+s.dialect.lockRow+`)
+		returning domain_id`, map[string]interface{}{"worker": workerID, "until": until, "now": now}).
+		Scan(&domainIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(domainIDs) == 0 {
+		return nil, nil
+	}
 
-	err := s.db.Raw(priority).Scan(&domain).Error
-	if err != nil || domain.ID == 0 {
-		return nil
+	domain := &model.Domain{}
+	if err = s.db.WithContext(ctx).Preload("Languages").First(domain, domainIDs[0]).Error; err != nil {
+		return nil, err
 	}
+	domain.Queue = model.Queue{DomainID: domain.ID, WorkerID: workerID, LeaseUntil: &until}
+	return domain, nil
+}
 
-	err = s.db.Where("domain_id=?", domain.ID).Delete(&model.Queue{}).Error
+func (s *Store) QueueDepth(ctx context.Context, lane model.QueueLane) (int64, error) {
+	query, err := s.dialect.laneQuery(lane)
 	if err != nil {
-		return nil
+		return 0, err
 	}
 
-	return &domain
+	var cnt int64
+	err = s.db.WithContext(ctx).Raw("select count(d.id) "+query, map[string]interface{}{"now": time.Now()}).Scan(&cnt).Error
+	return cnt, err
 }
 
-func (s *Store) AddToQueue(updateAt time.Time, list ...model.Domain) error {
+func (s *Store) AddToQueue(ctx context.Context, updateAt time.Time, list ...model.Domain) error {
+	if len(list) == 0 {
+		return nil
+	}
 	q := make([]model.Queue, len(list))
 	for i, domain := range list {
 		q[i].DomainID = domain.ID
 		q[i].UpdateAt = updateAt
 	}
-	return s.db.Clauses(clause.OnConflict{
+	err := s.db.WithContext(ctx).Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "domain_id"}},
 		DoNothing: true,
 	}).Create(&q).Error
-}
+	if err != nil {
+		return err
+	}
 
-func (s *Store) ReturnToQueue(updateAt time.Time, domain model.Domain) error {
-	return s.db.
-		Unscoped().
-		Model(&model.Queue{}).
-		Where("domain_id", domain.ID).
-		Updates(map[string]interface{}{"deleted_at": nil, "update_at": updateAt}).
-		Error
+	s.notifyQueue(ctx)
+	return nil
 }
 
-func (s *Store) RemoveFromQueue(domain model.Domain) error {
-	return s.db.
-		Unscoped().
-		Where("domain_id=?", domain.ID).
-		Delete(&model.Queue{}).
-		Error
+// RefreshDomains queues the domains for the crawl even if they are done. The
+// background crawl waits for the other lanes, otherwise it is the user request.
+func (s *Store) RefreshDomains(ctx context.Context, list []model.Domain, background bool) error {
+	if len(list) == 0 {
+		return nil
+	}
+	q := make([]model.Queue, len(list))
+	for i, domain := range list {
+		q[i].DomainID = domain.ID
+		q[i].UpdateAt = time.Now()
+		q[i].Refresh = background
+	}
+	// the background refresh does not lower the priority of the queued user request
+	updates := append(
+		clause.AssignmentColumns([]string{"update_at", "deleted_at"}),
+		clause.Assignment{Column: clause.Column{Name: "refresh"}, Value: gorm.Expr("queues.refresh and excluded.refresh")},
+	)
+	err := s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "domain_id"}},
+		DoUpdates: updates,
+	}).Create(&q).Error
+	if err != nil {
+		return err
+	}
+
+	s.notifyQueue(ctx)
+	return nil
 }
 
-func createRequestCode(list []model.Domain) string {
+// RequeueStale queues at most limit successfully crawled domains which are older than their max age.
+// The max age is taken from the domain itself, then from its group, then the default one.
+func (s *Store) RequeueStale(ctx context.Context, maxAge time.Duration, groupMaxAge map[string]time.Duration, limit int) (int64, error) {
+	groups := make([]string, 0, len(groupMaxAge))
+	for group := range groupMaxAge {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	now := time.Now()
 	sb := strings.Builder{}
-	for _, domain := range list {
-		sb.WriteString(domain.Host)
+	args := make([]interface{}, 0, len(groups)*2+6)
+	args = append(args, now, now, now, now)
+	sb.WriteString("nullif(case when d.max_age>0 then d.max_age")
+	for _, group := range groups {
+		sb.WriteString(" when d.refresh_group=? then ?")
+		args = append(args, group, int64(groupMaxAge[group].Seconds()))
 	}
-	return fmt.Sprintf("%x", md5.Sum([]byte(sb.String())))
-}
+	sb.WriteString(" else ? end, 0)")
+	args = append(args, int64(maxAge.Seconds()))
 
-func (s *Store) CreateRequest(list []model.Domain, callback *string) (requestCode string, err error) {
-	requestCode = createRequestCode(list)
-	if callback != nil {
-		s.callbacks[requestCode] = *callback
+	query := `insert into queues (created_at, updated_at, domain_id, update_at, refresh)
+		select ?, ?, d.id, ?, true from domains d
+		where d.response_code='ok'
+		and d.deleted_at is null
+		and not exists (select 1 from queues q where q.domain_id=d.id)
+		and ` + s.dialect.olderThan("coalesce(d.checked_at, d.updated_at)", "?", sb.String()) + `
+		order by d.id`
+	if limit > 0 {
+		query += " limit ?"
+		args = append(args, limit)
 	}
-	request := make([]model.Request, len(list))
-	for i, domain := range list {
-		request[i] = model.Request{
-			DomainID: domain.ID,
-			Code:     requestCode,
-		}
+
+	res := s.db.WithContext(ctx).Exec(query+" on conflict (domain_id) do nothing", args...)
+	if res.Error != nil {
+		return 0, res.Error
 	}
 
-	return requestCode, s.db.Clauses(clause.OnConflict{
-		Columns: []clause.Column{
-			{Name: "domain_id"},
-			{Name: "code"},
-		},
-		DoNothing: true,
-	}).Create(&request).Error
+	if res.RowsAffected > 0 {
+		s.notifyQueue(ctx)
+	}
+	return res.RowsAffected, nil
 }
 
-func (s *Store) GetRequest(requestCode string) ([]model.Domain, error) {
-	var cnt int64
-	s.db.Model(&model.Request{}).Joins("left join queues on queues.domain_id=requests.domain_id where not queues.domain_id isnull and requests.code=?", requestCode).Count(&cnt)
-	if cnt > 0 {
-		return nil, errors.New("not ready")
+// NextQueueUpdate returns the nearest time when a queued domain becomes due, zero time for the empty queue
+func (s *Store) NextQueueUpdate(ctx context.Context) (time.Time, error) {
+	var next []struct {
+		UpdateAt   time.Time
+		LeaseUntil *time.Time
 	}
+	err := s.db.WithContext(ctx).Raw(`select update_at, lease_until from queues
+		where deleted_at is null
+		order by ` + s.dialect.greatest("update_at", "coalesce(lease_until, update_at)") + `
+		limit 1`).Scan(&next).Error
+	if err != nil || len(next) == 0 {
+		return time.Time{}, err
+	}
+	if next[0].LeaseUntil != nil && next[0].LeaseUntil.After(next[0].UpdateAt) {
+		return *next[0].LeaseUntil, nil
+	}
+	return next[0].UpdateAt, nil
+}
 
-	var domains []model.Domain
-	s.db.Joins("left join requests on domains.id=requests.domain_id").Where("requests.code=?", requestCode).Find(&domains)
+func (s *Store) ReturnToQueue(ctx context.Context, updateAt time.Time, domain model.Domain) error {
+	res := s.leased(ctx, domain).
+		Updates(map[string]interface{}{"deleted_at": nil, "update_at": updateAt, "worker_id": "", "lease_until": nil})
+	return leaseResult(res, domain)
+}
 
-	return domains, nil
+func (s *Store) RemoveFromQueue(ctx context.Context, domain model.Domain) error {
+	res := s.leased(ctx, domain).Delete(&model.Queue{})
+	return leaseResult(res, domain)
+}
+
+// leased selects the queue row of the domain while it has the lease of the
+// domain, any row of the domain which was not leased
+func (s *Store) leased(ctx context.Context, domain model.Domain) *gorm.DB {
+	db := s.db.WithContext(ctx).Unscoped().Model(&model.Queue{}).Where("domain_id=?", domain.ID)
+	if lease := domain.Queue; lease.LeaseUntil != nil {
+		db = db.Where("worker_id=? and lease_until=?", lease.WorkerID, *lease.LeaseUntil)
+	}
+	return db
+}
+
+// leaseResult returns model.ErrLeaseLost when the leased row was not found
+func leaseResult(res *gorm.DB, domain model.Domain) error {
+	if res.Error == nil && res.RowsAffected == 0 && domain.Queue.LeaseUntil != nil {
+		return model.ErrLeaseLost
+	}
+	return res.Error
 }
@@ -2,6 +2,9 @@ package sqlstore_test
 
 import (
 	"os"
+	"restapi_langparser/internal/store"
+	"restapi_langparser/internal/store/sqlstore"
+	"restapi_langparser/internal/store/storetest"
 	"testing"
 )
 
@@ -11,8 +14,18 @@ var (
 
 func TestMain(m *testing.M) {
 	databaseURL = os.Getenv("DATABASE_URL")
+	os.Exit(m.Run())
+}
+
+func TestStore(t *testing.T) {
 	if databaseURL == "" {
-		databaseURL = "host=localhost dbname=restapi_test sslmode=disable user=akai password=password"
+		t.Skip("DATABASE_URL is not set")
 	}
-	os.Exit(m.Run())
+	storetest.Run(t, func(t *testing.T) store.IStore {
+		return sqlstore.TestStore(t, databaseURL)
+	})
+}
+
+func TestSQLiteStore(t *testing.T) {
+	storetest.Run(t, sqlstore.TestSQLiteStore)
 }
@@ -3,10 +3,15 @@ package sqlstore
 import (
 	"database/sql"
 	"fmt"
+	"path/filepath"
+	"restapi_langparser/internal/store"
 	"strings"
 	"testing"
 )
 
+// tables of the store, the link tables go first
+var tables = []string{"watchlist_domains", "watchlists", "domain_histories", "domain_languages", "request_domains", "requests", "queues", "workers", "proxies", "domains", "api_key_usage", "api_keys"}
+
 func TestDB(t *testing.T, databaseURL string) (*sql.DB, func(...string)) {
 	t.Helper()
 	db, err := sql.Open("postgres", databaseURL)
@@ -23,3 +28,40 @@ func TestDB(t *testing.T, databaseURL string) (*sql.DB, func(...string)) {
 		db.Close()
 	}
 }
+
+// TestStore opens the store on the empty database, the tables are truncated again after the test
+func TestStore(t *testing.T, databaseURL string) store.IStore {
+	t.Helper()
+	db, err := Open(databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncate := func() error {
+		return db.Exec(fmt.Sprintf("TRUNCATE %s RESTART IDENTITY CASCADE", strings.Join(tables, ", "))).Error
+	}
+	if err = truncate(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		truncate() //nolint:errcheck
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return New(db)
+}
+
+// TestSQLiteStore opens the store on a new database file removed after the test
+func TestSQLiteStore(t *testing.T) store.IStore {
+	t.Helper()
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return New(db)
+}
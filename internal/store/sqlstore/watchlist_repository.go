@@ -0,0 +1,60 @@
+package sqlstore
+
+import (
+	"context"
+	"restapi_langparser/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type WatchlistRepository struct {
+	db *gorm.DB
+}
+
+func NewWatchlistRepository(db *gorm.DB) *WatchlistRepository {
+	return &WatchlistRepository{
+		db: db,
+	}
+}
+
+func (w *WatchlistRepository) Create(ctx context.Context, watchlist *model.Watchlist) error {
+	return conflict(w.db.WithContext(ctx).Create(watchlist).Error)
+}
+
+func (w *WatchlistRepository) Read(ctx context.Context) ([]model.Watchlist, error) {
+	lists := make([]model.Watchlist, 0)
+	err := w.db.WithContext(ctx).Order("id").Find(&lists).Error
+	return lists, err
+}
+
+func (w *WatchlistRepository) Delete(ctx context.Context, id uint) error {
+	return w.db.WithContext(ctx).Select("Domains").Delete(&model.Watchlist{ID: id}).Error
+}
+
+func (w *WatchlistRepository) FindByID(ctx context.Context, id uint) (*model.Watchlist, error) {
+	watchlist := &model.Watchlist{}
+	err := w.db.WithContext(ctx).Preload("Domains.Languages").First(watchlist, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return watchlist, nil
+}
+
+func (w *WatchlistRepository) FindByDomain(ctx context.Context, domainID uint) ([]model.Watchlist, error) {
+	lists := make([]model.Watchlist, 0)
+	err := w.db.WithContext(ctx).
+		Joins("join watchlist_domains wd on wd.watchlist_id=watchlists.id").
+		Where("wd.domain_id=?", domainID).
+		Find(&lists).
+		Error
+	return lists, err
+}
+
+func (w *WatchlistRepository) AddDomains(ctx context.Context, id uint, domains []model.Domain) error {
+	return w.db.WithContext(ctx).Model(&model.Watchlist{ID: id}).Association("Domains").Append(domains)
+}
+
+func (w *WatchlistRepository) SetLastRun(ctx context.Context, id uint, at time.Time) error {
+	return w.db.WithContext(ctx).Model(&model.Watchlist{ID: id}).Update("last_run_at", at).Error
+}
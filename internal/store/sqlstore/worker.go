@@ -0,0 +1,26 @@
+package sqlstore
+
+import (
+	"context"
+	"restapi_langparser/internal/model"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+func (s *Store) Heartbeat(ctx context.Context, worker model.Worker) error {
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"host", "threads", "active", "heartbeat_at"}),
+	}).Create(&worker).Error
+}
+
+func (s *Store) GetWorkers(ctx context.Context, aliveSince time.Time) ([]model.Worker, error) {
+	workers := make([]model.Worker, 0)
+	err := s.db.WithContext(ctx).Where(s.dialect.time("heartbeat_at")+">"+s.dialect.time("?"), aliveSince).Order("id").Find(&workers).Error
+	return workers, err
+}
+
+func (s *Store) RemoveWorker(ctx context.Context, id string) error {
+	return s.db.WithContext(ctx).Delete(&model.Worker{}, "id=?", id).Error
+}
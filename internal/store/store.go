@@ -1,32 +1,77 @@
 package store
 
 import (
+	"context"
+	"errors"
 	"restapi_langparser/internal/model"
 	"time"
 )
 
+var (
+	// ErrConflict is returned when the unique host, name or key is taken by another row
+	ErrConflict = errors.New("already exists")
+	// ErrQuotaExceeded is returned when the hosts are over the daily quota of the key
+	ErrQuotaExceeded = errors.New("daily host quota exceeded")
+)
+
 type IProxyRepository interface {
-	Create(list []model.Proxy) error
-	Read(limit, offset int) ([]model.Proxy, error)
-	Update(list []model.Proxy) error
-	Delete(ids []int) error
+	Create(ctx context.Context, list []model.Proxy) error
+	// Read returns the page of the proxies ordered by id
+	Read(ctx context.Context, page model.Page) ([]model.Proxy, *model.PageInfo, error)
+	Update(ctx context.Context, list []model.Proxy) error
+	Delete(ctx context.Context, ids []int) error
+	FindByID(ctx context.Context, id int64) (*model.Proxy, error)
 }
 
 type IDomainRepository interface {
-	Create(domains ...model.Domain) error
-	Read(limit, offset int) ([]model.Domain, error)
-	Update(target model.Domain) error
-	Delete(target ...model.Domain) error
-
-	FindByID(id int) (*model.Domain, error)
-	FindByTagLang(lang string) ([]model.Domain, error)
-	FindBySMLang(lang string) ([]model.Domain, error)
-	FindByContentLang(lang string) ([]model.Domain, error)
-	FindByHost(hosts ...string) ([]model.Domain, error)
-	GetUserRequest() (*model.Domain, error)
-	GetErrorHost() (*model.Domain, error)
-	GetListHost() (*model.Domain, error)
-	CreateWithHost(hosts ...string) ([]model.Domain, error)
+	Create(ctx context.Context, domains ...model.Domain) error
+	// Read returns the page of the domains ordered by id
+	Read(ctx context.Context, page model.Page) ([]model.Domain, *model.PageInfo, error)
+	Update(ctx context.Context, target model.Domain) error
+	// Delete hard deletes the domains with their queue, request, history and watchlist rows
+	Delete(ctx context.Context, target ...model.Domain) error
+
+	FindByID(ctx context.Context, id int) (*model.Domain, error)
+	FindByTagLang(ctx context.Context, lang string) ([]model.Domain, error)
+	FindBySMLang(ctx context.Context, lang string) ([]model.Domain, error)
+	FindByContentLang(ctx context.Context, lang string) ([]model.Domain, error)
+	FindByHost(ctx context.Context, hosts ...string) ([]model.Domain, error)
+	// Search returns the page of the domains matching all filters in the order of the sort key
+	Search(ctx context.Context, filter model.DomainFilter, page model.Page) ([]model.Domain, *model.PageInfo, error)
+	// Export calls fn for each domain matching the filter in the order of id, with the request code
+	// only the crawled domains of the request are exported. The domains are read from one cursor, so
+	// fn must not use the store.
+	Export(ctx context.Context, filter model.DomainFilter, requestCode string, fn func(model.Domain) error) error
+	// CountByLang returns the number of domains per language and source, the most common first
+	CountByLang(ctx context.Context) ([]model.LanguageCount, error)
+	CreateWithHost(ctx context.Context, hosts ...string) ([]model.Domain, error)
+}
+
+type IWatchlistRepository interface {
+	Create(ctx context.Context, watchlist *model.Watchlist) error
+	Read(ctx context.Context) ([]model.Watchlist, error)
+	Delete(ctx context.Context, id uint) error
+
+	// FindByID returns the watchlist with its domains
+	FindByID(ctx context.Context, id uint) (*model.Watchlist, error)
+	FindByDomain(ctx context.Context, domainID uint) ([]model.Watchlist, error)
+	AddDomains(ctx context.Context, id uint, domains []model.Domain) error
+	SetLastRun(ctx context.Context, id uint, at time.Time) error
+}
+
+type IAPIKeyRepository interface {
+	Create(ctx context.Context, key *model.APIKey) error
+	// Read returns all keys ordered by id, the revoked ones too
+	Read(ctx context.Context) ([]model.APIKey, error)
+	// FindByHash returns the active key by the hash of its secret, gorm.ErrRecordNotFound for the unknown or revoked one
+	FindByHash(ctx context.Context, hash string) (*model.APIKey, error)
+	// Revoke disables the key, gorm.ErrRecordNotFound for the unknown one
+	Revoke(ctx context.Context, id uint, at time.Time) error
+	// AddUsage counts the hosts submitted by the key on the day unless the count goes over the limit,
+	// 0 is unlimited. It returns false and counts nothing when the hosts are over the limit.
+	AddUsage(ctx context.Context, id uint, day string, hosts, limit int) (bool, error)
+	// Usage returns the number of the hosts submitted by the key on the day
+	Usage(ctx context.Context, id uint, day string) (int, error)
 }
 
 type IStore interface {
@@ -34,21 +79,83 @@ type IStore interface {
 
 	Proxy() IProxyRepository
 	Domain() IDomainRepository
+	Watchlist() IWatchlistRepository
+	APIKey() IAPIKeyRepository
 
-	// AddDomains create new domains and add it to queue
-	AddDomains(list *[]model.Domain) error
-	GetDomains(hosts []string) ([]model.Domain, error)
-	GetFromQueue(priority string) *model.Domain
-	SaveDomain(domain model.Domain) error
-	CreateRequest(list []model.Domain, callback *string) (requestCode string, err error)
-	GetRequest(requestCode string) ([]model.Domain, error)
-
-	GetCompletedRequests(domain model.Domain) ([]string, error)
-	GetCallbacks(codes []string) map[string]string
+	// AddDomains create new domains and add it to queue. The list is replaced by
+	// the stored domains, processed domains are not queued again.
+	AddDomains(ctx context.Context, list *[]model.Domain) error
+	// GetDomains returns the domains if all of them are known and not queued, nil otherwise
+	GetDomains(ctx context.Context, hosts []string) ([]model.Domain, error)
+	// LeaseFromQueue takes the next due domain of the lane for the worker, nil if the lane is empty.
+	// The domain stays in the queue until RemoveFromQueue or ReturnToQueue, or until the lease expires.
+	// The lease is kept in the Queue of the domain.
+	LeaseFromQueue(ctx context.Context, lane model.QueueLane, workerID string, ttl time.Duration) (*model.Domain, error)
+	QueueDepth(ctx context.Context, lane model.QueueLane) (int64, error)
+	SaveDomain(ctx context.Context, domain model.Domain) error
+	// DeleteDomains deletes the domains with their queue, request, history and watchlist rows in one
+	// transaction. The soft deleted domains are hidden until their hosts are added again. The host
+	// counts of the requests with the domains are decreased, the pending ones left without queued
+	// domains are returned to be finished.
+	DeleteDomains(ctx context.Context, ids []uint, hard bool) ([]model.RequestHeader, error)
+	// SaveCrawlResult updates the domain and adds the crawl to its history
+	SaveCrawlResult(ctx context.Context, domain model.Domain, history model.DomainHistory) error
+	// GetHistory returns crawls of the domain from the latest one
+	GetHistory(ctx context.Context, domainID uint, page model.Page) ([]model.DomainHistory, *model.PageInfo, error)
+	GetHistoryRecord(ctx context.Context, domainID, id uint) (*model.DomainHistory, error)
+	// CreateRequest saves the submission of the domains under a new random code,
+	// the code, time, status and host count of the request are set by the store.
+	// The ingesting request keeps its status until FinishIngest.
+	CreateRequest(ctx context.Context, request model.RequestHeader, list []model.Domain) (*model.RequestHeader, error)
+	// GetRequestHeader returns the request, gorm.ErrRecordNotFound for the unknown code
+	GetRequestHeader(ctx context.Context, code string) (*model.RequestHeader, error)
+	// GetRequestProgress counts the domains of the request by their state, gorm.ErrRecordNotFound for the unknown code
+	GetRequestProgress(ctx context.Context, code string) (*model.RequestProgress, error)
+	// GetRequest returns the crawled domains of the request, the queued ones are not ready yet
+	GetRequest(ctx context.Context, requestCode string, page model.Page) ([]model.Domain, *model.PageInfo, error)
+	// GetCompletedRequests returns the pending requests with the domain which have no queued domains
+	GetCompletedRequests(ctx context.Context, domain model.Domain) ([]model.RequestHeader, error)
+	// FinishRequest moves the pending request to the final status, false if it is not pending anymore
+	FinishRequest(ctx context.Context, code, status string) (bool, error)
+	// IngestRequest adds the batches of the hosts to the ingesting request until next returns io.EOF.
+	// The new hosts are created, the ones without results are queued. Each batch is charged to the
+	// daily quota of the key with the batch, unless the key is nil or unlimited. It returns the number
+	// of the added hosts, model.ErrRequestFinished if the request is stopped meanwhile and
+	// ErrQuotaExceeded if the batch is over the quota, the batch is not added then.
+	IngestRequest(ctx context.Context, code string, key *model.APIKey, next func() ([]string, error)) (int64, error)
+	// FinishIngest moves the ingesting request to pending, or to done if none of its domains is queued
+	FinishIngest(ctx context.Context, code string, overBudget int) (string, error)
+	// StopRequest moves the pending or ingesting request to the status and removes its domains from the
+	// queue which are not crawled yet, unless another pending request needs them. It returns the number
+	// of removed domains, model.ErrRequestFinished if the request is finished already.
+	StopRequest(ctx context.Context, code, status string) (int64, error)
+	// GetOverdueRequests returns at most limit pending requests which passed their deadline before now
+	GetOverdueRequests(ctx context.Context, now time.Time, limit int) ([]model.RequestHeader, error)
+	// ExpireRequests expires at most limit requests made before the time from the oldest one. Their
+	// domain links and callbacks are deleted, the codes are kept to tell them from the unknown ones.
+	ExpireRequests(ctx context.Context, before time.Time, limit int) (int64, error)
+	// DeleteExpiredRequests deletes at most limit expired requests made before the time, their codes become unknown
+	DeleteExpiredRequests(ctx context.Context, before time.Time, limit int) (int64, error)
 
-	AddToQueue(updateAt time.Time, list ...model.Domain) error
-	ReturnToQueue(updateAt time.Time, domain model.Domain) error
-	RemoveFromQueue(domain model.Domain) error
+	AddToQueue(ctx context.Context, updateAt time.Time, list ...model.Domain) error
+	// ReturnToQueue releases the lease and schedules the domain to updateAt. The domain leased by
+	// LeaseFromQueue is released only while its lease is held, model.ErrLeaseLost otherwise.
+	ReturnToQueue(ctx context.Context, updateAt time.Time, domain model.Domain) error
+	// RemoveFromQueue acknowledges the domain as processed, the lease is checked like by ReturnToQueue
+	RemoveFromQueue(ctx context.Context, domain model.Domain) error
+	// RefreshDomains queues the domains for the crawl even if they are done. The
+	// background crawl waits for the other lanes, otherwise it is the user request.
+	RefreshDomains(ctx context.Context, list []model.Domain, background bool) error
+	// RequeueStale queues at most limit successfully crawled domains which are older than their max age, 0 means no limit
+	RequeueStale(ctx context.Context, maxAge time.Duration, groupMaxAge map[string]time.Duration, limit int) (int64, error)
+	// QueueNotify returns a channel signalled when domains are added to the queue
+	QueueNotify() <-chan struct{}
+	// NextQueueUpdate returns the nearest time when a queued domain becomes due, zero time for the empty queue
+	NextQueueUpdate(ctx context.Context) (time.Time, error)
 
-	Test()
+	// Heartbeat creates or refreshes the worker record
+	Heartbeat(ctx context.Context, worker model.Worker) error
+	// GetWorkers lists workers seen after aliveSince
+	GetWorkers(ctx context.Context, aliveSince time.Time) ([]model.Worker, error)
+	RemoveWorker(ctx context.Context, id string) error
 }
@@ -0,0 +1,1572 @@
+// Package storetest is the conformance suite every store.IStore backend has to pass.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"restapi_langparser/internal/model"
+	"restapi_langparser/internal/store"
+	"sort"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Factory returns an empty store for one test
+type Factory func(t *testing.T) store.IStore
+
+const leaseTTL = time.Minute
+
+// Run checks the backend against the semantics shared by all stores
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s store.IStore)
+	}{
+		{"domains", testDomains},
+		{"domain languages", testDomainLanguages},
+		{"domain search", testDomainSearch},
+		{"export", testExport},
+		{"proxies", testProxies},
+		{"add domains", testAddDomains},
+		{"queue lanes", testQueueLanes},
+		{"queue lane changes", testQueueLaneChanges},
+		{"queue lease", testQueueLease},
+		{"next queue update", testNextQueueUpdate},
+		{"refresh", testRefresh},
+		{"requeue stale", testRequeueStale},
+		{"requests", testRequests},
+		{"callbacks", testCallbacks},
+		{"completed requests", testCompletedRequests},
+		{"expired requests", testExpiredRequests},
+		{"stop requests", testStopRequests},
+		{"ingest requests", testIngestRequests},
+		{"history", testHistory},
+		{"workers", testWorkers},
+		{"delete domains", testDeleteDomains},
+		{"api keys", testAPIKeys},
+		{"conflicts", testConflicts},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			tc.test(t, newStore(t))
+		})
+	}
+}
+
+func hostsOf(domains []model.Domain) []string {
+	hosts := make([]string, len(domains))
+	for i, domain := range domains {
+		hosts[i] = domain.Host
+	}
+	return hosts
+}
+
+func assertHosts(t *testing.T, domains []model.Domain, hosts ...string) {
+	t.Helper()
+	if hosts == nil {
+		hosts = []string{}
+	}
+	if got := hostsOf(domains); !reflect.DeepEqual(got, hosts) {
+		t.Fatalf("hosts %v, want %v", got, hosts)
+	}
+}
+
+func mustCreate(t *testing.T, s store.IStore, hosts ...string) []model.Domain {
+	t.Helper()
+	domains, err := s.Domain().CreateWithHost(context.Background(), hosts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHosts(t, domains, hosts...)
+	return domains
+}
+
+func mustUpdate(t *testing.T, s store.IStore, domain model.Domain) {
+	t.Helper()
+	if err := s.Domain().Update(context.Background(), domain); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustQueue(t *testing.T, s store.IStore, updateAt time.Time, domains ...model.Domain) {
+	t.Helper()
+	if err := s.AddToQueue(context.Background(), updateAt, domains...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertDepth(t *testing.T, s store.IStore, lane model.QueueLane, want int64) {
+	t.Helper()
+	got, err := s.QueueDepth(context.Background(), lane)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("depth of %s lane %d, want %d", lane, got, want)
+	}
+}
+
+func assertLease(t *testing.T, s store.IStore, lane model.QueueLane, ttl time.Duration, host string) {
+	t.Helper()
+	domain, err := s.LeaseFromQueue(context.Background(), lane, "worker", ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch {
+	case domain == nil && host != "":
+		t.Fatalf("%s lane is empty, want %s", lane, host)
+	case domain != nil && domain.Host != host:
+		t.Fatalf("leased %s from %s lane, want %q", domain.Host, lane, host)
+	}
+}
+
+func assertTime(t *testing.T, got, want time.Time) {
+	t.Helper()
+	if d := got.Sub(want); d < -time.Millisecond || d > time.Millisecond {
+		t.Fatalf("time %s, want %s", got, want)
+	}
+}
+
+func testDomains(t *testing.T, s store.IStore) {
+	ctx := context.Background()
+	repo := s.Domain()
+	mustCreate(t, s, "https://a.example", "https://b.example")
+
+	if err := repo.Create(ctx, model.Domain{Host: "https://a.example"}); err != nil {
+		t.Fatalf("duplicate host: %s", err)
+	}
+	if err := repo.Create(ctx, model.Domain{}); err == nil {
+		t.Fatal("empty host is created")
+	}
+
+	domains, info, err := repo.Read(ctx, model.Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHosts(t, domains, "https://a.example", "https://b.example")
+	if info.Total != 2 || info.NextCursor != "" {
+		t.Fatalf("page info %+v", info)
+	}
+
+	domains, info, err = repo.Read(ctx, model.Page{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHosts(t, domains, "https://a.example")
+	if info.Total != 2 || info.NextCursor == "" {
+		t.Fatalf("page info %+v", info)
+	}
+	domains, info, err = repo.Read(ctx, model.Page{Limit: 1, Cursor: info.NextCursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHosts(t, domains, "https://b.example")
+	if info.NextCursor != "" {
+		t.Fatalf("cursor %q on the last page", info.NextCursor)
+	}
+
+	for _, cursor := range []string{"broken", model.Cursor{Sort: model.CursorProxy}.Encode()} {
+		if _, _, err = repo.Read(ctx, model.Page{Cursor: cursor}); !errors.Is(err, model.ErrInvalidCursor) {
+			t.Fatalf("cursor %q: %v", cursor, err)
+		}
+	}
+
+	found, err := repo.FindByHost(ctx, "https://b.example", "https://unknown.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHosts(t, found, "https://b.example")
+
+	byID, err := repo.FindByID(ctx, int(found[0].ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byID.Host != "https://b.example" {
+		t.Fatalf("found %s by id", byID.Host)
+	}
+	if _, err = repo.FindByID(ctx, int(found[0].ID)+100); err == nil {
+		t.Fatal("unknown id is found")
+	}
+
+	// update by host keeps the id, update by id keeps the host
+	mustUpdate(t, s, model.Domain{Host: "https://b.example", IP: "127.0.0.1"})
+	mustUpdate(t, s, model.Domain{Model: byID.Model, BlockerName: "cloudflare"})
+	byID, err = repo.FindByID(ctx, int(byID.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byID.Host != "https://b.example" || byID.BlockerName != "cloudflare" {
+		t.Fatalf("updated domain %+v", byID)
+	}
+
+	if err = repo.Delete(ctx, found...); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.FindByID(ctx, int(found[0].ID)); err == nil {
+		t.Fatal("deleted domain is found")
+	}
+	domains, _, err = repo.Read(ctx, model.Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHosts(t, domains, "https://a.example")
+}
+
+func testDomainLanguages(t *testing.T, s store.IStore) {
+	ctx := context.Background()
+	repo := s.Domain()
+	domains := mustCreate(t, s, "https://a.example", "https://b.example")
+
+	domains[0].TagsLanguages = []string{"en", "de", "en"}
+	domains[0].SitemapLanguages = []string{"fr"}
+	mustUpdate(t, s, domains[0])
+	domains[1].TagsLanguages = []string{"en"}
+	domains[1].ContentLanguage = "en"
+	mustUpdate(t, s, domains[1])
+
+	domains[1].ContentLanguage = "english"
+	if err := repo.Update(ctx, domains[1]); err == nil {
+		t.Fatal("invalid language is saved")
+	}
+
+	domain, err := repo.FindByID(ctx, int(domains[0].ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(domain.TagsLanguages, []string{"DE", "EN"}) ||
+		!reflect.DeepEqual(domain.SitemapLanguages, []string{"FR"}) {
+		t.Fatalf("languages %v %v", domain.TagsLanguages, domain.SitemapLanguages)
+	}
+
+	found, err := repo.FindByTagLang(ctx, "EN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHosts(t, found, "https://a.example", "https://b.example")
+
+	found, err = repo.FindByTagLang(ctx, "e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHosts(t, found)
+
+	found, err = repo.FindBySMLang(ctx, "FR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHosts(t, found, "https://a.example")
+
+	found, err = repo.FindByContentLang(ctx, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHosts(t, found, "https://b.example")
+
+	counts, err := repo.CountByLang(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []model.LanguageCount{
+		{Lang: "EN", Source: model.LangSourceTags, Domains: 2},
+		{Lang: "DE", Source: model.LangSourceTags, Domains: 1},
+		{Lang: "EN", Source: model.LangSourceContent, Domains: 1},
+		{Lang: "FR", Source: model.LangSourceSitemap, Domains: 1},
+	}
+	if !reflect.DeepEqual(counts, want) {
+		t.Fatalf("language counts %+v, want %+v", counts, want)
+	}
+
+	// the languages are replaced on update
+	domains[0].TagsLanguages = nil
+	mustUpdate(t, s, domains[0])
+	found, err = repo.FindByTagLang(ctx, "DE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHosts(t, found)
+}
+
+func testDomainSearch(t *testing.T, s store.IStore) {
+	ctx := context.Background()
+	domains := mustCreate(t, s, "https://a.example.com", "https://b.example.org/path", "https://c.example.com")
+	earlier, later := time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour)
+
+	domains[0].ResponseCode = model.ResponseOk
+	domains[0].ContentLanguage = "en"
+	domains[0].TagsLanguages = []string{"en"}
+	domains[0].CheckedAt = &earlier
+	mustUpdate(t, s, domains[0])
+	domains[1].ResponseCode = model.ResponseError
+	domains[1].ErrorClass = model.ErrorClassTimeout
+	domains[1].ErrorCount = 2
+	domains[1].SitemapLanguages = []string{"de"}
+	domains[1].CheckedAt = &later
+	mustUpdate(t, s, domains[1])
+	domains[2].BlockerName = "cloudflare"
+	mustUpdate(t, s, domains[2])
+
+	a, b, c := domains[0].Host, domains[1].Host, domains[2].Host
+	tests := []struct {
+		name   string
+		filter model.DomainFilter
+		hosts  []string
+	}{
+		{"all", model.DomainFilter{}, []string{a, b, c}},
+		{"content language", model.DomainFilter{ContentLang: "en"}, []string{a}},
+		{"declared tags language", model.DomainFilter{DeclaredLang: "EN"}, []string{a}},
+		{"declared sitemap language", model.DomainFilter{DeclaredLang: "de"}, []string{b}},
+		{"response code", model.DomainFilter{ResponseCode: model.ResponseError}, []string{b}},
+		{"error class", model.DomainFilter{ErrorClass: model.ErrorClassTimeout}, []string{b}},
+		{"blocker", model.DomainFilter{BlockerName: "cloudflare"}, []string{c}},
+		{"tld", model.DomainFilter{TLD: "COM"}, []string{a, c}},
+		{"combined", model.DomainFilter{TLD: "com", ContentLang: "en"}, []string{a}},
+		{"combined without match", model.DomainFilter{TLD: "org", ContentLang: "en"}, nil},
+		{"checked from", model.DomainFilter{CheckedFrom: &later}, []string{b}},
+		{"checked to", model.DomainFilter{CheckedTo: &later}, []string{a}},
+		{"sort by check", model.DomainFilter{Sort: "checkedAt"}, []string{a, b, c}},
+		{"sort by check desc", model.DomainFilter{Sort: "-checkedAt"}, []string{b, a, c}},
+		{"sort by host desc", model.DomainFilter{Sort: "-host"}, []string{c, b, a}},
+		{"sort by errors desc", model.DomainFilter{Sort: "-errorCount"}, []string{b, c, a}},
+		{"sort by response code", model.DomainFilter{Sort: "responseCode"}, []string{c, b, a}},
+	}
+	for _, tc := range tests {
+		found, info, err := s.Domain().Search(ctx, tc.filter, model.Page{})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := hostsOf(found); len(got) != len(tc.hosts) || len(got) > 0 && !reflect.DeepEqual(got, tc.hosts) {
+			t.Fatalf("%s: hosts %v, want %v", tc.name, got, tc.hosts)
+		}
+		if info.Total != int64(len(tc.hosts)) {
+			t.Fatalf("%s: total %d", tc.name, info.Total)
+		}
+
+		// the pages of one domain make the same list
+		var paged []string
+		page := model.Page{Limit: 1}
+		for i := 0; i <= len(tc.hosts); i++ {
+			found, info, err = s.Domain().Search(ctx, tc.filter, page)
+			if err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+			paged = append(paged, hostsOf(found)...)
+			if page.Cursor = info.NextCursor; page.Cursor == "" {
+				break
+			}
+		}
+		if len(paged) != len(tc.hosts) || len(paged) > 0 && !reflect.DeepEqual(paged, tc.hosts) {
+			t.Fatalf("%s: paged hosts %v, want %v", tc.name, paged, tc.hosts)
+		}
+	}
+
+	found, _, err := s.Domain().Search(ctx, model.DomainFilter{TLD: "org"}, model.Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].TLD != "org" || !reflect.DeepEqual(found[0].SitemapLanguages, []string{"DE"}) {
+		t.Fatalf("found %+v", found)
+	}
+
+	if _, _, err = s.Domain().Search(ctx, model.DomainFilter{Sort: "ip"}, model.Page{}); err == nil {
+		t.Fatal("unknown sort key is accepted")
+	}
+
+	_, info, err := s.Domain().Search(ctx, model.DomainFilter{Sort: "host"}, model.Page{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = s.Domain().Search(ctx, model.DomainFilter{Sort: "-host"}, model.Page{Cursor: info.NextCursor})
+	if !errors.Is(err, model.ErrInvalidCursor) {
+		t.Fatalf("cursor of another sort: %v", err)
+	}
+}
+
+func testExport(t *testing.T, s store.IStore) {
+	ctx := context.Background()
+	list := model.CreateDomainsList([]string{"https://a.example.com", "https://b.example.org", "https://c.example.com"})
+	if err := s.AddDomains(ctx, &list); err != nil {
+		t.Fatal(err)
+	}
+	request, err := s.CreateRequest(ctx, model.RequestHeader{}, list[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkedAt := time.Now().Add(time.Second)
+	list[0].ContentLanguage = "en"
+	list[0].TagsLanguages = []string{"fr", "en"}
+	list[0].SitemapLanguages = []string{"de"}
+	mustUpdate(t, s, list[0])
+	list[1].ResponseCode = model.ResponseOk
+	list[1].CheckedAt = &checkedAt
+	if err = s.SaveDomain(ctx, list[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	exported := func(filter model.DomainFilter, code string) []model.Domain {
+		t.Helper()
+		var domains []model.Domain
+		err := s.Domain().Export(ctx, filter, code, func(domain model.Domain) error {
+			domains = append(domains, domain)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return domains
+	}
+
+	all := exported(model.DomainFilter{}, "")
+	assertHosts(t, all, hostsOf(list)...)
+	if all[0].ContentLanguage != "EN" || !reflect.DeepEqual(all[0].TagsLanguages, []string{"EN", "FR"}) ||
+		!reflect.DeepEqual(all[0].SitemapLanguages, []string{"DE"}) || all[0].TLD != "com" {
+		t.Fatalf("exported languages %+v", all[0])
+	}
+	if all[1].CheckedAt == nil || all[1].ResponseCode != model.ResponseOk || all[2].CheckedAt != nil {
+		t.Fatalf("exported crawls %+v %+v", all[1], all[2])
+	}
+	assertHosts(t, exported(model.DomainFilter{TLD: "com"}, ""), list[0].Host, list[2].Host)
+
+	// only the crawled domains of the request are ready
+	assertHosts(t, exported(model.DomainFilter{}, request.Code), list[1].Host)
+	assertHosts(t, exported(model.DomainFilter{TLD: "com"}, request.Code))
+
+	stop := errors.New("stop")
+	err = s.Domain().Export(ctx, model.DomainFilter{}, "", func(model.Domain) error { return stop })
+	if !errors.Is(err, stop) {
+		t.Fatalf("export error of the callback: %v", err)
+	}
+	err = s.Domain().Export(ctx, model.DomainFilter{}, "unknown", func(model.Domain) error { return nil })
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("export of unknown request: %v", err)
+	}
+}
+
+func testProxies(t *testing.T, s store.IStore) {
+	ctx := context.Background()
+	repo := s.Proxy()
+
+	err := repo.Create(ctx, []model.Proxy{
+		{IP: "10.0.0.1", Port: "8080", Scheme: model.HTTPS},
+		{IP: "not an ip", Port: "8080", Scheme: model.HTTPS},
+		{IP: "10.0.0.2", Port: "1080", Scheme: model.Socks5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.Create(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	list, _, err := repo.Read(ctx, model.Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].IP != "10.0.0.1" || list[1].IP != "10.0.0.2" {
+		t.Fatalf("proxies %+v", list)
+	}
+
+	first, info, err := repo.Read(ctx, model.Page{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, nextInfo, err := repo.Read(ctx, model.Page{Limit: 1, Cursor: info.NextCursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 1 || len(next) != 1 || first[0].ID != list[0].ID || next[0].ID != list[1].ID ||
+		info.Total != 2 || nextInfo.NextCursor != "" {
+		t.Fatalf("proxy pages %+v %+v, %+v %+v", first, info, next, nextInfo)
+	}
+
+	list[0].Port = "3128"
+	if err = repo.Update(ctx, list[:1]); err != nil {
+		t.Fatal(err)
+	}
+	invalid := list[1]
+	invalid.Port = "port"
+	if err = repo.Update(ctx, []model.Proxy{invalid}); err == nil {
+		t.Fatal("invalid proxy is saved")
+	}
+
+	if err = repo.Delete(ctx, []int{list[1].ID}); err != nil {
+		t.Fatal(err)
+	}
+	list, _, err = repo.Read(ctx, model.Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Port != "3128" {
+		t.Fatalf("proxies %+v", list)
+	}
+}
+
+func testAddDomains(t *testing.T, s store.IStore) {
+	ctx := context.Background()
+	done := mustCreate(t, s, "https://done.example")
+	done[0].ResponseCode = model.ResponseOk
+	mustUpdate(t, s, done[0])
+
+	list := model.CreateDomainsList([]string{"https://new.example", "https://done.example"})
+	if err := s.AddDomains(ctx, &list); err != nil {
+		t.Fatal(err)
+	}
+	assertHosts(t, list, "https://done.example", "https://new.example")
+	for _, domain := range list {
+		if domain.ID == 0 {
+			t.Fatalf("domain %s without id", domain.Host)
+		}
+	}
+
+	// processed domains are not queued again
+	domains, err := s.GetDomains(ctx, []string{"https://done.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHosts(t, domains, "https://done.example")
+
+	domains, err = s.GetDomains(ctx, []string{"https://done.example", "https://new.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if domains != nil {
+		t.Fatalf("queued domains are returned: %v", hostsOf(domains))
+	}
+	time.Sleep(10 * time.Millisecond)
+	assertDepth(t, s, model.LaneQueue, 1)
+}
+
+func testQueueLanes(t *testing.T, s store.IStore) {
+	ctx := context.Background()
+	now := time.Now()
+	domains := mustCreate(t, s,
+		"https://list1.example",
+		"https://list2.example",
+		"https://bad.example",
+		"https://bad-later.example",
+		"https://user.example",
+		"https://refresh.example",
+	)
+	list1, list2, bad, badLater, user, refresh := domains[0], domains[1], domains[2], domains[3], domains[4], domains[5]
+
+	bad.ResponseCode = model.ResponseError
+	mustUpdate(t, s, bad)
+	badLater.ResponseCode = model.ResponseError
+	mustUpdate(t, s, badLater)
+	refresh.ResponseCode = model.ResponseOk
+	mustUpdate(t, s, refresh)
+
+	mustQueue(t, s, now.Add(-2*time.Minute), list1)
+	mustQueue(t, s, now.Add(-3*time.Minute), list2)
+	mustQueue(t, s, now.Add(-time.Minute), bad)
+	mustQueue(t, s, now.Add(time.Hour), badLater)
+	// user requests do not wait for update time
+	mustQueue(t, s, now.Add(time.Hour), user)
+	if _, err := s.CreateRequest(ctx, model.RequestHeader{}, []model.Domain{user}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RefreshDomains(ctx, []model.Domain{refresh}, true); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	depth := map[model.QueueLane]int64{
+		model.LaneUserRequest: 1,
+		model.LaneQueue:       2,
+		model.LaneBadDomain:   1,
+		model.LaneRefresh:     1,
+	}
+	for lane, want := range depth {
+		assertDepth(t, s, lane, want)
+	}
+
+	leases := []struct {
+		lane model.QueueLane
+		host string
+	}{
+		{model.LaneUserRequest, user.Host},
+		{model.LaneUserRequest, ""},
+		{model.LaneQueue, list2.Host},
+		{model.LaneQueue, list1.Host},
+		{model.LaneQueue, ""},
+		{model.LaneBadDomain, bad.Host},
+		{model.LaneBadDomain, ""},
+		{model.LaneRefresh, refresh.Host},
+		{model.LaneRefresh, ""},
+	}
+	for _, tc := range leases {
+		assertLease(t, s, tc.lane, leaseTTL, tc.host)
+	}
+	for lane := range depth {
+		assertDepth(t, s, lane, 0)
+	}
+
+	if _, err := s.LeaseFromQueue(ctx, "unknown", "worker", leaseTTL); err == nil {
+		t.Fatal("unknown lane is leased")
+	}
+	if _, err := s.QueueDepth(ctx, "unknown"); err == nil {
+		t.Fatal("unknown lane is counted")
+	}
+}
+
+func testQueueLaneChanges(t *testing.T, s store.IStore) {
+	ctx := context.Background()
+	domains := mustCreate(t, s, "https://failed.example", "https://requested.example")
+	failed, requested := domains[0], domains[1]
+	mustQueue(t, s, time.Now().Add(-time.Minute), domains...)
+	assertDepth(t, s, model.LaneQueue, 2)
+
+	// the queued domain moves with its response code and its requests
+	failed.ResponseCode = model.ResponseError
+	mustUpdate(t, s, failed)
+	if _, err := s.CreateRequest(ctx, model.RequestHeader{}, []model.Domain{requested}); err != nil {
+		t.Fatal(err)
+	}
+	assertDepth(t, s, model.LaneQueue, 0)
+	assertDepth(t, s, model.LaneBadDomain, 1)
+	assertDepth(t, s, model.LaneUserRequest, 1)
+
+	if _, err := s.ExpireRequests(ctx, time.Now().Add(time.Second), 0); err != nil {
+		t.Fatal(err)
+	}
+	assertDepth(t, s, model.LaneUserRequest, 0)
+	assertLease(t, s, model.LaneQueue, leaseTTL, requested.Host)
+	assertLease(t, s, model.LaneBadDomain, leaseTTL, failed.Host)
+}
+
+func testQueueLease(t *testing.T, s store.IStore) {
+	ctx := context.Background()
+	domains := mustCreate(t, s, "https://a.example")
+	mustQueue(t, s, time.Now().Add(-time.Minute), domains...)
+
+	// expired lease is taken again
+	assertLease(t, s, model.LaneQueue, -time.Second, domains[0].Host)
+	assertLease(t, s, model.LaneQueue, leaseTTL, domains[0].Host)
+	assertLease(t, s, model.LaneQueue, leaseTTL, "")
+
+	// released domain waits for its update time
+	if err := s.ReturnToQueue(ctx, time.Now().Add(time.Hour), domains[0]); err != nil {
+		t.Fatal(err)
+	}
+	assertLease(t, s, model.LaneQueue, leaseTTL, "")
+	if err := s.ReturnToQueue(ctx, time.Now().Add(-time.Second), domains[0]); err != nil {
+		t.Fatal(err)
+	}
+	assertLease(t, s, model.LaneQueue, leaseTTL, domains[0].Host)
+
+	// acknowledged domain leaves the queue
+	if err := s.RemoveFromQueue(ctx, domains[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReturnToQueue(ctx, time.Now().Add(-time.Second), domains[0]); err != nil {
+		t.Fatal(err)
+	}
+	assertLease(t, s, model.LaneQueue, leaseTTL, "")
+
+	// queued domain is not added twice
+	mustQueue(t, s, time.Now().Add(-time.Minute), domains...)
+	mustQueue(t, s, time.Now().Add(-time.Minute), domains...)
+	mustQueue(t, s, time.Now())
+	assertDepth(t, s, model.LaneQueue, 1)
+
+	// the ack of the expired lease does not touch the domain leased by another worker
+	stale, err := s.LeaseFromQueue(ctx, model.LaneQueue, "stale", -time.Second)
+	if err != nil || stale == nil {
+		t.Fatalf("stale lease %v %v", stale, err)
+	}
+	current, err := s.LeaseFromQueue(ctx, model.LaneQueue, "current", leaseTTL)
+	if err != nil || current == nil {
+		t.Fatalf("current lease %v %v", current, err)
+	}
+	if err = s.ReturnToQueue(ctx, time.Now().Add(-time.Second), *stale); !errors.Is(err, model.ErrLeaseLost) {
+		t.Fatalf("return of stale lease: %v", err)
+	}
+	if err = s.RemoveFromQueue(ctx, *stale); !errors.Is(err, model.ErrLeaseLost) {
+		t.Fatalf("ack of stale lease: %v", err)
+	}
+	assertLease(t, s, model.LaneQueue, leaseTTL, "")
+	if err = s.RemoveFromQueue(ctx, *current); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.ReturnToQueue(ctx, time.Now().Add(-time.Second), *stale); !errors.Is(err, model.ErrLeaseLost) {
+		t.Fatalf("return of acknowledged domain: %v", err)
+	}
+	assertDepth(t, s, model.LaneQueue, 0)
+}
+
+func testNextQueueUpdate(t *testing.T, s store.IStore) {
+	ctx := context.Background()
+	next, err := s.NextQueueUpdate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !next.IsZero() {
+		t.Fatalf("empty queue is due at %s", next)
+	}
+
+	domains := mustCreate(t, s, "https://a.example", "https://b.example")
+	now := time.Now()
+	mustQueue(t, s, now.Add(time.Hour), domains[0])
+	mustQueue(t, s, now.Add(-time.Minute), domains[1])
+	next, err = s.NextQueueUpdate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTime(t, next, now.Add(-time.Minute))
+
+	// leased domain is due when its lease expires
+	domain, err := s.LeaseFromQueue(ctx, model.LaneQueue, "worker", 30*time.Minute)
+	if err != nil || domain == nil {
+		t.Fatalf("lease %v %s", domain, err)
+	}
+	next, err = s.NextQueueUpdate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Before(now.Add(30*time.Minute)) || next.After(time.Now().Add(30*time.Minute)) {
+		t.Fatalf("next update %s, want the lease end", next)
+	}
+}
+
+func testRefresh(t *testing.T, s store.IStore) {
+	ctx := context.Background()
+	domains := mustCreate(t, s, "https://a.example", "https://b.example")
+	for i := range domains {
+		domains[i].ResponseCode = model.ResponseOk
+		mustUpdate(t, s, domains[i])
+	}
+
+	// background refresh does not lower the priority of the user request
+	if err := s.RefreshDomains(ctx, domains[:1], false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateRequest(ctx, model.RequestHeader{}, domains[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RefreshDomains(ctx, domains, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RefreshDomains(ctx, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	assertDepth(t, s, model.LaneUserRequest, 1)
+	assertDepth(t, s, model.LaneRefresh, 1)
+	assertLease(t, s, model.LaneRefresh, leaseTTL, "https://b.example")
+}
+
+func testRequeueStale(t *testing.T, s store.IStore) {
+	ctx := context.Background()
+	domains := mustCreate(t, s,
+		"https://default.example",
+		"https://group.example",
+		"https://own.example",
+		"https://fresh.example",
+		"https://failed.example",
+	)
+	checked := func(age time.Duration) *time.Time {
+		at := time.Now().Add(-age)
+		return &at
+	}
+
+	domains[0].CheckedAt = checked(2 * time.Hour)
+	domains[1].CheckedAt = checked(30 * time.Minute)
+	domains[1].Group = "news"
+	domains[2].CheckedAt = checked(30 * time.Minute)
+	domains[2].Group = "news"
+	domains[2].MaxAge = int64(time.Hour.Seconds())
+	domains[3].CheckedAt = checked(time.Minute)
+	domains[4].CheckedAt = checked(2 * time.Hour)
+	for i := range domains {
+		domains[i].ResponseCode = model.ResponseOk
+	}
+	domains[4].ResponseCode = model.ResponseError
+	for _, domain := range domains {
+		mustUpdate(t, s, domain)
+	}
+
+	groups := map[string]time.Duration{"news": 10 * time.Minute}
+	cnt, err := s.RequeueStale(ctx, time.Hour, groups, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cnt != 2 {
+		t.Fatalf("requeued %d, want 2", cnt)
+	}
+
+	// queued domains are not requeued
+	if cnt, err = s.RequeueStale(ctx, time.Hour, groups, 1000); err != nil || cnt != 0 {
+		t.Fatalf("requeued %d %v, want 0", cnt, err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	assertDepth(t, s, model.LaneRefresh, 2)
+	assertLease(t, s, model.LaneRefresh, leaseTTL, "https://default.example")
+	assertLease(t, s, model.LaneRefresh, leaseTTL, "https://group.example")
+}
+
+func testRequests(t *testing.T, s store.IStore) {
+	ctx := context.Background()
+	list := model.CreateDomainsList([]string{"https://b.example", "https://a.example"})
+	if err := s.AddDomains(ctx, &list); err != nil {
+		t.Fatal(err)
+	}
+
+	request, err := s.CreateRequest(ctx, model.RequestHeader{Owner: "acme"}, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Code == "" || request.Owner != "acme" || request.Status != model.RequestPending || request.Hosts != 2 {
+		t.Fatalf("request %+v", request)
+	}
+
+	// the same hosts make another request
+	again, err := s.CreateRequest(ctx, model.RequestHeader{Owner: "other"}, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Code == request.Code {
+		t.Fatalf("request code %s is reused", again.Code)
+	}
+
+	header, err := s.GetRequestHeader(ctx, request.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Code != request.Code || header.Owner != "acme" || header.Status != model.RequestPending || header.Hosts != 2 {
+		t.Fatalf("request header %+v", header)
+	}
+	if _, err = s.GetRequestHeader(ctx, "unknown"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("unknown request: %v", err)
+	}
+	if _, _, err = s.GetRequest(ctx, "unknown", model.Page{}); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("unknown request: %v", err)
+	}
+	if _, err = s.GetRequestProgress(ctx, "unknown"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("unknown request progress: %v", err)
+	}
+
+	assertProgress := func(want model.RequestProgress) {
+		t.Helper()
+		progress, err := s.GetRequestProgress(ctx, request.Code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *progress != want {
+			t.Fatalf("progress %+v, want %+v", *progress, want)
+		}
+	}
+	assertProgress(model.RequestProgress{Total: 2, Queued: 2})
+	domains, info, err := s.GetRequest(ctx, request.Code, model.Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHosts(t, domains)
+	if info.Total != 0 {
+		t.Fatalf("ready domains %d", info.Total)
+	}
+
+	// the failed domain stays queued for the retry but it does not hold the request
+	checkedAt := time.Now().Add(time.Second)
+	crawled := []string{model.ResponseError, model.ResponseOk}
+	for i, domain := range list {
+		domain.ResponseCode = crawled[i]
+		domain.CheckedAt = &checkedAt
+		if err = s.SaveDomain(ctx, domain); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertProgress(model.RequestProgress{Total: 2, Done: 1, Failed: 1})
+	if err = s.RemoveFromQueue(ctx, list[1]); err != nil {
+		t.Fatal(err)
+	}
+	assertProgress(model.RequestProgress{Total: 2, Done: 1, Failed: 1})
+
+	first, info, err := s.GetRequest(ctx, request.Code, model.Page{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, lastInfo, err := s.GetRequest(ctx, request.Code, model.Page{Limit: 1, Cursor: info.NextCursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHosts(t, append(first, last...), "https://b.example", "https://a.example")
+	if info.Total != 2 || lastInfo.NextCursor != "" {
+		t.Fatalf("request pages %+v %+v", info, lastInfo)
+	}
+	if _, _, err = s.GetRequest(ctx, request.Code, model.Page{Cursor: "broken"}); !errors.Is(err, model.ErrInvalidCursor) {
+		t.Fatalf("invalid cursor: %v", err)
+	}
+	if err = s.RemoveFromQueue(ctx, list[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// the request of the crawled domains is done at once
+	done, err := s.CreateRequest(ctx, model.RequestHeader{}, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done.Status != model.RequestDone {
+		t.Fatalf("request of crawled domains %+v", done)
+	}
+}
+
+func testCallbacks(t *testing.T, s store.IStore) {
+	ctx := context.Background()
+	domains := mustCreate(t, s, "https://a.example")
+
+	// the callbacks of the same hosts do not overwrite each other
+	callbacks := []string{"https://first.example/done", "https://second.example/done", ""}
+	codes := make([]string, len(callbacks))
+	for i, callback := range callbacks {
+		request, err := s.CreateRequest(ctx, model.RequestHeader{Callback: callback}, domains)
+		if err != nil {
+			t.Fatal(err)
+		}
+		codes[i] = request.Code
+	}
+	for i, code := range codes {
+		request, err := s.GetRequestHeader(ctx, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if request.Callback != callbacks[i] {
+			t.Fatalf("callback %q, want %q", request.Callback, callbacks[i])
+		}
+	}
+}
+
+func testCompletedRequests(t *testing.T, s store.IStore) {
+	ctx := context.Background()
+	list := model.CreateDomainsList([]string{"https://a.example", "https://b.example"})
+	if err := s.AddDomains(ctx, &list); err != nil {
+		t.Fatal(err)
+	}
+	a, b := list[0], list[1]
+
+	request, err := s.CreateRequest(ctx, model.RequestHeader{}, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	both := request.Code
+	if request, err = s.CreateRequest(ctx, model.RequestHeader{}, []model.Domain{a}); err != nil {
+		t.Fatal(err)
+	}
+	single := request.Code
+
+	completed := func(domain model.Domain, want ...string) {
+		t.Helper()
+		requests, err := s.GetCompletedRequests(ctx, domain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(requests) != len(want) {
+			t.Fatalf("completed %+v, want %v", requests, want)
+		}
+		set := make(map[string]bool)
+		for _, request := range requests {
+			set[request.Code] = true
+		}
+		for _, code := range want {
+			if !set[code] {
+				t.Fatalf("completed %+v, want %v", requests, want)
+			}
+		}
+	}
+
+	completed(a)
+	if err = s.RemoveFromQueue(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	completed(a, single)
+	if err = s.RemoveFromQueue(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+	completed(a, single, both)
+	completed(b, both)
+
+	// only one of the concurrent crawlers finishes the request
+	for i, want := range []bool{true, false} {
+		finished, err := s.FinishRequest(ctx, both, model.RequestDone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if finished != want {
+			t.Fatalf("finish %d: %v, want %v", i, finished, want)
+		}
+	}
+	completed(a, single)
+	completed(b)
+	header, err := s.GetRequestHeader(ctx, both)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Status != model.RequestDone {
+		t.Fatalf("finished request %+v", header)
+	}
+}
+
+func testExpiredRequests(t *testing.T, s store.IStore) {
+	ctx := context.Background()
+	domains := mustCreate(t, s, "https://a.example")
+
+	codes := make([]string, 3)
+	var mid time.Time
+	for i := range codes {
+		request, err := s.CreateRequest(ctx, model.RequestHeader{Callback: "https://cb.example"}, domains)
+		if err != nil {
+			t.Fatal(err)
+		}
+		codes[i] = request.Code
+		time.Sleep(20 * time.Millisecond)
+		if i == 1 {
+			mid = time.Now()
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	// the oldest requests are expired first
+	for i, want := range []int64{1, 1, 0} {
+		cnt, err := s.ExpireRequests(ctx, mid, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cnt != want {
+			t.Fatalf("expire %d: %d, want %d", i, cnt, want)
+		}
+	}
+	for i, code := range codes {
+		request, err := s.GetRequestHeader(ctx, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expired := i < 2
+		if (request.Status == model.RequestExpired) != expired || (request.Callback == "") != expired {
+			t.Fatalf("request %d %+v", i, request)
+		}
+		progress, err := s.GetRequestProgress(ctx, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (progress.Total == 0) != expired {
+			t.Fatalf("request %d progress %+v", i, progress)
+		}
+	}
+
+	if cnt, err := s.DeleteExpiredRequests(ctx, time.Now(), 0); err != nil || cnt != 2 {
+		t.Fatalf("deleted %d %v", cnt, err)
+	}
+	if _, err := s.GetRequestHeader(ctx, codes[0]); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("deleted request: %v", err)
+	}
+	if _, err := s.GetRequestHeader(ctx, codes[2]); err != nil {
+		t.Fatalf("live request: %v", err)
+	}
+}
+
+func testStopRequests(t *testing.T, s store.IStore) {
+	ctx := context.Background()
+	list := model.CreateDomainsList([]string{"https://a.example", "https://b.example", "https://c.example"})
+	if err := s.AddDomains(ctx, &list); err != nil {
+		t.Fatal(err)
+	}
+	request, err := s.CreateRequest(ctx, model.RequestHeader{}, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := s.CreateRequest(ctx, model.RequestHeader{}, list[1:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertLease(t, s, model.LaneUserRequest, time.Minute, "https://a.example")
+
+	// the leased domain is crawled and the other request still needs b
+	removed, err := s.StopRequest(ctx, request.Code, model.RequestCancelled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Fatalf("removed %d domains, want 1", removed)
+	}
+	assertDepth(t, s, model.LaneUserRequest, 1)
+	header, err := s.GetRequestHeader(ctx, request.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Status != model.RequestCancelled {
+		t.Fatalf("cancelled request %+v", header)
+	}
+	progress, err := s.GetRequestProgress(ctx, request.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (model.RequestProgress{Total: 3, Queued: 2, Skipped: 1}); *progress != want {
+		t.Fatalf("cancelled request progress %+v, want %+v", *progress, want)
+	}
+	if _, err = s.StopRequest(ctx, request.Code, model.RequestCancelled); !errors.Is(err, model.ErrRequestFinished) {
+		t.Fatalf("stop of cancelled request: %v", err)
+	}
+	if _, err = s.StopRequest(ctx, "unknown", model.RequestCancelled); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("stop of unknown request: %v", err)
+	}
+
+	// b is removed when no pending request needs it
+	if removed, err = s.StopRequest(ctx, other.Code, model.RequestPartial); err != nil || removed != 1 {
+		t.Fatalf("removed %d domains %v", removed, err)
+	}
+	assertDepth(t, s, model.LaneUserRequest, 0)
+
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	overdue, err := s.CreateRequest(ctx, model.RequestHeader{Deadline: &past}, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.CreateRequest(ctx, model.RequestHeader{Deadline: &future}, list); err != nil {
+		t.Fatal(err)
+	}
+	requests, err := s.GetOverdueRequests(ctx, time.Now(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 || requests[0].Code != overdue.Code || requests[0].Deadline == nil {
+		t.Fatalf("overdue requests %+v", requests)
+	}
+	assertTime(t, *requests[0].Deadline, past)
+}
+
+// batchesOf returns the next func of IngestRequest over the batches
+func batchesOf(batches ...[]string) func() ([]string, error) {
+	return func() ([]string, error) {
+		if len(batches) == 0 {
+			return nil, io.EOF
+		}
+		batch := batches[0]
+		batches = batches[1:]
+		return batch, nil
+	}
+}
+
+func testIngestRequests(t *testing.T, s store.IStore) {
+	ctx := context.Background()
+	crawled := mustCreate(t, s, "https://crawled.example")[0]
+	crawled.ResponseCode = model.ResponseOk
+	if err := s.SaveDomain(ctx, crawled); err != nil {
+		t.Fatal(err)
+	}
+
+	request, err := s.CreateRequest(ctx, model.RequestHeader{Status: model.RequestIngesting}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Status != model.RequestIngesting || request.Hosts != 0 {
+		t.Fatalf("ingesting request %+v", request)
+	}
+
+	added, err := s.IngestRequest(ctx, request.Code, nil, batchesOf(
+		[]string{"https://a.example", "https://b.example", "https://a.example"},
+		[]string{"https://b.example", "https://crawled.example"},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 3 {
+		t.Fatalf("added %d hosts, want 3", added)
+	}
+	header, err := s.GetRequestHeader(ctx, request.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Status != model.RequestIngesting || header.Hosts != 3 {
+		t.Fatalf("ingested request %+v", header)
+	}
+	progress, err := s.GetRequestProgress(ctx, request.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (model.RequestProgress{Total: 3, Done: 1, Queued: 2}); *progress != want {
+		t.Fatalf("progress %+v, want %+v", *progress, want)
+	}
+	assertDepth(t, s, model.LaneUserRequest, 2)
+
+	// the ingesting request is not completed by the crawls
+	domains, err := s.Domain().FindByHost(ctx, "https://a.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if completed, err := s.GetCompletedRequests(ctx, domains[0]); err != nil || len(completed) != 0 {
+		t.Fatalf("completed %+v %v", completed, err)
+	}
+
+	status, err := s.FinishIngest(ctx, request.Code, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header, err = s.GetRequestHeader(ctx, request.Code); err != nil {
+		t.Fatal(err)
+	}
+	if status != model.RequestPending || header.Status != model.RequestPending || header.OverBudget != 2 {
+		t.Fatalf("finished ingest %s %+v", status, header)
+	}
+	if _, err = s.FinishIngest(ctx, request.Code, 0); !errors.Is(err, model.ErrRequestFinished) {
+		t.Fatalf("finish of pending request: %v", err)
+	}
+
+	// the cancelled upload stops at the next batch
+	cancelled, err := s.CreateRequest(ctx, model.RequestHeader{Status: model.RequestIngesting}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.StopRequest(ctx, cancelled.Code, model.RequestCancelled); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.IngestRequest(ctx, cancelled.Code, nil, batchesOf([]string{"https://c.example"}))
+	if !errors.Is(err, model.ErrRequestFinished) {
+		t.Fatalf("ingest of cancelled request: %v", err)
+	}
+
+	// the upload of crawled hosts is done at once
+	done, err := s.CreateRequest(ctx, model.RequestHeader{Status: model.RequestIngesting}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.IngestRequest(ctx, done.Code, nil, batchesOf([]string{"https://crawled.example"})); err != nil {
+		t.Fatal(err)
+	}
+	if status, err = s.FinishIngest(ctx, done.Code, 0); err != nil || status != model.RequestDone {
+		t.Fatalf("finished ingest of crawled hosts %s %v", status, err)
+	}
+
+	// next may use the store between the batches, which are charged to the quota of the key
+	key, _, err := model.NewAPIKey("acme", model.ScopeSubmit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.DailyHosts = 4
+	if err = s.APIKey().Create(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	counted, err := s.CreateRequest(ctx, model.RequestHeader{Status: model.RequestIngesting}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	batches := batchesOf([]string{"https://d.example", "https://e.example", "https://d.example"}, []string{"https://f.example"})
+	var used []int
+	next := func() ([]string, error) {
+		hosts, err := s.APIKey().Usage(timeout, key.ID, model.UsageDay(time.Now()))
+		if err != nil {
+			return nil, err
+		}
+		used = append(used, hosts)
+		return batches()
+	}
+	if _, err = s.IngestRequest(timeout, counted.Code, key, next); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{0, 2, 3}; !reflect.DeepEqual(used, want) {
+		t.Fatalf("usage seen by next %v, want %v", used, want)
+	}
+
+	// the batch over the quota is not added
+	added, err = s.IngestRequest(ctx, counted.Code, key, batchesOf([]string{"https://g.example"}, []string{"https://h.example", "https://i.example"}))
+	if !errors.Is(err, store.ErrQuotaExceeded) || added != 1 {
+		t.Fatalf("ingest over the quota added %d: %v", added, err)
+	}
+	if hosts, err := s.APIKey().Usage(ctx, key.ID, model.UsageDay(time.Now())); err != nil || hosts != 4 {
+		t.Fatalf("usage %d after the quota: %v", hosts, err)
+	}
+	if found, err := s.Domain().FindByHost(ctx, "https://h.example", "https://i.example"); err != nil || len(found) != 0 {
+		t.Fatalf("hosts over the quota %v: %v", found, err)
+	}
+}
+
+func testHistory(t *testing.T, s store.IStore) {
+	ctx := context.Background()
+	domains := mustCreate(t, s, "https://a.example", "https://b.example")
+
+	domain := domains[0]
+	for i, code := range []string{model.ResponseError, model.ResponseOk} {
+		checkedAt := time.Now().Add(time.Duration(i-2) * time.Minute)
+		domain.CheckedAt = &checkedAt
+		domain.ResponseCode = code
+		domain.TagsLanguages = []string{"en"}
+		if err := s.SaveCrawlResult(ctx, domain, model.NewDomainHistory(domain)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	saved, err := s.Domain().FindByID(ctx, int(domain.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.ResponseCode != model.ResponseOk {
+		t.Fatalf("saved code %q", saved.ResponseCode)
+	}
+
+	history, info, err := s.GetHistory(ctx, domain.ID, model.Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || info.Total != 2 || history[0].ResponseCode != model.ResponseOk || history[1].ResponseCode != model.ResponseError {
+		t.Fatalf("history %+v", history)
+	}
+	if !reflect.DeepEqual(history[0].TagsLanguages, []string{"EN"}) {
+		t.Fatalf("history languages %v", history[0].TagsLanguages)
+	}
+
+	latest, info, err := s.GetHistory(ctx, domain.ID, model.Page{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, lastInfo, err := s.GetHistory(ctx, domain.ID, model.Page{Limit: 1, Cursor: info.NextCursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(latest) != 1 || latest[0].ID != history[0].ID || len(last) != 1 || last[0].ID != history[1].ID || lastInfo.NextCursor != "" {
+		t.Fatalf("history pages %+v %+v", latest, last)
+	}
+
+	record, err := s.GetHistoryRecord(ctx, domain.ID, history[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.ResponseCode != model.ResponseError {
+		t.Fatalf("history record %+v", record)
+	}
+	if _, err = s.GetHistoryRecord(ctx, domains[1].ID, history[1].ID); err == nil {
+		t.Fatal("history record of another domain is found")
+	}
+}
+
+func testWorkers(t *testing.T, s store.IStore) {
+	ctx := context.Background()
+	startedAt := time.Now().Add(-time.Hour)
+	for _, worker := range []model.Worker{
+		{ID: "a", Threads: 10, StartedAt: startedAt, HeartbeatAt: time.Now()},
+		{ID: "b", Threads: 10, StartedAt: startedAt, HeartbeatAt: time.Now().Add(-time.Hour)},
+		{ID: "a", Threads: 10, Active: 3, StartedAt: time.Now(), HeartbeatAt: time.Now()},
+	} {
+		if err := s.Heartbeat(ctx, worker); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	workers, err := s.GetWorkers(ctx, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(workers) != 1 || workers[0].ID != "a" || workers[0].Active != 3 {
+		t.Fatalf("workers %+v", workers)
+	}
+	assertTime(t, workers[0].StartedAt, startedAt)
+
+	if err = s.RemoveWorker(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if workers, err = s.GetWorkers(ctx, time.Time{}); err != nil || len(workers) != 1 {
+		t.Fatalf("workers %+v %v", workers, err)
+	}
+}
+
+func testAPIKeys(t *testing.T, s store.IStore) {
+	ctx := context.Background()
+	if err := s.APIKey().Create(ctx, &model.APIKey{Tenant: "acme", Scope: "owner"}); err == nil {
+		t.Fatal("key with unknown scope is created")
+	}
+
+	key, secret, err := model.NewAPIKey("acme", model.ScopeSubmit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.DailyHosts = 10
+	if err = s.APIKey().Create(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := model.NewAPIKey("other", model.ScopeRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.APIKey().Create(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := s.APIKey().FindByHash(ctx, model.HashAPIKey(secret))
+	if err != nil || found.ID != key.ID || found.Tenant != "acme" || found.Scope != model.ScopeSubmit || found.DailyHosts != 10 {
+		t.Fatalf("found %+v: %v", found, err)
+	}
+	if _, err = s.APIKey().FindByHash(ctx, model.HashAPIKey("lp_unknown")); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("unknown key: %v", err)
+	}
+
+	day := model.UsageDay(time.Now())
+	for _, tc := range []struct {
+		hosts int
+		ok    bool
+	}{{4, true}, {6, true}, {1, false}, {11, false}} {
+		ok, err := s.APIKey().AddUsage(ctx, key.ID, day, tc.hosts, key.DailyHosts)
+		if err != nil || ok != tc.ok {
+			t.Fatalf("add %d hosts: %v %v, want %v", tc.hosts, ok, err, tc.ok)
+		}
+	}
+	if ok, err := s.APIKey().AddUsage(ctx, key.ID, "2000-01-01", 10, key.DailyHosts); err != nil || !ok {
+		t.Fatalf("other day: %v %v", ok, err)
+	}
+	if ok, err := s.APIKey().AddUsage(ctx, other.ID, day, 100, 0); err != nil || !ok {
+		t.Fatalf("unlimited: %v %v", ok, err)
+	}
+	if used, err := s.APIKey().Usage(ctx, key.ID, day); err != nil || used != 10 {
+		t.Fatalf("usage %d: %v", used, err)
+	}
+
+	if err = s.APIKey().Revoke(ctx, key.ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.APIKey().FindByHash(ctx, model.HashAPIKey(secret)); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("revoked key: %v", err)
+	}
+	if err = s.APIKey().Revoke(ctx, 999, time.Now()); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("unknown key revoked: %v", err)
+	}
+	keys, err := s.APIKey().Read(ctx)
+	if err != nil || len(keys) != 2 || keys[0].ID != key.ID || keys[0].RevokedAt == nil || keys[1].RevokedAt != nil {
+		t.Fatalf("keys %+v: %v", keys, err)
+	}
+}
+
+func testDeleteDomains(t *testing.T, s store.IStore) {
+	ctx := context.Background()
+	list := model.CreateDomainsList([]string{"https://a.example", "https://b.example", "https://c.example"})
+	if err := s.AddDomains(ctx, &list); err != nil {
+		t.Fatal(err)
+	}
+	a, b, c := list[0], list[1], list[2]
+	first, err := s.CreateRequest(ctx, model.RequestHeader{}, []model.Domain{a, b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.CreateRequest(ctx, model.RequestHeader{}, []model.Domain{c})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	a.ResponseCode = model.ResponseOk
+	a.CheckedAt = &now
+	if err = s.SaveCrawlResult(ctx, a, model.NewDomainHistory(a)); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.RemoveFromQueue(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+
+	codes := func(requests []model.RequestHeader) []string {
+		list := make([]string, len(requests))
+		for i, request := range requests {
+			list[i] = request.Code
+		}
+		sort.Strings(list)
+		return list
+	}
+
+	// the first request waits only for b, it is completed by the deletion
+	completed, err := s.DeleteDomains(ctx, []uint{b.ID}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := codes(completed); !reflect.DeepEqual(got, []string{first.Code}) {
+		t.Fatalf("completed %v, want %s", got, first.Code)
+	}
+	header, err := s.GetRequestHeader(ctx, first.Code)
+	if err != nil || header.Hosts != 1 {
+		t.Fatalf("request %+v: %v", header, err)
+	}
+	progress, err := s.GetRequestProgress(ctx, first.Code)
+	if err != nil || progress.Total != 1 || progress.Done != 1 {
+		t.Fatalf("progress %+v: %v", progress, err)
+	}
+	if _, err = s.Domain().FindByID(ctx, int(b.ID)); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("soft deleted domain is found: %v", err)
+	}
+
+	// the soft deleted domain is restored with its id
+	again := model.CreateDomainsList([]string{"https://b.example"})
+	if err = s.AddDomains(ctx, &again); err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != 1 || again[0].ID != b.ID {
+		t.Fatalf("restored %+v, want id %d", again, b.ID)
+	}
+
+	completed, err = s.DeleteDomains(ctx, []uint{a.ID, c.ID}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{first.Code, second.Code}
+	sort.Strings(want)
+	if got := codes(completed); !reflect.DeepEqual(got, want) {
+		t.Fatalf("completed %v, want %v", got, want)
+	}
+	if header, err = s.GetRequestHeader(ctx, second.Code); err != nil || header.Hosts != 0 {
+		t.Fatalf("request %+v: %v", header, err)
+	}
+	history, _, err := s.GetHistory(ctx, a.ID, model.Page{})
+	if err != nil || len(history) != 0 {
+		t.Fatalf("history of the deleted domain %+v: %v", history, err)
+	}
+	domains, _, err := s.Domain().Read(ctx, model.Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHosts(t, domains, "https://b.example")
+
+	// the hard deleted host is a new domain
+	again = model.CreateDomainsList([]string{"https://a.example"})
+	if err = s.AddDomains(ctx, &again); err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != 1 || again[0].ID == a.ID || again[0].ResponseCode != "" {
+		t.Fatalf("added again %+v", again)
+	}
+}
+
+func testConflicts(t *testing.T, s store.IStore) {
+	ctx := context.Background()
+	domains := mustCreate(t, s, "https://a.example", "https://b.example")
+
+	domain := domains[1]
+	domain.Host = "https://a.example"
+	if err := s.Domain().Update(ctx, domain); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("update to the taken host: %v", err)
+	}
+	found, err := s.Domain().FindByID(ctx, int(domains[1].ID))
+	if err != nil || found.Host != "https://b.example" {
+		t.Fatalf("domain %+v after the conflict: %v", found, err)
+	}
+
+	if err = s.Watchlist().Create(ctx, &model.Watchlist{Name: "news", Interval: 60}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Watchlist().Create(ctx, &model.Watchlist{Name: "news", Interval: 60}); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("watchlist with the taken name: %v", err)
+	}
+
+	key, _, err := model.NewAPIKey("acme", model.ScopeRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	same := *key
+	if err = s.APIKey().Create(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.APIKey().Create(ctx, &same); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("api key with the taken hash: %v", err)
+	}
+}